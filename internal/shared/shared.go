@@ -9,6 +9,9 @@ import (
 type Options struct {
 	ListenAddress   string
 	ProfilerEnabled bool
+	// Check health of the running server and exit (for container
+	// healthchecks).
+	HealthCheck bool
 }
 
 func InitializeOptions() Options {
@@ -21,9 +24,12 @@ func InitializeOptions() Options {
 
 	profilerEnabled := flag.Bool("profile", false, "Enable profiler web-server on port 6060")
 
+	healthCheck := flag.Bool("healthcheck", false, "Check health of the running server and exit")
+
 	flag.Parse()
 
 	options.ProfilerEnabled = *profilerEnabled
+	options.HealthCheck = *healthCheck
 
 	return options
 }
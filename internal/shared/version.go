@@ -0,0 +1,5 @@
+package shared
+
+// Build version of robocat, set at build time with
+// -ldflags "-X github.com/robocat-ai/robocat/internal/shared.Version=..."
+var Version = "dev"
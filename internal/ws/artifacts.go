@@ -0,0 +1,287 @@
+package ws
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Refs are used as directory names of artifacts and isolated runs, so runs
+// with refs containing other characters are not retained (and cannot be
+// isolated).
+var artifactRefPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]{0,127}$`)
+
+// Output file retained after the run.
+type Artifact struct {
+	Path     string `json:"path"`
+	MimeType string `json:"type"`
+	Size     int64  `json:"size"`
+	// Hex-encoded SHA-256 checksum of the file.
+	SHA256 string `json:"sha256"`
+	// Time the file was written (the latest version is retained only).
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Manifest of the files retained after the run.
+type ArtifactManifest struct {
+	Ref  string `json:"ref"`
+	Flow string `json:"flow"`
+	// Client that has started the run (empty if unknown).
+	Principal string     `json:"principal,omitempty"`
+	UpdatedAt time.Time  `json:"updatedAt"`
+	Files     []Artifact `json:"files"`
+}
+
+// Body of "artifacts.list" command.
+type ArtifactsListArguments struct {
+	Ref string `json:"ref"`
+}
+
+// Body of "artifacts.get" command. Artifact is sent in parts of
+// TRANSFER_CHUNK_SIZE starting at the offset.
+type ArtifactGetArguments struct {
+	Ref    string `json:"ref"`
+	Path   string `json:"path"`
+	Offset int64  `json:"offset,omitempty"`
+}
+
+// Body of "artifact" update with a part of the artifact.
+type ArtifactPart struct {
+	Artifact
+	Offset  int64  `json:"offset"`
+	Payload []byte `json:"payload"`
+}
+
+// Output files of runs retained on disk - every run has its own directory
+// with "manifest.json" and the files themselves in "files" directory.
+type artifactStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newArtifactStore(path string) *artifactStore {
+	return &artifactStore{path: path}
+}
+
+func (a *artifactStore) runPath(ref string, elem ...string) string {
+	return filepath.Join(append([]string{a.path, ref}, elem...)...)
+}
+
+// Write the file of the run and add it to the manifest replacing the
+// previous version of the file.
+func (a *artifactStore) add(
+	ref string,
+	flow string,
+	owner string,
+	artifact Artifact,
+	payload io.Reader,
+) error {
+	if !artifactRefPattern.MatchString(ref) {
+		return errors.New("ref cannot be used as directory name")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	filePath := a.runPath(ref, "files", filepath.FromSlash(artifact.Path))
+
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	err = writeFileAtomicFrom(filePath, payload)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := a.readManifest(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		manifest = &ArtifactManifest{Ref: ref, Flow: flow, Principal: owner, Files: make([]Artifact, 0)}
+	} else if err != nil {
+		return err
+	}
+
+	manifest.UpdatedAt = artifact.CreatedAt
+	manifest.Files = replaceArtifact(manifest.Files, artifact)
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(a.runPath(ref, "manifest.json"), data)
+}
+
+func replaceArtifact(files []Artifact, artifact Artifact) []Artifact {
+	for i := range files {
+		if files[i].Path == artifact.Path {
+			files[i] = artifact
+			return files
+		}
+	}
+
+	return append(files, artifact)
+}
+
+func (a *artifactStore) readManifest(ref string) (*ArtifactManifest, error) {
+	if !artifactRefPattern.MatchString(ref) {
+		return nil, os.ErrNotExist
+	}
+
+	data, err := os.ReadFile(a.runPath(ref, "manifest.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest *ArtifactManifest
+
+	err = json.Unmarshal(data, &manifest)
+	if err == nil && manifest == nil {
+		err = errors.New("manifest is empty")
+	}
+
+	return manifest, err
+}
+
+// Manifest of the run (nil if nothing is retained for the run).
+func (a *artifactStore) manifest(ref string) (*ArtifactManifest, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	manifest, err := a.readManifest(ref)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	return manifest, err
+}
+
+// Read part of the artifact starting at the offset.
+func (a *artifactStore) read(ref string, artifact Artifact, offset int64, size int) ([]byte, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file, err := os.Open(a.runPath(ref, "files", filepath.FromSlash(artifact.Path)))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if remaining := artifact.Size - offset; remaining < int64(size) {
+		size = int(remaining)
+	}
+
+	payload := make([]byte, size)
+
+	_, err = file.ReadAt(payload, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return payload, nil
+}
+
+// Remove artifacts of runs not updated within the TTL, then remove the
+// oldest ones until total size of artifacts fits into maxSize. Artifacts of
+// runs for which keep returns true are never removed.
+func (a *artifactStore) collect(ttl time.Duration, maxSize int64, keep func(ref string) bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	dirs, err := os.ReadDir(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	type retained struct {
+		ref       string
+		updatedAt time.Time
+		size      int64
+	}
+
+	var runs []retained
+	var total int64
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || keep(dir.Name()) {
+			continue
+		}
+
+		run := retained{ref: dir.Name()}
+
+		if manifest, err := a.readManifest(run.ref); err == nil {
+			run.updatedAt = manifest.UpdatedAt
+			for _, file := range manifest.Files {
+				run.size += file.Size
+			}
+		} else if info, err := dir.Info(); err == nil {
+			// Manifest is missing - the run was interrupted while
+			// retaining its first file.
+			run.updatedAt = info.ModTime()
+		}
+
+		runs = append(runs, run)
+		total += run.size
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].updatedAt.Before(runs[j].updatedAt)
+	})
+
+	expiredAt := time.Now().Add(-ttl)
+
+	for _, run := range runs {
+		if !run.updatedAt.Before(expiredAt) && total <= maxSize {
+			break
+		}
+
+		log.Debugw("Removing artifacts", "updatedAt", run.updatedAt, "size", run.size, "ref", run.ref)
+
+		err := os.RemoveAll(a.runPath(run.ref))
+		if err != nil {
+			return err
+		}
+
+		total -= run.size
+	}
+
+	return nil
+}
+
+// Write the file through a temporary file, so that readers never see a
+// partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	return writeFileAtomicFrom(path, bytes.NewReader(data))
+}
+
+func writeFileAtomicFrom(path string, data io.Reader) error {
+	file, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), path)
+	}
+
+	if err != nil {
+		os.Remove(file.Name())
+	}
+
+	return err
+}
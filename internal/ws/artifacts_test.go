@@ -0,0 +1,59 @@
+package ws
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifactStoreCollect(t *testing.T) {
+	store := newArtifactStore(t.TempDir())
+	now := time.Now()
+
+	add := func(ref string, age time.Duration, size int) {
+		err := store.add(ref, "fake", "", Artifact{
+			Path:      "output.bin",
+			Size:      int64(size),
+			CreatedAt: now.Add(-age),
+		}, bytes.NewReader(make([]byte, size)))
+		assert.NoError(t, err)
+	}
+
+	add("expired", 2*time.Hour, 10)
+	add("active", 3*time.Hour, 10)
+	add("old", 30*time.Minute, 100)
+	add("recent", 10*time.Minute, 100)
+	add("latest", time.Minute, 100)
+
+	assert.Error(t, store.add("..", "fake", "", Artifact{Path: "output.bin"}, bytes.NewReader(nil)))
+
+	err := store.collect(time.Hour, 250, func(ref string) bool {
+		return ref == "active"
+	})
+	assert.NoError(t, err)
+
+	dirs, err := os.ReadDir(store.path)
+	assert.NoError(t, err)
+
+	refs := make([]string, 0)
+	for _, dir := range dirs {
+		refs = append(refs, dir.Name())
+	}
+
+	// Expired run is removed regardless of size and the oldest run is
+	// removed to fit into size limit.
+	assert.ElementsMatch(t, []string{"active", "recent", "latest"}, refs)
+
+	manifest, err := store.manifest("latest")
+	if assert.NoError(t, err) && assert.NotNil(t, manifest) {
+		assert.Equal(t, "fake", manifest.Flow)
+		assert.Len(t, manifest.Files, 1)
+	}
+
+	_, err = os.Stat(filepath.Join(store.path, "latest", "files", "output.bin"))
+	assert.NoError(t, err)
+}
@@ -0,0 +1,327 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Prefix of the websocket subprotocol carrying access token - browsers are
+// unable to set Authorization header, so the token is sent as one of the
+// requested subprotocols instead (i.e. "token.<token>").
+const TokenSubprotocolPrefix = "token."
+
+// Scope granting access to all commands.
+const ScopeAll = "*"
+
+// Scope granting control over runs started by other clients.
+const ScopeAdmin = "admin"
+
+// Authenticated client of the server.
+type Principal struct {
+	// Name of the user, API key or token subject.
+	Name string
+	// Authentication method ("basic", "api-key", "token" or "anonymous").
+	Method string
+	// Scopes limiting which commands the principal may send (all commands
+	// are allowed when empty).
+	Scopes []string
+}
+
+// Name of the principal with authentication method for logs (i.e.
+// "alice (basic)").
+func (p *Principal) String() string {
+	if p == nil {
+		return "unknown"
+	}
+
+	if len(p.Name) == 0 {
+		return p.Method
+	}
+
+	return fmt.Sprintf("%s (%s)", p.Name, p.Method)
+}
+
+// Check if the principal is allowed to send the command - all scopes
+// required by the command must be granted.
+func (p *Principal) Allows(command string) bool {
+	if len(p.Scopes) == 0 {
+		return true
+	}
+
+	for _, scope := range commandScopes(command) {
+		if !p.hasScope(scope) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Check if the principal may stop, attach to or cancel the run - runs are
+// controlled by principals that have started them and by principals with
+// "admin" scope.
+func (p *Principal) controls(run *RobocatRun) bool {
+	if p != nil && p.hasScope(ScopeAdmin) {
+		return true
+	}
+
+	if p == nil || run.Principal == nil {
+		return p == run.Principal
+	}
+
+	return p.Name == run.Principal.Name && p.Method == run.Principal.Method
+}
+
+// Owner of the run as recorded in stores outliving the run (i.e. artifacts)
+// - empty if unknown.
+func (p *Principal) owner() string {
+	if p == nil {
+		return ""
+	}
+
+	return p.String()
+}
+
+// Check if the principal may inspect records of the run with the owner -
+// same rules as for runs in progress apply.
+func (p *Principal) owns(owner string) bool {
+	if p != nil && p.hasScope(ScopeAdmin) {
+		return true
+	}
+
+	return p.owner() == owner
+}
+
+func (p *Principal) hasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == ScopeAll || s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Scopes required to send the command - "run" for commands controlling flow
+// runs and command name without sub-command for the rest (i.e. "input" for
+// "input.chunk"). Commands managing schedules start flows on their own, so
+// they require "run" scope as well.
+func commandScopes(command string) []string {
+	switch command {
+	case "attach":
+		return []string{"run"}
+	case "schedule.add", "schedule.remove":
+		return []string{"schedule", "run"}
+	default:
+		return []string{strings.SplitN(command, ".", 2)[0]}
+	}
+}
+
+// Scopes required to send the command for error messages (i.e. "'run'").
+func requiredScopes(command string) string {
+	return "'" + strings.Join(commandScopes(command), "' and '") + "'"
+}
+
+// Named API key with optional scopes.
+type APIKey struct {
+	Name   string
+	Key    string
+	Scopes []string
+}
+
+// Load API keys from the file. Every line of the file describes a single key
+// in "name:key[:scope,...]" format, empty lines and lines starting with "#"
+// are ignored.
+func LoadAPIKeys(path string) ([]APIKey, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	keys := make([]APIKey, 0)
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.Split(text, ":")
+		if len(parts) < 2 || len(parts) > 3 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected 'name:key[:scopes]'", path, line)
+		}
+
+		key := APIKey{Name: parts[0], Key: parts[1]}
+
+		if len(parts) == 3 && len(parts[2]) > 0 {
+			key.Scopes = strings.Split(parts[2], ",")
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, scanner.Err()
+}
+
+// Claims of HMAC-signed access tokens.
+type TokenClaims struct {
+	// Space-separated list of scopes (all commands are allowed when empty).
+	Scope string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Create access token signed with the secret (HS256). Tokens without
+// expiration time ("exp" claim) are rejected by the server.
+func SignToken(secret []byte, claims TokenClaims) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(secret)
+}
+
+func parseToken(secret []byte, token string) (*Principal, error) {
+	var claims TokenClaims
+
+	_, err := jwt.ParseWithClaims(token, &claims, func(t *jwt.Token) (interface{}, error) {
+		return secret, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Tokens are not revocable, so they must expire.
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token has no expiration time")
+	}
+
+	return &Principal{
+		Name:   claims.Subject,
+		Method: "token",
+		Scopes: strings.Fields(claims.Scope),
+	}, nil
+}
+
+// Extract access token from Authorization header, "token" query parameter
+// or websocket subprotocol.
+func tokenFromRequest(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if strings.HasPrefix(authorization, "Bearer ") {
+		return strings.TrimPrefix(authorization, "Bearer ")
+	}
+
+	if token := r.URL.Query().Get("token"); len(token) > 0 {
+		return token
+	}
+
+	for _, header := range r.Header.Values("Sec-WebSocket-Protocol") {
+		for _, protocol := range strings.Split(header, ",") {
+			protocol = strings.TrimSpace(protocol)
+			if strings.HasPrefix(protocol, TokenSubprotocolPrefix) {
+				return strings.TrimPrefix(protocol, TokenSubprotocolPrefix)
+			}
+		}
+	}
+
+	return ""
+}
+
+func secureCompare(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// Provider of credentials for Basic authentication (nil if disabled).
+func (s *Server) credentialProvider() CredentialProvider {
+	if s.Credentials != nil {
+		return s.Credentials
+	}
+
+	if len(s.Username) > 0 || len(s.Password) > 0 {
+		return &StaticCredentials{Username: s.Username, Password: s.Password}
+	}
+
+	return nil
+}
+
+func (s *Server) authEnabled() bool {
+	return s.credentialProvider() != nil ||
+		len(s.APIKeys) > 0 || len(s.TokenSecret) > 0
+}
+
+// Authenticate the request with any of the configured methods. Verified
+// client certificate takes precedence over other methods. Anybody is allowed
+// to connect when no authentication is configured.
+func (s *Server) authenticateRequest(r *http.Request) (*Principal, error) {
+	principal, err := certificatePrincipal(r)
+	if principal != nil || err != nil {
+		return principal, err
+	}
+
+	if !s.authEnabled() {
+		return &Principal{Method: "anonymous"}, nil
+	}
+
+	if token := tokenFromRequest(r); len(token) > 0 {
+		for _, key := range s.APIKeys {
+			if secureCompare(key.Key, token) {
+				return &Principal{Name: key.Name, Method: "api-key", Scopes: key.Scopes}, nil
+			}
+		}
+
+		if len(s.TokenSecret) > 0 {
+			principal, err := parseToken(s.TokenSecret, token)
+			if err != nil {
+				return nil, fmt.Errorf("invalid token: %w", err)
+			}
+
+			return principal, nil
+		}
+
+		return nil, errors.New("unknown API key")
+	}
+
+	if provider := s.credentialProvider(); provider != nil {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			return nil, errors.New("no credentials provided")
+		}
+
+		return provider.Authenticate(username, password)
+	}
+
+	return nil, errors.New("no credentials provided")
+}
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal the HTTP request was authenticated as (nil if unknown).
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Check if the principal is allowed to send the command.
+func authorizeCommand(principal *Principal, message *Message) error {
+	if principal == nil || principal.Allows(message.Name) {
+		return nil
+	}
+
+	return NewError(
+		ErrorUnauthorized,
+		"'%s' command is not allowed (requires %s scope)",
+		message.Name, requiredScopes(message.Name),
+	)
+}
@@ -0,0 +1,71 @@
+package ws
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAPIKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+
+	err := os.WriteFile(path, []byte("# comment\n\nci:key1\nuploader:key2:input,stop\n"), 0600)
+	assert.NoError(t, err)
+
+	keys, err := LoadAPIKeys(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []APIKey{
+		{Name: "ci", Key: "key1"},
+		{Name: "uploader", Key: "key2", Scopes: []string{"input", "stop"}},
+	}, keys)
+
+	err = os.WriteFile(path, []byte("invalid\n"), 0600)
+	assert.NoError(t, err)
+
+	_, err = LoadAPIKeys(path)
+	assert.ErrorContains(t, err, ":1:")
+}
+
+func TestPrincipalAllows(t *testing.T) {
+	principal := &Principal{Scopes: []string{"run"}}
+
+	assert.True(t, principal.Allows("run"))
+	assert.True(t, principal.Allows("attach"))
+	assert.False(t, principal.Allows("input"))
+	assert.False(t, principal.Allows("input.chunk"))
+
+	assert.True(t, (&Principal{}).Allows("input"))
+	assert.True(t, (&Principal{Scopes: []string{ScopeAll}}).Allows("stop"))
+
+	// Schedules start flows, so both scopes are required to manage them.
+	scheduler := &Principal{Scopes: []string{"schedule"}}
+	assert.True(t, scheduler.Allows("schedule.list"))
+	assert.False(t, scheduler.Allows("schedule.add"))
+	assert.False(t, scheduler.Allows("schedule.remove"))
+	assert.False(t, principal.Allows("schedule.add"))
+
+	both := &Principal{Scopes: []string{"schedule", "run"}}
+	assert.True(t, both.Allows("schedule.add"))
+	assert.True(t, both.Allows("schedule.remove"))
+}
+
+func TestPrincipalControls(t *testing.T) {
+	alice := &Principal{Name: "alice", Method: "basic"}
+	run := &RobocatRun{Principal: alice}
+
+	assert.True(t, alice.controls(run))
+	assert.True(t, (&Principal{Name: "alice", Method: "basic"}).controls(run))
+	assert.False(t, (&Principal{Name: "alice", Method: "api-key"}).controls(run))
+	assert.False(t, (&Principal{Name: "bob", Method: "basic"}).controls(run))
+	assert.False(t, (*Principal)(nil).controls(run))
+
+	assert.True(t, (&Principal{Name: "ops", Scopes: []string{ScopeAdmin}}).controls(run))
+	assert.True(t, (&Principal{Name: "ops", Scopes: []string{ScopeAll}}).controls(run))
+
+	// Runs of unknown clients are controlled by admins only.
+	unknown := &RobocatRun{}
+	assert.True(t, (*Principal)(nil).controls(unknown))
+	assert.False(t, alice.controls(unknown))
+}
@@ -0,0 +1,199 @@
+package ws
+
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"nhooyr.io/websocket"
+)
+
+const (
+	// Original subprotocol with JSON-encoded messages sent in text frames.
+	SubprotocolJSON = "robocat"
+	// Subprotocol with CBOR-encoded messages sent in binary frames, so that
+	// file payloads are transferred as raw bytes instead of base64.
+	SubprotocolCBOR = "robocat.v2+cbor"
+)
+
+// Subprotocols supported by the server in order of preference.
+var Subprotocols = []string{SubprotocolCBOR, SubprotocolJSON}
+
+// Encoding of messages negotiated with websocket subprotocol.
+type Codec interface {
+	Subprotocol() string
+	// Type of websocket frames carrying encoded messages.
+	MessageType() websocket.MessageType
+	EncodeMessage(m *Message) ([]byte, error)
+	DecodeMessage(data []byte) (*Message, error)
+	// Decode message body into the value.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	JSONCodec Codec = jsonCodec{}
+	CBORCodec Codec = newCBORCodec()
+)
+
+// Get codec for the negotiated subprotocol - JSON codec is used for unknown
+// subprotocols for compatibility with older clients.
+func CodecForSubprotocol(subprotocol string) Codec {
+	if subprotocol == SubprotocolCBOR {
+		return CBORCodec
+	}
+
+	return JSONCodec
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Subprotocol() string {
+	return SubprotocolJSON
+}
+
+func (jsonCodec) MessageType() websocket.MessageType {
+	return websocket.MessageText
+}
+
+func (c jsonCodec) EncodeMessage(m *Message) ([]byte, error) {
+	body, err := m.encodeBody(c)
+	if err != nil {
+		return nil, err
+	}
+
+	copy := *m
+	copy.Body = body
+
+	return json.Marshal(&copy)
+}
+
+func (c jsonCodec) DecodeMessage(data []byte) (*Message, error) {
+	var message *Message
+
+	err := json.Unmarshal(data, &message)
+	if err != nil {
+		return nil, err
+	}
+
+	if message != nil {
+		message.codec = c
+	}
+
+	return message, nil
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type cborCodec struct {
+	enc cbor.EncMode
+	dec cbor.DecMode
+}
+
+func newCBORCodec() cborCodec {
+	enc, err := cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	if err != nil {
+		panic(err)
+	}
+
+	// Maps are decoded the same way as with JSON, so that decoded values can
+	// be passed around interchangeably.
+	dec, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		panic(err)
+	}
+
+	return cborCodec{enc: enc, dec: dec}
+}
+
+// Message as it is encoded with CBOR - body is embedded as is instead of
+// being a byte string.
+type cborMessage struct {
+	Type MessageType     `cbor:"type"`
+	Name string          `cbor:"name"`
+	Body cbor.RawMessage `cbor:"body,omitempty"`
+	Ref  string          `cbor:"ref,omitempty"`
+	Seq  uint64          `cbor:"seq,omitempty"`
+
+	Trace map[string]string `cbor:"trace,omitempty"`
+}
+
+func (cborCodec) Subprotocol() string {
+	return SubprotocolCBOR
+}
+
+func (cborCodec) MessageType() websocket.MessageType {
+	return websocket.MessageBinary
+}
+
+func (c cborCodec) EncodeMessage(m *Message) ([]byte, error) {
+	wire := cborMessage{
+		Type: m.Type,
+		Name: m.Name,
+		Ref:  m.Ref,
+		Seq:  m.Seq,
+
+		Trace: m.Trace,
+	}
+
+	body, err := m.encodeBody(c)
+	if err != nil {
+		return nil, err
+	}
+
+	wire.Body = body
+
+	return c.enc.Marshal(wire)
+}
+
+func (c cborCodec) DecodeMessage(data []byte) (*Message, error) {
+	var wire cborMessage
+
+	err := c.dec.Unmarshal(data, &wire)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Message{
+		codec: c,
+		Type:  wire.Type,
+		Name:  wire.Name,
+		Body:  []byte(wire.Body),
+		Ref:   wire.Ref,
+		Seq:   wire.Seq,
+		Trace: wire.Trace,
+	}, nil
+}
+
+func (c cborCodec) Unmarshal(data []byte, v interface{}) error {
+	return c.dec.Unmarshal(data, v)
+}
+
+func (c cborCodec) Marshal(v interface{}) ([]byte, error) {
+	return c.enc.Marshal(v)
+}
+
+// Re-encode body of the received message with another codec - body is
+// decoded into a generic value first.
+func transcodeBody(m *Message, to Codec) ([]byte, error) {
+	var value interface{}
+
+	err := m.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalBody(to, value)
+}
+
+func marshalBody(codec Codec, value interface{}) ([]byte, error) {
+	switch codec := codec.(type) {
+	case cborCodec:
+		return codec.Marshal(value)
+	default:
+		return json.Marshal(value)
+	}
+}
@@ -0,0 +1,115 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	payload := make([]byte, 3000)
+	for i := range payload {
+		payload[i] = byte(i)
+	}
+
+	update, err := newUpdate("output", RobocatFile{
+		Path:     "file.bin",
+		MimeType: "application/octet-stream",
+		Payload:  payload,
+	})
+	assert.NoError(t, err)
+
+	update.Ref = "ref"
+	update.Seq = 7
+
+	sizes := make(map[string]int)
+
+	for _, codec := range []Codec{JSONCodec, CBORCodec} {
+		bytes, err := codec.EncodeMessage(update)
+		assert.NoError(t, err)
+
+		sizes[codec.Subprotocol()] = len(bytes)
+
+		decoded, err := codec.DecodeMessage(bytes)
+		assert.NoError(t, err)
+		assert.Equal(t, Update, decoded.Type)
+		assert.Equal(t, "output", decoded.Name)
+		assert.Equal(t, "ref", decoded.Ref)
+		assert.Equal(t, uint64(7), decoded.Seq)
+
+		file, err := ParseFileFromMessage(decoded)
+		assert.NoError(t, err)
+		assert.Equal(t, "file.bin", file.Path)
+		assert.Equal(t, payload, file.Payload)
+	}
+
+	// Payload is sent as raw bytes instead of base64.
+	assert.Less(t, sizes[SubprotocolCBOR], len(payload)+200)
+	assert.Greater(t, sizes[SubprotocolJSON], len(payload)*4/3)
+}
+
+func TestCodecLazyBody(t *testing.T) {
+	update, err := newUpdate("output", RobocatFile{Path: "file.bin", Payload: []byte("payload")})
+	assert.NoError(t, err)
+
+	// Body is not encoded until the update is sent.
+	assert.Empty(t, update.Body)
+	assert.Equal(t, 0, update.size())
+
+	_, err = CBORCodec.EncodeMessage(update)
+	assert.NoError(t, err)
+	assert.Len(t, update.encoded.bodies, 1)
+
+	size := update.size()
+	assert.Greater(t, size, 0)
+
+	// Body encoded with every codec is reused.
+	for i := 0; i < 2; i++ {
+		_, err = JSONCodec.EncodeMessage(update)
+		assert.NoError(t, err)
+	}
+
+	assert.Len(t, update.encoded.bodies, 2)
+	assert.Equal(t, size, update.size())
+
+	file, err := ParseFileFromMessage(update)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("payload"), file.Payload)
+}
+
+func TestCodecTranscode(t *testing.T) {
+	command, err := JSONCodec.DecodeMessage([]byte(
+		`{"type":"command","name":"run","ref":"ref","body":{"flow":"test","data":"a=1"}}`,
+	))
+	assert.NoError(t, err)
+
+	bytes, err := CBORCodec.EncodeMessage(command)
+	assert.NoError(t, err)
+
+	decoded, err := CBORCodec.DecodeMessage(bytes)
+	assert.NoError(t, err)
+
+	var args RunnerArguments
+	assert.NoError(t, decoded.Decode(&args))
+	assert.Equal(t, "test", args.Flow)
+	assert.Equal(t, "a=1", args.Data)
+}
+
+func TestCodecTrace(t *testing.T) {
+	command, err := NewMessageWithBody("run")
+	assert.NoError(t, err)
+
+	command.Type = Command
+	command.Trace = map[string]string{
+		"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+
+	for _, codec := range []Codec{JSONCodec, CBORCodec} {
+		bytes, err := codec.EncodeMessage(command)
+		assert.NoError(t, err)
+
+		decoded, err := codec.DecodeMessage(bytes)
+		assert.NoError(t, err)
+		assert.Equal(t, command.Trace, decoded.Trace)
+	}
+}
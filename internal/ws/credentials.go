@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var errInvalidCredentials = errors.New("invalid username or password")
+
+// Provider of credentials checked during Basic authentication.
+type CredentialProvider interface {
+	// Check the username and password and return authenticated principal.
+	Authenticate(username string, password string) (*Principal, error)
+}
+
+// Single user with plaintext password (AUTH_USERNAME and AUTH_PASSWORD).
+type StaticCredentials struct {
+	Username string
+	Password string
+}
+
+func (c *StaticCredentials) Authenticate(username string, password string) (*Principal, error) {
+	// Both values are compared regardless of the result of the first
+	// comparison, so that timing does not reveal which one was wrong.
+	usernameMatches := secureCompare(c.Username, username)
+	passwordMatches := secureCompare(c.Password, password)
+
+	if !usernameMatches || !passwordMatches {
+		return nil, errInvalidCredentials
+	}
+
+	return &Principal{Name: username, Method: "basic"}, nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// Compare the password with a dummy hash for unknown users, so that response
+// time does not reveal whether the user exists.
+func compareDummyHash(password string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("robocat"), bcrypt.DefaultCost)
+	})
+
+	bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+}
+
+// Users with bcrypt-hashed passwords read from htpasswd file (i.e. created
+// with "htpasswd -B"). File is reloaded when it is modified.
+type HtpasswdFile struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	users   map[string][]byte
+}
+
+// Read htpasswd file - only bcrypt hashes are supported.
+func NewHtpasswdFile(path string) (*HtpasswdFile, error) {
+	f := &HtpasswdFile{path: path}
+
+	if err := f.reload(); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (f *HtpasswdFile) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+
+	if info.ModTime().Equal(f.modTime) && f.users != nil {
+		return nil
+	}
+
+	users, err := parseHtpasswd(f.path)
+	if err != nil {
+		return err
+	}
+
+	if f.users != nil {
+		log.Infow("Reloaded htpasswd file", "path", f.path, "users", len(users))
+	}
+
+	f.users = users
+	f.modTime = info.ModTime()
+
+	return nil
+}
+
+func parseHtpasswd(path string) (map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	users := make(map[string][]byte)
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		username, hash, ok := strings.Cut(text, ":")
+		if !ok || len(username) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected 'username:hash'", path, line)
+		}
+
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("%s:%d: only bcrypt hashes are supported", path, line)
+		}
+
+		users[username] = []byte(hash)
+	}
+
+	return users, scanner.Err()
+}
+
+func (f *HtpasswdFile) Authenticate(username string, password string) (*Principal, error) {
+	f.mu.Lock()
+
+	// Previously loaded users are kept if the file is being rewritten or
+	// is broken for some reason.
+	if err := f.reload(); err != nil {
+		log.Warnw("Unable to reload htpasswd file", "path", f.path, "error", err)
+	}
+
+	hash, ok := f.users[username]
+
+	f.mu.Unlock()
+
+	if !ok {
+		compareDummyHash(password)
+		return nil, errInvalidCredentials
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return nil, errInvalidCredentials
+	}
+
+	return &Principal{Name: username, Method: "basic"}, nil
+}
@@ -0,0 +1,65 @@
+package ws
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeHtpasswd(t *testing.T, path string, users map[string]string, modTime time.Time) {
+	content := ""
+
+	for username, password := range users {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		assert.NoError(t, err)
+
+		content += fmt.Sprintf("%s:%s\n", username, hash)
+	}
+
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+}
+
+func TestHtpasswdFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	now := time.Now()
+
+	writeHtpasswd(t, path, map[string]string{"alice": "first"}, now.Add(-time.Hour))
+
+	credentials, err := NewHtpasswdFile(path)
+	assert.NoError(t, err)
+
+	principal, err := credentials.Authenticate("alice", "first")
+	assert.NoError(t, err)
+	assert.Equal(t, "alice", principal.Name)
+
+	_, err = credentials.Authenticate("alice", "wrong")
+	assert.Error(t, err)
+
+	_, err = credentials.Authenticate("bob", "first")
+	assert.Error(t, err)
+
+	// Changes are picked up without restart.
+	writeHtpasswd(t, path, map[string]string{"bob": "second"}, now)
+
+	_, err = credentials.Authenticate("alice", "first")
+	assert.Error(t, err)
+
+	principal, err = credentials.Authenticate("bob", "second")
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", principal.Name)
+}
+
+func TestHtpasswdFileInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+
+	assert.NoError(t, os.WriteFile(path, []byte("alice:{SHA}plain\n"), 0600))
+
+	_, err := NewHtpasswdFile(path)
+	assert.ErrorContains(t, err, "only bcrypt hashes are supported")
+}
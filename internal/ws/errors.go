@@ -0,0 +1,86 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Machine-readable code of the error sent in "error" updates.
+type ErrorCode string
+
+const (
+	// Flow with the given name does not exist.
+	ErrorFlowNotFound ErrorCode = "flow-not-found"
+	// Automation has not started within AUTOMATION_START_TIMEOUT.
+	ErrorStartTimeout ErrorCode = "automation-start-timeout"
+	// Flow has printed an error line.
+	ErrorFlowErrorLine ErrorCode = "flow-error-line"
+	// Flow process has exited with non-zero code.
+	ErrorProcessExit ErrorCode = "process-exit"
+	// Command arguments are malformed or invalid.
+	ErrorInvalidArguments ErrorCode = "invalid-arguments"
+	// Server is busy running another flow.
+	ErrorBusy ErrorCode = "busy"
+	// Client is not allowed to perform the command.
+	ErrorUnauthorized ErrorCode = "unauthorized"
+	// Referenced flow is not running.
+	ErrorNotRunning ErrorCode = "not-running"
+	// Referenced history entry, artifact, queued run or schedule does not
+	// exist.
+	ErrorNotFound ErrorCode = "not-found"
+	// Command is not supported by the server.
+	ErrorUnknownCommand ErrorCode = "unknown-command"
+	// Checksum of the transferred file does not match the expected one.
+	ErrorChecksumMismatch ErrorCode = "checksum-mismatch"
+	// Any other error.
+	ErrorInternal ErrorCode = "internal"
+)
+
+// Body of "error" updates.
+type ErrorBody struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// Error with a code that is sent to the client as is.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Details interface{}
+}
+
+func NewError(code ErrorCode, format string, a ...any) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(format, a...),
+	}
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Attach additional machine-readable details to the error.
+func (e *Error) WithDetails(details interface{}) *Error {
+	e.Details = details
+	return e
+}
+
+// Convert error to "error" update body. Errors without code are reported
+// as internal errors.
+func NewErrorBody(err error) *ErrorBody {
+	var e *Error
+	if errors.As(err, &e) {
+		return &ErrorBody{
+			Code:    e.Code,
+			Message: e.Message,
+			Details: e.Details,
+		}
+	}
+
+	return &ErrorBody{
+		Code:    ErrorInternal,
+		Message: err.Error(),
+	}
+}
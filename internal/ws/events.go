@@ -1,18 +1,39 @@
 package ws
 
-import "context"
+import (
+	"context"
 
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Callback for a command received from the client. Context is bound to the
+// session the command was received from, which is available through
+// Message.Session, and carries the span of the command which is ended when
+// the callback returns.
 type CommandCallback func(context.Context, *Message)
 
 func (s *Server) On(name string, callback CommandCallback) {
 	s.registeredCallbacks[name] = callback
 }
 
+// Check if the command is supported by the server.
+func (s *Server) hasCommand(name string) bool {
+	_, ok := s.registeredCallbacks[name]
+	return ok || name == "ping" || name == "hello"
+}
+
+// Run callback registered for the command and report whether there was one.
+// Span in the context is ended when the callback returns.
 func (s *Server) broadcastEvent(
 	ctx context.Context, name string, message *Message,
-) {
+) bool {
 	callback, ok := s.registeredCallbacks[name]
 	if ok {
-		go callback(ctx, message)
+		go func() {
+			defer trace.SpanFromContext(ctx).End()
+			callback(ctx, message)
+		}()
 	}
+
+	return ok
 }
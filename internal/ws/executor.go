@@ -0,0 +1,214 @@
+package ws
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/sakirsensoy/genv"
+)
+
+// Time to wait for output left in the pipes once the flow process exits.
+const pipeDrainTimeout = time.Second
+
+type LogEventKind int
+
+const (
+	// Regular log line without any special meaning.
+	LogLine LogEventKind = iota
+	// Automation has started - the start watchdog should be armed.
+	LogStart
+	// Flow reported an error - the run should be aborted.
+	LogError
+)
+
+// Result of parsing a single log line of the flow process.
+type LogEvent struct {
+	Kind LogEventKind
+	// Text associated with the event (i.e. error message without prefix).
+	Text string
+	// Error code for LogError events (ErrorFlowErrorLine if not set).
+	Code ErrorCode
+}
+
+// Process started by an executor.
+type ExecutorProcess interface {
+	// Standard output of the process.
+	Stdout() io.Reader
+	// Standard error of the process.
+	Stderr() io.Reader
+	// Block until the process exits.
+	Wait() error
+}
+
+// Executor is responsible for running flows. It allows to use engines other
+// than TagUI (i.e. Playwright or Python scripts) through the same protocol.
+type Executor interface {
+	// Name of the executor (i.e. "tagui").
+	Name() string
+	// Version of the underlying engine (empty if unknown).
+	Version() string
+	// Check that flows can be started (i.e. required binaries are on PATH).
+	Check() error
+	// Start a new process for the flow described by arguments.
+	Start(args *RunnerArguments) (ExecutorProcess, error)
+	// Stop the process started by this executor.
+	Stop(process ExecutorProcess) error
+	// Kill anything left behind by previous runs.
+	Cleanup() error
+	// Check if several flows can be run at the same time.
+	Concurrent() bool
+	// Check if flows use input and output directories of their run passed
+	// through arguments instead of shared ones.
+	Isolated() bool
+	// Detect special log lines produced by the flow.
+	ParseLog(line string) LogEvent
+}
+
+// Create an executor selected through EXECUTOR environment variable.
+func NewExecutorFromEnvironment() (Executor, error) {
+	name := genv.Key("EXECUTOR").Default("tagui").String()
+
+	switch name {
+	case "tagui":
+		return NewTagUIExecutor(), nil
+	case "shell":
+		return NewShellExecutor(
+			genv.Key("EXECUTOR_COMMAND").String(),
+			genv.Key("EXECUTOR_DIR").Default("flow").String(),
+		)
+	default:
+		return nil, fmt.Errorf("unknown executor: %s", name)
+	}
+}
+
+// Environment variables with input and output directories of the run.
+func directoryEnv(args *RunnerArguments) []string {
+	return []string{
+		fmt.Sprintf("ROBOCAT_INPUT_DIR=%s", args.InputPath),
+		fmt.Sprintf("ROBOCAT_OUTPUT_DIR=%s", args.OutputPath),
+	}
+}
+
+type cmdProcess struct {
+	cmd    *exec.Cmd
+	stdout *io.PipeReader
+	stderr *io.PipeReader
+
+	done chan struct{}
+	err  error
+}
+
+func startCommand(cmd *exec.Cmd) (*cmdProcess, error) {
+	setProcessGroup(cmd)
+
+	// Process writes to OS pipes directly, so that waiting for the command
+	// does not depend on its descendants closing inherited pipes. Output is
+	// copied to in-memory pipes, which allows to wait for the process without
+	// losing output that was not read yet.
+	stdoutPipe, stdoutFile, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stderrPipe, stderrFile, err := os.Pipe()
+	if err != nil {
+		stdoutPipe.Close()
+		stdoutFile.Close()
+		return nil, err
+	}
+
+	cmd.Stdout = stdoutFile
+	cmd.Stderr = stderrFile
+
+	err = cmd.Start()
+
+	// Write ends are only kept open by the process and its descendants.
+	stdoutFile.Close()
+	stderrFile.Close()
+
+	if err != nil {
+		stdoutPipe.Close()
+		stderrPipe.Close()
+		return nil, err
+	}
+
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+
+	process := &cmdProcess{
+		cmd:    cmd,
+		stdout: stdout,
+		stderr: stderr,
+		done:   make(chan struct{}),
+	}
+
+	var copied sync.WaitGroup
+	copied.Add(2)
+
+	for src, dst := range map[*os.File]*io.PipeWriter{
+		stdoutPipe: stdoutWriter,
+		stderrPipe: stderrWriter,
+	} {
+		go func(src *os.File, dst *io.PipeWriter) {
+			defer copied.Done()
+
+			io.Copy(dst, src)
+			dst.Close()
+		}(src, dst)
+	}
+
+	go func() {
+		process.err = cmd.Wait()
+
+		// Output left in the pipes is read unless descendants of the process
+		// keep them open - those are killed along with the process group.
+		drained := make(chan struct{})
+		go func() {
+			copied.Wait()
+			close(drained)
+		}()
+
+		select {
+		case <-drained:
+		case <-time.After(pipeDrainTimeout):
+			log.Debugw("Output pipes are held by descendants - killing process group", "pid", cmd.Process.Pid)
+		}
+
+		killProcessGroup(cmd)
+
+		stdoutPipe.Close()
+		stderrPipe.Close()
+		<-drained
+
+		close(process.done)
+	}()
+
+	return process, nil
+}
+
+func (p *cmdProcess) Stdout() io.Reader {
+	return p.stdout
+}
+
+func (p *cmdProcess) Stderr() io.Reader {
+	return p.stderr
+}
+
+func (p *cmdProcess) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// Kill the process along with everything it has spawned.
+func (p *cmdProcess) kill() error {
+	select {
+	case <-p.done:
+		return nil
+	default:
+		return killProcessGroup(p.cmd)
+	}
+}
@@ -0,0 +1,162 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Function implementing behaviour of a fake flow. Everything written to
+// stdout and stderr is treated as flow logs and returned error is reported
+// as process exit error. Context is canceled when the process is stopped.
+type FakeScript func(
+	ctx context.Context,
+	args *RunnerArguments,
+	stdout io.Writer,
+	stderr io.Writer,
+) error
+
+// Executor running scripted fake flows without any external processes.
+// It allows to test the server on a machine without the base image.
+type FakeExecutor struct {
+	Script FakeScript
+	// Pretend that flows cannot be run concurrently (like TagUI ones).
+	Sequential bool
+	// Pretend that flows only use shared input and output directories (like
+	// TagUI ones).
+	Shared bool
+
+	mu        sync.Mutex
+	processes map[*fakeProcess]struct{}
+	started   int
+}
+
+type fakeProcess struct {
+	stdout *io.PipeReader
+	stderr *io.PipeReader
+	cancel context.CancelFunc
+
+	done chan struct{}
+	err  error
+}
+
+func NewFakeExecutor(script FakeScript) *FakeExecutor {
+	return &FakeExecutor{
+		Script:    script,
+		processes: make(map[*fakeProcess]struct{}),
+	}
+}
+
+func (e *FakeExecutor) Name() string {
+	return "fake"
+}
+
+func (e *FakeExecutor) Version() string {
+	return "fake"
+}
+
+func (e *FakeExecutor) Check() error {
+	return nil
+}
+
+func (e *FakeExecutor) Start(args *RunnerArguments) (ExecutorProcess, error) {
+	if e.Script == nil {
+		return nil, errors.New("fake executor script is not set")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stdout, stdoutWriter := io.Pipe()
+	stderr, stderrWriter := io.Pipe()
+
+	process := &fakeProcess{
+		stdout: stdout,
+		stderr: stderr,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	e.mu.Lock()
+	e.processes[process] = struct{}{}
+	e.started++
+	e.mu.Unlock()
+
+	go func() {
+		process.err = e.Script(ctx, args, stdoutWriter, stderrWriter)
+		stdoutWriter.Close()
+		stderrWriter.Close()
+		cancel()
+
+		e.mu.Lock()
+		delete(e.processes, process)
+		e.mu.Unlock()
+
+		close(process.done)
+	}()
+
+	return process, nil
+}
+
+func (e *FakeExecutor) Stop(process ExecutorProcess) error {
+	p, ok := process.(*fakeProcess)
+	if !ok {
+		return errors.New("process was not started by fake executor")
+	}
+
+	p.cancel()
+
+	return nil
+}
+
+func (e *FakeExecutor) Cleanup() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	for process := range e.processes {
+		process.cancel()
+	}
+
+	return nil
+}
+
+func (e *FakeExecutor) Concurrent() bool {
+	return !e.Sequential
+}
+
+// Scripts get directories of the run through arguments.
+func (e *FakeExecutor) Isolated() bool {
+	return !e.Shared
+}
+
+func (e *FakeExecutor) ParseLog(line string) LogEvent {
+	return NewTagUIExecutor().ParseLog(line)
+}
+
+// Number of processes started by the executor so far.
+func (e *FakeExecutor) Started() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.started
+}
+
+// Number of processes that are still running.
+func (e *FakeExecutor) Running() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return len(e.processes)
+}
+
+func (p *fakeProcess) Stdout() io.Reader {
+	return p.stdout
+}
+
+func (p *fakeProcess) Stderr() io.Reader {
+	return p.stderr
+}
+
+func (p *fakeProcess) Wait() error {
+	<-p.done
+	return p.err
+}
@@ -0,0 +1,160 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Executor running an arbitrary command for each flow (i.e. Playwright or
+// Python scripts). The "{flow}" placeholder in the command is replaced with
+// the flow name, otherwise the flow name is appended as the last argument.
+// Flow data and proxy are passed through ROBOCAT_DATA and ROBOCAT_PROXY
+// environment variables, input and output directories of the run through
+// ROBOCAT_INPUT_DIR and ROBOCAT_OUTPUT_DIR.
+type ShellExecutor struct {
+	command []string
+	dir     string
+
+	mu        sync.Mutex
+	processes map[*cmdProcess]struct{}
+}
+
+func NewShellExecutor(command string, dir string) (*ShellExecutor, error) {
+	fields := strings.Fields(command)
+	if len(fields) == 0 {
+		return nil, errors.New("shell executor command must not be empty")
+	}
+
+	return &ShellExecutor{
+		command:   fields,
+		dir:       dir,
+		processes: make(map[*cmdProcess]struct{}),
+	}, nil
+}
+
+func (e *ShellExecutor) Name() string {
+	return "shell"
+}
+
+// Version of the shell command is not known - running the command just to
+// find it out could have side effects.
+func (e *ShellExecutor) Version() string {
+	return ""
+}
+
+// Check that the command is on PATH (commands with flow name placeholder in
+// place of the binary cannot be checked in advance).
+func (e *ShellExecutor) Check() error {
+	name := e.command[0]
+	if strings.Contains(name, "{flow}") {
+		return nil
+	}
+
+	// Relative paths are resolved against the working directory of the
+	// process.
+	if strings.Contains(name, "/") && !filepath.IsAbs(name) {
+		name = filepath.Join(e.dir, name)
+	}
+
+	_, err := exec.LookPath(name)
+	return err
+}
+
+func (e *ShellExecutor) Start(args *RunnerArguments) (ExecutorProcess, error) {
+	command := make([]string, 0, len(e.command)+1)
+	substituted := false
+
+	for _, field := range e.command {
+		if strings.Contains(field, "{flow}") {
+			field = strings.ReplaceAll(field, "{flow}", args.Flow)
+			substituted = true
+		}
+
+		command = append(command, field)
+	}
+
+	if !substituted {
+		command = append(command, args.Flow)
+	}
+
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = e.dir
+	cmd.Env = append(
+		os.Environ(),
+		fmt.Sprintf("ROBOCAT_FLOW=%s", args.Flow),
+		fmt.Sprintf("ROBOCAT_DATA=%s", args.Data),
+		fmt.Sprintf("ROBOCAT_PROXY=%s", args.Proxy),
+	)
+	cmd.Env = append(cmd.Env, directoryEnv(args)...)
+
+	process, err := startCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	e.mu.Lock()
+	e.processes[process] = struct{}{}
+	e.mu.Unlock()
+
+	go func() {
+		process.Wait()
+
+		e.mu.Lock()
+		delete(e.processes, process)
+		e.mu.Unlock()
+	}()
+
+	return process, nil
+}
+
+func (e *ShellExecutor) Stop(process ExecutorProcess) error {
+	p, ok := process.(*cmdProcess)
+	if !ok {
+		return errors.New("process was not started by shell executor")
+	}
+
+	return p.kill()
+}
+
+func (e *ShellExecutor) Cleanup() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var lastErr error
+
+	for process := range e.processes {
+		err := process.kill()
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
+
+// Flows are independent processes, though they still share input and output
+// directories unless runs are isolated.
+func (e *ShellExecutor) Concurrent() bool {
+	return true
+}
+
+// Directories of the run are passed through ROBOCAT_INPUT_DIR and
+// ROBOCAT_OUTPUT_DIR.
+func (e *ShellExecutor) Isolated() bool {
+	return true
+}
+
+func (e *ShellExecutor) ParseLog(line string) LogEvent {
+	errorPrefix := "ERROR - "
+
+	if strings.HasPrefix(line, errorPrefix) {
+		return LogEvent{Kind: LogError, Text: strings.TrimPrefix(line, errorPrefix)}
+	}
+
+	return LogEvent{Kind: LogLine, Text: line}
+}
@@ -0,0 +1,127 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TagUI prints its version in the usage message (i.e. "tagui v6.110: use
+// following options...").
+var tagUIVersionPattern = regexp.MustCompile(`tagui v([^\s:]+)`)
+
+// Executor running TagUI flows using wrapper scripts from the base image.
+type TagUIExecutor struct {
+	versionOnce sync.Once
+	version     string
+}
+
+func NewTagUIExecutor() *TagUIExecutor {
+	return &TagUIExecutor{}
+}
+
+func (e *TagUIExecutor) Name() string {
+	return "tagui"
+}
+
+// Version of TagUI taken from TAGUI_VERSION environment variable or detected
+// from the usage message printed by tagui command.
+func (e *TagUIExecutor) Version() string {
+	e.versionOnce.Do(func() {
+		e.version = os.Getenv("TAGUI_VERSION")
+		if len(e.version) > 0 {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// Usage message is printed with non-zero exit code, so the error is
+		// ignored.
+		output, _ := exec.CommandContext(ctx, "tagui").CombinedOutput()
+
+		match := tagUIVersionPattern.FindSubmatch(output)
+		if match != nil {
+			e.version = string(match[1])
+		}
+	})
+
+	return e.version
+}
+
+// Check that TagUI and wrapper scripts of the base image are on PATH.
+func (e *TagUIExecutor) Check() error {
+	for _, name := range []string{"run", "kill_tagui", "tagui"} {
+		if _, err := exec.LookPath(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *TagUIExecutor) Start(args *RunnerArguments) (ExecutorProcess, error) {
+	// Run the flow using base wrapper script (which is 'run' command
+	// inside container).
+	cmd := exec.Command("run", args.ToArray()...)
+
+	return startCommand(cmd)
+}
+
+func (e *TagUIExecutor) Stop(process ExecutorProcess) error {
+	p, ok := process.(*cmdProcess)
+	if !ok {
+		return errors.New("process was not started by tagui executor")
+	}
+
+	err := p.kill()
+
+	// Browser and other helper processes may leave the process group of the
+	// wrapper script. It is safe to kill all of them since TagUI flows are
+	// never run concurrently.
+	if cleanupErr := e.Cleanup(); err == nil {
+		err = cleanupErr
+	}
+
+	return err
+}
+
+func (e *TagUIExecutor) Cleanup() error {
+	return exec.Command("kill_tagui").Run()
+}
+
+// TagUI instances share browser and its debugging port, and kill_tagui kills
+// all of them, so only one flow is run at a time.
+func (e *TagUIExecutor) Concurrent() bool {
+	return false
+}
+
+// Wrapper script of the base image always uses shared "input" and "output"
+// directories of the flow.
+func (e *TagUIExecutor) Isolated() bool {
+	return false
+}
+
+func (e *TagUIExecutor) ParseLog(line string) LogEvent {
+	startPrefix := "START - automation started"
+	errorPrefix := "ERROR - "
+
+	if strings.HasPrefix(line, startPrefix) {
+		return LogEvent{Kind: LogStart, Text: line}
+	} else if strings.HasPrefix(line, errorPrefix) {
+		text := strings.TrimPrefix(line, errorPrefix)
+
+		if strings.HasPrefix(text, "cannot find ") {
+			return LogEvent{Kind: LogError, Text: text, Code: ErrorFlowNotFound}
+		}
+
+		return LogEvent{Kind: LogError, Text: text}
+	}
+
+	return LogEvent{Kind: LogLine, Text: line}
+}
@@ -0,0 +1,71 @@
+package ws_test
+
+import (
+	"bufio"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	"github.com/stretchr/testify/assert"
+)
+
+// Wait for the process to exit and its output to be closed.
+func waitProcess(t *testing.T, process ws.ExecutorProcess, timeout time.Duration) {
+	done := make(chan struct{})
+
+	go func() {
+		io.Copy(io.Discard, process.Stderr())
+		process.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		t.Fatal("process has not exited")
+	}
+}
+
+func TestShellExecutorStop(t *testing.T) {
+	executor, err := ws.NewShellExecutor("sh -c", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Child process keeps output of the shell open.
+	process, err := executor.Start(&ws.RunnerArguments{Flow: "sleep 30 & echo started; wait"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := bufio.NewScanner(process.Stdout())
+	if assert.True(t, stdout.Scan()) {
+		assert.Equal(t, "started", stdout.Text())
+	}
+
+	go io.Copy(io.Discard, process.Stdout())
+
+	assert.NoError(t, executor.Stop(process))
+	waitProcess(t, process, 5*time.Second)
+}
+
+func TestShellExecutorDescendants(t *testing.T) {
+	executor, err := ws.NewShellExecutor("sh -c", t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Shell exits leaving its child with inherited output behind.
+	process, err := executor.Start(&ws.RunnerArguments{Flow: "sleep 30 & echo done"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output, err := io.ReadAll(process.Stdout())
+	assert.NoError(t, err)
+	assert.Equal(t, "done\n", string(output))
+
+	waitProcess(t, process, 5*time.Second)
+	assert.NoError(t, process.Wait())
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package ws
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// Start the command in its own process group, so that processes spawned by
+// the flow (i.e. browser) can be killed along with it.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// Kill every process in the process group of the started command.
+func killProcessGroup(cmd *exec.Cmd) error {
+	err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		// Whole group has already exited.
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,19 @@
+package ws
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// Process groups are not supported - only the command itself is killed.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	err := cmd.Process.Kill()
+	if errors.Is(err, os.ErrProcessDone) {
+		return nil
+	}
+
+	return err
+}
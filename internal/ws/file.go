@@ -1,7 +1,6 @@
 package ws
 
 import (
-	"encoding/json"
 	_ "image/jpeg"
 	_ "image/png"
 )
@@ -11,11 +10,16 @@ type RobocatFile struct {
 	// Mime-Type of the file (set only for outputs to aid decoding the payload).
 	MimeType string `json:"type"`
 	Payload  []byte `json:"payload"`
+	// Input is written for the run in progress with the ref (inputs only).
+	Ref string `json:"ref,omitempty"`
+	// Scope of the input - InputScopeSession keeps the input for runs
+	// started by the session only (inputs only).
+	Scope string `json:"scope,omitempty"`
 }
 
 func ParseFileFromMessage(m *Message) (*RobocatFile, error) {
 	var fields *RobocatFile
-	err := json.Unmarshal(m.Body, &fields)
+	err := m.Decode(&fields)
 	if err != nil {
 		return nil, err
 	}
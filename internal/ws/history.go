@@ -0,0 +1,290 @@
+package ws
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Value secrets are replaced with in recorded arguments.
+const redactedValue = "xxxxx"
+
+const (
+	// Number of history entries returned when the filter has no limit.
+	DefaultHistoryLimit = 100
+	// Max number of history entries returned at once, so that the reply
+	// fits into MAX_READ_SIZE of the client - older entries are paged with
+	// Until set to StartedAt of the last entry.
+	MaxHistoryLimit = 500
+)
+
+// Keys of values in JSON run data that are treated as secrets (matched
+// case-insensitively as substrings, so "apiToken" is redacted as well).
+var secretKeys = []string{
+	"password", "passwd", "secret", "token", "credential", "apikey", "api_key",
+	"authorization", "cookie",
+}
+
+// Finished run recorded in history.
+type HistoryEntry struct {
+	Ref  string `json:"ref"`
+	Flow string `json:"flow"`
+	// Arguments of the run with secrets redacted.
+	Args *RunnerArguments `json:"args"`
+	// Client that has started the run (empty if unknown).
+	Principal string `json:"principal,omitempty"`
+	// "success", "error" or "stopped".
+	Status     string     `json:"status"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt time.Time  `json:"finishedAt"`
+	Result     *RunResult `json:"result"`
+	// Error the run has failed with (if any).
+	Error *ErrorBody `json:"error,omitempty"`
+	// Manifest of output files sent during the run.
+	Files []RunFile `json:"files"`
+}
+
+// Filter of "history" command - empty fields match all entries.
+type HistoryFilter struct {
+	Flow   string `json:"flow,omitempty"`
+	Status string `json:"status,omitempty"`
+	// Only runs started at or after the time.
+	Since *time.Time `json:"since,omitempty"`
+	// Only runs started before the time.
+	Until *time.Time `json:"until,omitempty"`
+	// Max number of entries to return (the latest ones are returned first) -
+	// DefaultHistoryLimit when zero and never more than MaxHistoryLimit.
+	Limit int `json:"limit,omitempty"`
+}
+
+func (f *HistoryFilter) Validate() error {
+	switch f.Status {
+	case "", "success", "error", "stopped":
+	default:
+		return fmt.Errorf("unknown status: %s", f.Status)
+	}
+
+	if f.Limit < 0 {
+		return errors.New("limit must not be negative")
+	}
+
+	return nil
+}
+
+func (f *HistoryFilter) limit() int {
+	if f.Limit == 0 {
+		return DefaultHistoryLimit
+	}
+
+	if f.Limit > MaxHistoryLimit {
+		return MaxHistoryLimit
+	}
+
+	return f.Limit
+}
+
+func (f *HistoryFilter) matches(entry *HistoryEntry) bool {
+	if len(f.Flow) > 0 && entry.Flow != f.Flow {
+		return false
+	}
+
+	if len(f.Status) > 0 && entry.Status != f.Status {
+		return false
+	}
+
+	if f.Since != nil && entry.StartedAt.Before(*f.Since) {
+		return false
+	}
+
+	if f.Until != nil && !entry.StartedAt.Before(*f.Until) {
+		return false
+	}
+
+	return true
+}
+
+type HistoryGetArguments struct {
+	Ref string `json:"ref"`
+}
+
+// Run history stored as JSON lines, so that entries are only ever appended
+// to the file and a partially written line does not corrupt other entries.
+type historyStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newHistoryStore(path string) *historyStore {
+	return &historyStore{path: path}
+}
+
+func (h *historyStore) add(entry *HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(h.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(append(line, '\n'))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Read all entries in the order they were recorded. Malformed lines are
+// skipped.
+func (h *historyStore) entries() ([]*HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	file, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*HistoryEntry
+
+	reader := bufio.NewReader(file)
+
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(strings.TrimSpace(string(data))) > 0 {
+			var entry *HistoryEntry
+			if jsonErr := json.Unmarshal(data, &entry); jsonErr != nil || entry == nil {
+				log.Warnw("Skipping malformed history entry", "file", h.path, "line", line, "error", jsonErr)
+			} else {
+				entries = append(entries, entry)
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// Entries matching the filter that are visible to the principal (runs it
+// has started, or all runs for admins) - the latest runs come first.
+func (h *historyStore) find(filter *HistoryFilter, principal *Principal) ([]*HistoryEntry, error) {
+	entries, err := h.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([]*HistoryEntry, 0)
+	for _, entry := range entries {
+		if filter.matches(entry) && principal.owns(entry.Principal) {
+			found = append(found, entry)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].StartedAt.After(found[j].StartedAt)
+	})
+
+	if len(found) > filter.limit() {
+		found = found[:filter.limit()]
+	}
+
+	return found, nil
+}
+
+// Get entry of the run by its ref.
+func (h *historyStore) get(ref string) (*HistoryEntry, error) {
+	entries, err := h.entries()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].Ref == ref {
+			return entries[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Copy of run arguments safe to be stored: password of proxy URL and
+// secret-looking values of JSON data are redacted. Data that is not JSON
+// cannot be inspected, so it is redacted whole.
+func redactArguments(args *RunnerArguments) *RunnerArguments {
+	redacted := *args
+
+	if u, err := url.Parse(args.Proxy); err == nil && u.User != nil {
+		redacted.Proxy = u.Redacted()
+	}
+
+	if len(args.Data) == 0 {
+		return &redacted
+	}
+
+	redacted.Data = redactedValue
+
+	var data interface{}
+	if err := json.Unmarshal([]byte(args.Data), &data); err == nil {
+		if bytes, err := json.Marshal(redactSecrets(data)); err == nil {
+			redacted.Data = string(bytes)
+		}
+	}
+
+	return &redacted
+}
+
+func redactSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			if isSecretKey(key) {
+				v[key] = redactedValue
+			} else {
+				v[key] = redactSecrets(item)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = redactSecrets(item)
+		}
+	}
+
+	return value
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -2,18 +2,30 @@ package ws
 
 import (
 	"context"
-	"errors"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
+	"sync"
 )
 
 type RobocatInput struct {
 	runner *RobocatRunner
+
+	mu sync.Mutex
+	// Chunked transfers in progress keyed by owner and transfer ID.
+	transfers map[string]*TransferBegin
+	// Sessions with inputs kept for their runs (removed once the session is
+	// closed) keyed by session ID.
+	sessionsMu sync.Mutex
+	sessions   map[string]struct{}
 }
 
 func NewRobocatInput(runner *RobocatRunner) *RobocatInput {
 	return &RobocatInput{
-		runner: runner,
+		runner:    runner,
+		transfers: make(map[string]*TransferBegin),
+		sessions:  make(map[string]struct{}),
 	}
 }
 
@@ -23,40 +35,137 @@ func (r *RobocatInput) Handle(
 ) {
 	file, err := ParseFileFromMessage(message)
 	if err != nil {
-		message.ReplyWithError(err)
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "unable to deserialize body: %s", err)
 		return
 	}
 
-	if len(file.Path) == 0 {
-		message.ReplyWithError(errors.New("file path must not be empty"))
+	if file == nil || len(file.Path) == 0 {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "file path must not be empty")
 		return
 	}
 
-	inputBasePath, err := r.runner.GetFlowBasePath("input")
+	basePath, err := r.directory(message.Session(), file.Ref, file.Scope)
 	if err != nil {
 		message.ReplyWithError(err)
 		return
 	}
 
-	absolutePath := path.Join(inputBasePath, file.Path)
-
-	log.Debugw("Creating directory for input", "path", path.Dir(absolutePath))
-
-	err = os.MkdirAll(path.Dir(absolutePath), 0777)
+	err = r.write(basePath, file.Path, file.Payload)
 	if err != nil {
 		message.ReplyWithError(err)
 		return
 	}
 
-	log.Debugw("Writing input", "path", absolutePath)
+	r.runner.metrics.receiveInput(len(file.Payload))
 
-	err = os.WriteFile(absolutePath, file.Payload, 0644)
-	if err != nil {
-		message.ReplyWithError(err)
+	message.Reply("status", "ok")
+
+	log.Debugw("Written input", "file", file.Path, "len", len(file.Payload))
+}
+
+// Directory the input is written to - input directory of the run in
+// progress when the ref is set, directory of session inputs for session
+// scope and shared input directory otherwise.
+func (r *RobocatInput) directory(session *Session, ref string, scope string) (string, error) {
+	if len(ref) > 0 {
+		run, ok := r.runner.GetRun(ref)
+		if !ok {
+			return "", NewError(ErrorNotRunning, "run %s is not running", ref)
+		}
+
+		var principal *Principal
+		if session != nil {
+			principal = session.Principal()
+		}
+
+		if !principal.controls(run) {
+			return "", NewError(ErrorUnauthorized, "run %s was started by another client", ref)
+		}
+
+		return r.runner.runPath(ref, "input")
+	}
+
+	switch scope {
+	case "":
+		return r.runner.GetFlowBasePath("input")
+	case InputScopeSession:
+		if session == nil {
+			return "", NewError(ErrorInvalidArguments, "session scope requires a session")
+		}
+
+		path, err := r.runner.sessionInputPath(session)
+		if err != nil {
+			return "", err
+		}
+
+		r.removeOnClose(session, path)
+
+		return path, nil
+	default:
+		return "", NewError(ErrorInvalidArguments, "unknown input scope: '%s'", scope)
+	}
+}
+
+// Remove inputs of the session once the session is closed.
+func (r *RobocatInput) removeOnClose(session *Session, dir string) {
+	if !r.runner.runIsolation() {
+		// Session inputs are written to shared input directory.
 		return
 	}
 
-	message.Reply("status", "ok")
+	r.sessionsMu.Lock()
+	defer r.sessionsMu.Unlock()
 
-	log.Debugw("Written input", "file", file.Path, "len", len(file.Payload))
+	if _, ok := r.sessions[session.ID()]; ok {
+		return
+	}
+
+	r.sessions[session.ID()] = struct{}{}
+
+	go func() {
+		<-session.Context().Done()
+
+		r.sessionsMu.Lock()
+		delete(r.sessions, session.ID())
+		r.sessionsMu.Unlock()
+
+		// Directory of the session is removed along with its inputs.
+		err := os.RemoveAll(path.Dir(dir))
+		if err != nil {
+			log.Warnw("Unable to remove session inputs", "error", err, "session", session.ID())
+		}
+	}()
+}
+
+// Path of the input file inside the base directory. Paths escaping the
+// directory (i.e. with "..") are rejected, so that clients are unable to
+// write anywhere else.
+func inputFilePath(basePath string, filePath string) (string, error) {
+	absolutePath := filepath.Join(basePath, filePath)
+
+	relativePath, err := filepath.Rel(basePath, absolutePath)
+	if err != nil || relativePath == "." || relativePath == ".." ||
+		strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", NewError(ErrorInvalidArguments, "file path is outside of input directory: '%s'", filePath)
+	}
+
+	return absolutePath, nil
+}
+
+func (r *RobocatInput) write(basePath string, filePath string, payload []byte) error {
+	absolutePath, err := inputFilePath(basePath, filePath)
+	if err != nil {
+		return err
+	}
+
+	log.Debugw("Creating directory for input", "path", path.Dir(absolutePath))
+
+	err = os.MkdirAll(path.Dir(absolutePath), 0777)
+	if err != nil {
+		return err
+	}
+
+	log.Debugw("Writing input", "path", absolutePath)
+
+	return os.WriteFile(absolutePath, payload, 0644)
 }
@@ -0,0 +1,313 @@
+package ws
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+)
+
+// Directory keeping partially received files until the transfer is ended.
+const transfersPath = ".transfers"
+
+// Owner of the transfers started by the session - transfers are kept per
+// principal, so that they can be resumed over another connection, but not
+// by other clients.
+func transferOwner(session *Session) string {
+	if session == nil || session.Principal() == nil {
+		return transferKey("")
+	}
+
+	principal := session.Principal()
+
+	return transferKey(principal.Method + ":" + principal.Name)
+}
+
+// Key of the transfer in the map of transfers in progress.
+func transferMapKey(owner string, id string) string {
+	return owner + "/" + id
+}
+
+func (r *RobocatInput) transferPath(owner string, id string) (string, error) {
+	return r.runner.GetFlowBasePath(transfersPath, "input", owner, id)
+}
+
+// Remove stale transfers (along with transfers whose files were removed).
+// Must be called without input mutex held.
+func (r *RobocatInput) expireTransfers() {
+	r.runner.collectTransfers()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.transfers {
+		owner, id := path.Split(key)
+
+		transferPath, err := r.transferPath(path.Clean(owner), id)
+		if err != nil {
+			continue
+		}
+
+		if _, err := os.Stat(transferPath); os.IsNotExist(err) {
+			delete(r.transfers, key)
+		}
+	}
+}
+
+// Parse message body and make sure transfer ID is valid.
+func parseTransferBody(message *Message, body transferBody) bool {
+	err := message.Decode(body)
+	if err != nil {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "unable to deserialize body: %s", err)
+		return false
+	}
+
+	if !transferIDPattern.MatchString(body.transferID()) {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "invalid transfer id: '%s'", body.transferID())
+		return false
+	}
+
+	return true
+}
+
+// Start chunked transfer of the input file or resume the transfer with the
+// same ID. Offset to continue the transfer from is sent in reply.
+func (r *RobocatInput) Begin(
+	ctx context.Context,
+	message *Message,
+) {
+	var begin TransferBegin
+
+	if !parseTransferBody(message, &begin) {
+		return
+	}
+
+	if len(begin.Path) == 0 {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "file path must not be empty")
+		return
+	}
+
+	// Target is checked in advance, so that the file is not uploaded in
+	// vain.
+	basePath, err := r.directory(message.Session(), begin.Ref, begin.Scope)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	if _, err := inputFilePath(basePath, begin.Path); err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	r.expireTransfers()
+
+	owner := transferOwner(message.Session())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	transferPath, err := r.transferPath(owner, begin.ID)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	err = os.MkdirAll(path.Dir(transferPath), 0777)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	// Partially received file is kept, so that the transfer can be resumed.
+	file, err := os.OpenFile(transferPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	r.transfers[transferMapKey(owner, begin.ID)] = &begin
+
+	log.Debugw("Started input transfer", "id", begin.ID, "file", begin.Path, "offset", offset)
+
+	message.Reply("transfer", TransferStatus{ID: begin.ID, Offset: offset})
+}
+
+// Append chunk to the transferred file. Chunk must start exactly where the
+// previously received data ends.
+func (r *RobocatInput) Chunk(
+	ctx context.Context,
+	message *Message,
+) {
+	var chunk TransferChunk
+
+	if !parseTransferBody(message, &chunk) {
+		return
+	}
+
+	owner := transferOwner(message.Session())
+	key := transferMapKey(owner, chunk.ID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.transfers[key]; !ok {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "transfer '%s' was not started", chunk.ID)
+		return
+	}
+
+	transferPath, err := r.transferPath(owner, chunk.ID)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	file, err := os.OpenFile(transferPath, os.O_WRONLY, 0644)
+	if os.IsNotExist(err) {
+		// File of the stale transfer was removed.
+		delete(r.transfers, key)
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "transfer '%s' was not started", chunk.ID)
+		return
+	}
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+	defer file.Close()
+
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	if offset != chunk.Offset {
+		message.ReplyWithError(NewError(
+			ErrorInvalidArguments,
+			"chunk offset %d does not match received size %d",
+			chunk.Offset, offset,
+		).WithDetails(TransferStatus{ID: chunk.ID, Offset: offset}))
+		return
+	}
+
+	n, err := file.Write(chunk.Payload)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	r.runner.metrics.receiveInput(n)
+
+	message.Reply("transfer", TransferStatus{ID: chunk.ID, Offset: offset + int64(n)})
+}
+
+// Verify checksum of the transferred file and move it to the input
+// directory.
+func (r *RobocatInput) End(
+	ctx context.Context,
+	message *Message,
+) {
+	var end TransferEnd
+
+	if !parseTransferBody(message, &end) {
+		return
+	}
+
+	owner := transferOwner(message.Session())
+	key := transferMapKey(owner, end.ID)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	begin, ok := r.transfers[key]
+	if !ok {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "transfer '%s' was not started", end.ID)
+		return
+	}
+
+	transferPath, err := r.transferPath(owner, end.ID)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	checksum, size, err := fileChecksum(transferPath)
+	if os.IsNotExist(err) {
+		delete(r.transfers, key)
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "transfer '%s' was not started", end.ID)
+		return
+	}
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	if checksum != end.Checksum || (begin.Size > 0 && size != begin.Size) {
+		// Corrupted file cannot be resumed, so transfer has to start over.
+		delete(r.transfers, key)
+		os.Remove(transferPath)
+
+		message.ReplyWithErrorCode(
+			ErrorChecksumMismatch,
+			"checksum of '%s' does not match (%d bytes received)",
+			begin.Path, size,
+		)
+		return
+	}
+
+	basePath, err := r.directory(message.Session(), begin.Ref, begin.Scope)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	absolutePath, err := inputFilePath(basePath, begin.Path)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	err = os.MkdirAll(path.Dir(absolutePath), 0777)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	err = os.Rename(transferPath, absolutePath)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	delete(r.transfers, key)
+
+	message.Reply("status", "ok")
+
+	log.Debugw("Written input", "file", begin.Path, "len", size)
+}
+
+func fileChecksum(filePath string) (string, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return "", 0, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
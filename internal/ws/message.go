@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 )
 
 type MessageType string
@@ -14,30 +15,128 @@ const (
 )
 
 type Message struct {
-	server *Server
+	session *Session
+	// Codec the message was decoded with (JSON when not set).
+	codec Codec
+	// Original value of the body of outgoing message - it is encoded only
+	// when the message is sent, with the codec of the session.
+	value interface{}
+	// Body value encoded with codecs so far.
+	encoded *encodedBody
 
 	Type MessageType     `json:"type"`
 	Name string          `json:"name"`
 	Body json.RawMessage `json:"body,omitempty"`
 	Ref  string          `json:"ref,omitempty"`
+	// Sequence number of the update within the run (set only for updates
+	// of flow runs, starting from 1).
+	Seq uint64 `json:"seq,omitempty"`
+	// W3C trace context of the command ("traceparent" and "tracestate"), so
+	// that server spans are linked to the span of the client.
+	Trace map[string]string `json:"trace,omitempty"`
 }
 
+// Encode message as JSON.
 func (m *Message) Bytes() ([]byte, error) {
-	bytes, err := json.Marshal(m)
+	return JSONCodec.EncodeMessage(m)
+}
+
+// Decode body of the message into the value using the codec the message was
+// received with.
+func (m *Message) Decode(v interface{}) error {
+	if m.value != nil {
+		body, err := m.encodeBody(JSONCodec)
+		if err != nil {
+			return err
+		}
+
+		return JSONCodec.Unmarshal(body, v)
+	}
+
+	return m.bodyCodec().Unmarshal(m.Body, v)
+}
+
+func (m *Message) bodyCodec() Codec {
+	if m.codec == nil {
+		return JSONCodec
+	}
+
+	return m.codec
+}
+
+// Body of the message encoded with the codec. Body value is encoded at most
+// once per codec, since the same update may be sent to sessions using
+// different codecs (i.e. replayed to a re-attached client).
+func (m *Message) encodeBody(codec Codec) ([]byte, error) {
+	if m.value != nil {
+		return m.encoded.get(codec, m.value)
+	}
+
+	if len(m.Body) == 0 || m.bodyCodec().Subprotocol() == codec.Subprotocol() {
+		return m.Body, nil
+	}
+
+	return transcodeBody(m, codec)
+}
+
+// Size of the encoded body - body value is expected to be encoded already
+// (with any codec).
+func (m *Message) size() int {
+	if m.value != nil {
+		return m.encoded.size()
+	}
+
+	return len(m.Body)
+}
+
+type encodedBody struct {
+	mu sync.Mutex
+	// Encoded body keyed by subprotocol of the codec.
+	bodies map[string][]byte
+	// Size of the body encoded first.
+	first int
+}
+
+func (b *encodedBody) get(codec Codec, value interface{}) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if body, ok := b.bodies[codec.Subprotocol()]; ok {
+		return body, nil
+	}
+
+	body, err := marshalBody(codec, value)
 	if err != nil {
 		return nil, err
 	}
 
-	return bytes, nil
+	if len(b.bodies) == 0 {
+		b.first = len(body)
+	}
+
+	b.bodies[codec.Subprotocol()] = body
+
+	return body, nil
+}
+
+func (b *encodedBody) size() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.first
 }
 
 func (m *Message) Text() (string, error) {
 	var text *string
-	err := json.Unmarshal(m.Body, &text)
+	err := m.Decode(&text)
 	if err != nil {
 		return "", err
 	}
 
+	if text == nil {
+		return "", errors.New("message body is empty")
+	}
+
 	return *text, nil
 }
 
@@ -55,8 +154,8 @@ func (m *Message) Reply(name string, body ...interface{}) error {
 		return errors.New("message is not a command")
 	}
 
-	if m.server == nil {
-		return errors.New("message server must be set")
+	if m.session == nil {
+		return errors.New("message session must be set")
 	}
 
 	update, err := newUpdate(name, body...)
@@ -65,26 +164,29 @@ func (m *Message) Reply(name string, body ...interface{}) error {
 	}
 
 	update.Ref = m.Ref
-	return m.server.sendUpdate(update)
+	return m.session.sendUpdate(update)
+}
+
+// Session the message was received from (set only for commands).
+func (m *Message) Session() *Session {
+	return m.session
 }
 
 func (m *Message) ReplyWithError(err error) error {
-	return m.Reply("error", err.Error())
+	return m.Reply("error", NewErrorBody(err))
 }
 
 func (m *Message) ReplyWithErrorf(format string, a ...any) error {
 	return m.ReplyWithError(fmt.Errorf(format, a...))
 }
 
-func MessageFromBytes(bytes []byte) (*Message, error) {
-	var message *Message
-
-	err := json.Unmarshal(bytes, &message)
-	if err != nil {
-		return nil, err
-	}
+func (m *Message) ReplyWithErrorCode(code ErrorCode, format string, a ...any) error {
+	return m.ReplyWithError(NewError(code, format, a...))
+}
 
-	return message, nil
+// Decode JSON-encoded message.
+func MessageFromBytes(bytes []byte) (*Message, error) {
+	return JSONCodec.DecodeMessage(bytes)
 }
 
 func NewMessageWithBody(name string, body ...interface{}) (*Message, error) {
@@ -99,12 +201,8 @@ func NewMessageWithBody(name string, body ...interface{}) (*Message, error) {
 	}
 
 	if actualBody != nil {
-		bytes, err := json.Marshal(actualBody)
-		if err != nil {
-			return nil, err
-		}
-
-		message.Body = json.RawMessage(bytes)
+		message.value = actualBody
+		message.encoded = &encodedBody{bodies: make(map[string][]byte)}
 	}
 
 	return message, nil
@@ -121,13 +219,13 @@ func newUpdate(name string, body ...interface{}) (*Message, error) {
 	return message, nil
 }
 
-func commandFromBytes(bytes []byte) (*Message, error) {
-	message, err := MessageFromBytes(bytes)
+func commandFromBytes(codec Codec, bytes []byte) (*Message, error) {
+	message, err := codec.DecodeMessage(bytes)
 	if err != nil {
 		return nil, err
 	}
 
-	if message.Type != Command {
+	if message == nil || message.Type != Command {
 		return nil, errors.New("message is not a command")
 	}
 
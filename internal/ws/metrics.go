@@ -0,0 +1,176 @@
+package ws
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Path of Prometheus metrics endpoint.
+const MetricsPath = "/metrics"
+
+// Reasons connections and runs are rejected for.
+const (
+	// Credentials are missing or invalid.
+	RejectUnauthorized = "unauthorized"
+	// Client does not speak robocat subprotocol.
+	RejectSubprotocol = "subprotocol"
+	// Run was rejected since another flow is running.
+	RejectBusy = "busy"
+)
+
+// Prometheus metrics of the server and its runs. Every server has its own
+// registry, so that several servers can live in the same process.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	connectionsActive   prometheus.Gauge
+	connectionsRejected *prometheus.CounterVec
+	commands            *prometheus.CounterVec
+
+	queueLength prometheus.Gauge
+
+	runsStarted   prometheus.Counter
+	runsSucceeded prometheus.Counter
+	runsFailed    *prometheus.CounterVec
+	runDuration   *prometheus.HistogramVec
+
+	outputFiles      prometheus.Counter
+	outputBytes      prometheus.Counter
+	inputBytes       prometheus.Counter
+	watchdogTimeouts prometheus.Counter
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		connectionsActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "robocat_connections_active",
+			Help: "Number of connected websocket sessions.",
+		}),
+		connectionsRejected: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "robocat_connections_rejected_total",
+			Help: "Number of rejected connections and runs by reason (unauthorized, subprotocol or busy).",
+		}, []string{"reason"}),
+		commands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "robocat_commands_total",
+			Help: "Number of commands received by name.",
+		}, []string{"command"}),
+
+		queueLength: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "robocat_queue_length",
+			Help: "Number of runs waiting for their turn.",
+		}),
+
+		runsStarted: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "robocat_runs_started_total",
+			Help: "Number of started runs.",
+		}),
+		runsSucceeded: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "robocat_runs_succeeded_total",
+			Help: "Number of runs finished successfully.",
+		}),
+		runsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "robocat_runs_failed_total",
+			Help: "Number of failed or stopped runs by error code (or termination reason when there is no error).",
+		}, []string{"code"}),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "robocat_run_duration_seconds",
+			Help:    "Duration of finished runs by termination reason.",
+			Buckets: []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600},
+		}, []string{"reason"}),
+
+		outputFiles: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "robocat_output_files_total",
+			Help: "Number of output files sent.",
+		}),
+		outputBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "robocat_output_bytes_total",
+			Help: "Total size of output files sent.",
+		}),
+		inputBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "robocat_input_bytes_total",
+			Help: "Total size of input files received.",
+		}),
+		watchdogTimeouts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "robocat_watchdog_timeouts_total",
+			Help: "Number of runs stopped since automation has not started in time.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.connectionsActive,
+		m.connectionsRejected,
+		m.commands,
+		m.queueLength,
+		m.runsStarted,
+		m.runsSucceeded,
+		m.runsFailed,
+		m.runDuration,
+		m.outputFiles,
+		m.outputBytes,
+		m.inputBytes,
+		m.watchdogTimeouts,
+	)
+
+	return m
+}
+
+// Handler serving metrics in Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// Count received command - unknown commands are counted together, so that
+// clients are unable to create arbitrary number of series.
+func (m *Metrics) countCommand(name string, known bool) {
+	if !known {
+		name = "unknown"
+	}
+
+	m.commands.WithLabelValues(name).Inc()
+}
+
+func (m *Metrics) reject(reason string) {
+	m.connectionsRejected.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) sendOutput(size int) {
+	m.outputFiles.Inc()
+	m.outputBytes.Add(float64(size))
+}
+
+func (m *Metrics) receiveInput(size int) {
+	m.inputBytes.Add(float64(size))
+}
+
+// Record the finished run.
+func (m *Metrics) finishRun(run *RobocatRun, result *RunResult) {
+	m.runDuration.WithLabelValues(string(result.Reason)).Observe(result.Duration.Seconds())
+
+	if result.Reason == ReasonSuccess {
+		m.runsSucceeded.Inc()
+		return
+	}
+
+	if result.Reason == ReasonWatchdog {
+		m.watchdogTimeouts.Inc()
+	}
+
+	code := string(result.Reason)
+
+	var e *Error
+	if errors.As(run.Err(), &e) {
+		code = string(e.Code)
+	} else if run.Err() != nil {
+		code = string(ErrorInternal)
+	}
+
+	m.runsFailed.WithLabelValues(code).Inc()
+}
@@ -0,0 +1,110 @@
+package ws_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	robocat "github.com/robocat-ai/robocat/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// Scrape metrics and return sample values keyed by series (i.e.
+// `robocat_commands_total{command="run"}`).
+func scrapeMetrics(t *testing.T, s *testServer) map[string]string {
+	res := apiRequest(t, http.MethodGet, probeURL(s, ws.MetricsPath), "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	samples := make(map[string]string)
+	scanner := bufio.NewScanner(res.Body)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if i := strings.LastIndex(line, " "); i > 0 {
+			samples[line[:i]] = line[i+1:]
+		}
+	}
+
+	return samples
+}
+
+func TestMetrics(t *testing.T) {
+	var s *testServer
+	s = startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		// Giving output watcher time to start.
+		time.Sleep(200 * time.Millisecond)
+
+		output, err := s.runner.GetFlowBasePath("output")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(filepath.Join(output, "result.bin"), []byte("12345"), 0644)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(500 * time.Millisecond)
+
+		if args.Data == "fail" {
+			return exitError(3)
+		}
+
+		return nil
+	}, func(server *ws.Server) {
+		server.Username = "robocat"
+		server.Password = "secret"
+	})
+
+	_, err := robocat.Connect(s.address)
+	assert.Error(t, err)
+
+	client, err := robocat.Connect(s.address, robocat.Credentials{Username: "robocat", Password: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	assert.NoError(t, client.Input("input.txt", []byte("1234567890")))
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Wait())
+
+	flow = client.Flow("fake").WithData("fail").WithTimeout(5 * time.Second).Run()
+	assert.Error(t, flow.Wait())
+
+	// Metrics require credentials as well.
+	res := apiRequest(t, http.MethodGet, probeURL(s, ws.MetricsPath), "")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	s.address = strings.Replace(s.address, "ws://", "ws://robocat:secret@", 1)
+
+	samples := scrapeMetrics(t, s)
+
+	assert.Equal(t, "1", samples["robocat_connections_active"])
+	assert.Equal(t, "2", samples[`robocat_connections_rejected_total{reason="unauthorized"}`])
+	assert.Equal(t, "1", samples[`robocat_commands_total{command="input"}`])
+	assert.Equal(t, "2", samples[`robocat_commands_total{command="run"}`])
+	assert.Equal(t, "2", samples["robocat_runs_started_total"])
+	assert.Equal(t, "1", samples["robocat_runs_succeeded_total"])
+	assert.Equal(t, "1", samples[fmt.Sprintf(`robocat_runs_failed_total{code="%s"}`, ws.ErrorProcessExit)])
+	assert.Equal(t, "1", samples[`robocat_run_duration_seconds_count{reason="success"}`])
+	assert.Equal(t, "2", samples["robocat_output_files_total"])
+	assert.Equal(t, "10", samples["robocat_output_bytes_total"])
+	assert.Equal(t, "10", samples["robocat_input_bytes_total"])
+	assert.Equal(t, "0", samples["robocat_watchdog_timeouts_total"])
+}
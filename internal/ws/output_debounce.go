@@ -0,0 +1,66 @@
+package ws
+
+import "time"
+
+// Debouncer keeping track of files that were recently written to, so that
+// a file is sent only once after the last write to it.
+type outputDebouncer struct {
+	window  time.Duration
+	pending map[string]time.Time
+}
+
+func newOutputDebouncer(window time.Duration) *outputDebouncer {
+	return &outputDebouncer{
+		window:  window,
+		pending: make(map[string]time.Time),
+	}
+}
+
+// Record write to the file, postponing sending it.
+func (d *outputDebouncer) touch(path string, now time.Time) {
+	d.pending[path] = now.Add(d.window)
+}
+
+// Remove and return files with no writes during the debounce window.
+func (d *outputDebouncer) due(now time.Time) []string {
+	var paths []string
+
+	for path, deadline := range d.pending {
+		if !deadline.After(now) {
+			paths = append(paths, path)
+			delete(d.pending, path)
+		}
+	}
+
+	return paths
+}
+
+// Remove and return all pending files.
+func (d *outputDebouncer) flush() []string {
+	var paths []string
+
+	for path := range d.pending {
+		paths = append(paths, path)
+		delete(d.pending, path)
+	}
+
+	return paths
+}
+
+// Time left until the next pending file is due, false when nothing is
+// pending.
+func (d *outputDebouncer) next(now time.Time) (time.Duration, bool) {
+	var earliest time.Time
+
+	for _, deadline := range d.pending {
+		if earliest.IsZero() || deadline.Before(earliest) {
+			earliest = deadline
+		}
+	}
+
+	if earliest.IsZero() {
+		return 0, false
+	}
+
+	return earliest.Sub(now), true
+}
@@ -0,0 +1,34 @@
+package ws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOutputDebouncer(t *testing.T) {
+	debouncer := newOutputDebouncer(100 * time.Millisecond)
+	now := time.Now()
+
+	_, ok := debouncer.next(now)
+	assert.False(t, ok)
+
+	debouncer.touch("a", now)
+	debouncer.touch("b", now.Add(50*time.Millisecond))
+
+	wait, ok := debouncer.next(now)
+	assert.True(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+
+	// Writing to the file again postpones sending it.
+	debouncer.touch("a", now.Add(80*time.Millisecond))
+
+	assert.Empty(t, debouncer.due(now.Add(100*time.Millisecond)))
+	assert.Equal(t, []string{"b"}, debouncer.due(now.Add(150*time.Millisecond)))
+	assert.Equal(t, []string{"a"}, debouncer.due(now.Add(180*time.Millisecond)))
+
+	debouncer.touch("c", now)
+	assert.Equal(t, []string{"c"}, debouncer.flush())
+	assert.Empty(t, debouncer.flush())
+}
@@ -0,0 +1,244 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// Output file sent to the client in chunks. The file is copied to the spool
+// directory first, so that it does not change while it is sent, and updates
+// of the transfer are read from disk every time they are sent. They are not
+// kept in the replay buffer, so the transfer is resumed after re-attaching
+// regardless of the size of the file.
+type outputTransfer struct {
+	begin     TransferBegin
+	end       TransferEnd
+	path      string
+	chunkSize int
+	// Sequence numbers of the updates sent so far - "output.begin", chunks
+	// in order of their offsets and "output.end".
+	seqs []uint64
+}
+
+// Number of updates of the transfer.
+func (t *outputTransfer) length() int {
+	chunkSize := int64(t.chunkSize)
+	return int((t.begin.Size+chunkSize-1)/chunkSize) + 2
+}
+
+// Update of the transfer with the index - chunks are read from the spooled
+// file.
+func (t *outputTransfer) update(i int) (*Message, error) {
+	switch i {
+	case 0:
+		return newUpdate("output.begin", t.begin)
+	case t.length() - 1:
+		return newUpdate("output.end", t.end)
+	}
+
+	offset := int64(i-1) * int64(t.chunkSize)
+
+	size := t.begin.Size - offset
+	if size > int64(t.chunkSize) {
+		size = int64(t.chunkSize)
+	}
+
+	file, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	payload := make([]byte, size)
+
+	_, err = file.ReadAt(payload, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	return newUpdate("output.chunk", TransferChunk{
+		ID:      t.begin.ID,
+		Offset:  offset,
+		Payload: payload,
+	})
+}
+
+// Name of the directory keeping transfers of the owner (i.e. run ref) - the
+// owner is hashed, so that any string can be used safely.
+func transferKey(owner string) string {
+	sum := sha256.Sum256([]byte(owner))
+	return hex.EncodeToString(sum[:16])
+}
+
+// Directory output files of the run are spooled to while they are sent in
+// chunks (removed once the run is released).
+func (r *RobocatRunner) outputTransfersPath(ref string) (string, error) {
+	return r.GetFlowBasePath(transfersPath, "output", transferKey(ref))
+}
+
+// Send large output file in chunks - client puts the file back together once
+// "output.end" is received. Size of the sent file is returned.
+func (r *RobocatRunner) sendOutputChunks(
+	run *RobocatRun,
+	path string,
+	mimeType string,
+	filePath string,
+) (int64, error) {
+	id := ulid.Make().String()
+
+	dir, err := r.outputTransfersPath(run.Ref)
+	if err != nil {
+		return 0, err
+	}
+
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	spoolPath := filepath.Join(dir, id)
+
+	size, checksum, err := copyFileWithChecksum(filePath, spoolPath)
+	if err != nil {
+		os.Remove(spoolPath)
+		return 0, err
+	}
+
+	if spool, err := os.Open(spoolPath); err == nil {
+		r.retainArtifact(run, path, mimeType, size, checksum, spool)
+		spool.Close()
+	}
+
+	transfer := &outputTransfer{
+		begin: TransferBegin{
+			ID:       id,
+			Path:     path,
+			MimeType: mimeType,
+			Size:     size,
+		},
+		end: TransferEnd{
+			ID:       id,
+			Checksum: checksum,
+		},
+		path:      spoolPath,
+		chunkSize: transferChunkSize(),
+	}
+
+	log.Debugw("Sending output in chunks", "file", path, "len", size, "id", id, "ref", run.Ref)
+
+	for i := 0; i < transfer.length(); i++ {
+		update, err := transfer.update(i)
+		if err != nil {
+			return size, err
+		}
+
+		run.replyTransfer(transfer, update)
+	}
+
+	return size, nil
+}
+
+// Remove files spooled for the run.
+func (r *RobocatRunner) removeOutputTransfers(run *RobocatRun) {
+	dir, err := r.outputTransfersPath(run.Ref)
+	if err == nil {
+		err = os.RemoveAll(dir)
+	}
+
+	if err != nil {
+		log.Warnw("Unable to remove output transfers", "error", err, "ref", run.Ref)
+	}
+}
+
+// Time partially received input files are kept for after they were last
+// written to.
+func transferTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("TRANSFER_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 24 * time.Hour
+	}
+
+	return ttl
+}
+
+// Remove spooled output files of runs that are no longer registered (i.e.
+// left after restart) and partially received input files that have not been
+// written to for TRANSFER_TTL.
+func (r *RobocatRunner) collectTransfers() {
+	outputs, err := r.GetFlowBasePath(transfersPath, "output")
+	if err != nil {
+		log.Warnw("Unable to remove stale transfers", "error", err)
+		return
+	}
+
+	// Directories are listed before runs are, so that spool directory of a
+	// run registered in the meantime is not removed.
+	entries, _ := os.ReadDir(outputs)
+
+	active := make(map[string]bool)
+
+	r.mu.Lock()
+	for ref := range r.runs {
+		active[transferKey(ref)] = true
+	}
+	r.mu.Unlock()
+
+	for _, entry := range entries {
+		if !active[entry.Name()] {
+			os.RemoveAll(filepath.Join(outputs, entry.Name()))
+		}
+	}
+
+	inputs, err := r.GetFlowBasePath(transfersPath, "input")
+	if err != nil {
+		return
+	}
+
+	expiredBefore := time.Now().Add(-transferTTL())
+
+	filepath.WalkDir(inputs, func(path string, entry os.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(expiredBefore) {
+			log.Debugw("Removing stale input transfer", "file", path)
+			os.Remove(path)
+		}
+
+		return nil
+	})
+}
+
+// Copy the file computing checksum of its contents on the way.
+func copyFileWithChecksum(src string, dst string) (int64, string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, "", err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return 0, "", err
+	}
+
+	hash := sha256.New()
+
+	size, err := io.Copy(io.MultiWriter(out, hash), in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		return 0, "", err
+	}
+
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Error reported by output watchers when the watched directory is removed.
+var errOutputRemoved = errors.New("output directory was removed")
+
+// Watcher reporting files created or written to inside of the output
+// directory (including nested directories).
+type outputWatcher interface {
+	// Paths of the files that were created or written to.
+	Files() <-chan string
+	// Errors of the watcher, errOutputRemoved is sent when the watched
+	// directory is removed.
+	Errors() <-chan error
+	Close() error
+}
+
+// Create output watcher for the directory. Watcher kind is selected with
+// OUTPUT_WATCHER environment variable ("notify" by default or "poll"), and
+// polling is used as a fallback when file system notifications are not
+// available.
+func newOutputWatcher(dir string) (outputWatcher, error) {
+	if os.Getenv("OUTPUT_WATCHER") == "poll" {
+		return newPollWatcher(dir, outputPollInterval)
+	}
+
+	w, err := newNotifyWatcher(dir)
+	if err != nil {
+		log.Warnw("Unable to create notify watcher - falling back to polling", "error", err)
+		return newPollWatcher(dir, outputPollInterval)
+	}
+
+	return w, nil
+}
+
+// Time to wait after the last write to the file before it is sent.
+func (r *RobocatRunner) outputDebounce() time.Duration {
+	debounce, err := time.ParseDuration(os.Getenv("OUTPUT_DEBOUNCE"))
+	if err != nil || debounce < 0 {
+		debounce = 100 * time.Millisecond
+	}
+
+	return debounce
+}
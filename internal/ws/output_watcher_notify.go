@@ -0,0 +1,147 @@
+package ws
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Output watcher relying on file system notifications (inotify on Linux).
+// Notifications are not recursive, so every nested directory is added to
+// the watcher as soon as it is created.
+type notifyWatcher struct {
+	w      *fsnotify.Watcher
+	root   string
+	files  chan string
+	errors chan error
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newNotifyWatcher(dir string) (*notifyWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	nw := &notifyWatcher{
+		w:      w,
+		root:   filepath.Clean(dir),
+		files:  make(chan string),
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+
+	if _, err := nw.addRecursive(nw.root); err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	go nw.forward()
+
+	return nw, nil
+}
+
+// Add directory with all of its subdirectories to the watcher and return
+// files that are already present in them.
+func (nw *notifyWatcher) addRecursive(dir string) ([]string, error) {
+	var files []string
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nw.w.Add(path)
+		}
+
+		files = append(files, path)
+
+		return nil
+	})
+
+	return files, err
+}
+
+func (nw *notifyWatcher) forward() {
+	for {
+		select {
+		case event, ok := <-nw.w.Events:
+			if !ok {
+				return
+			}
+
+			if event.Name == nw.root && (event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename)) {
+				nw.sendError(errOutputRemoved)
+				continue
+			}
+
+			if event.Has(fsnotify.Create) {
+				info, err := os.Stat(event.Name)
+				if err == nil && info.IsDir() {
+					// Files could have been written before the directory
+					// was added to the watcher.
+					files, err := nw.addRecursive(event.Name)
+					if err != nil {
+						log.Debugw("Unable to watch directory", "path", event.Name, "error", err)
+					}
+
+					for _, file := range files {
+						nw.sendFile(file)
+					}
+
+					continue
+				}
+			}
+
+			if event.Has(fsnotify.Create) || event.Has(fsnotify.Write) {
+				nw.sendFile(event.Name)
+			}
+		case err, ok := <-nw.w.Errors:
+			if !ok {
+				return
+			}
+
+			nw.sendError(err)
+		case <-nw.done:
+			return
+		}
+	}
+}
+
+func (nw *notifyWatcher) sendFile(path string) {
+	select {
+	case nw.files <- path:
+	case <-nw.done:
+	}
+}
+
+func (nw *notifyWatcher) sendError(err error) {
+	select {
+	case nw.errors <- err:
+	case <-nw.done:
+	}
+}
+
+func (nw *notifyWatcher) Files() <-chan string {
+	return nw.files
+}
+
+func (nw *notifyWatcher) Errors() <-chan error {
+	return nw.errors
+}
+
+func (nw *notifyWatcher) Close() error {
+	var err error
+
+	nw.once.Do(func() {
+		close(nw.done)
+		err = nw.w.Close()
+	})
+
+	return err
+}
@@ -0,0 +1,101 @@
+package ws
+
+import (
+	"sync"
+	"time"
+
+	"github.com/radovskyb/watcher"
+)
+
+// Interval between rescans of the output directory by the polling watcher.
+const outputPollInterval = 300 * time.Millisecond
+
+// Output watcher periodically rescanning the whole directory tree.
+type pollWatcher struct {
+	w      *watcher.Watcher
+	files  chan string
+	errors chan error
+	done   chan struct{}
+	once   sync.Once
+}
+
+func newPollWatcher(dir string, interval time.Duration) (*pollWatcher, error) {
+	w := watcher.New()
+
+	w.FilterOps(watcher.Create, watcher.Write)
+
+	if err := w.AddRecursive(dir); err != nil {
+		return nil, err
+	}
+
+	pw := &pollWatcher{
+		w:      w,
+		files:  make(chan string),
+		errors: make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		if err := w.Start(interval); err != nil {
+			pw.sendError(err)
+		}
+	}()
+
+	// Making sure watcher is started, otherwise closing it would not stop it.
+	w.Wait()
+
+	go pw.forward()
+
+	return pw, nil
+}
+
+func (pw *pollWatcher) forward() {
+	for {
+		select {
+		case event := <-pw.w.Event:
+			if event.IsDir() {
+				continue
+			}
+
+			select {
+			case pw.files <- event.Path:
+			case <-pw.done:
+				return
+			}
+		case err := <-pw.w.Error:
+			if err == watcher.ErrWatchedFileDeleted {
+				err = errOutputRemoved
+			}
+
+			pw.sendError(err)
+		case <-pw.w.Closed:
+			return
+		case <-pw.done:
+			return
+		}
+	}
+}
+
+func (pw *pollWatcher) sendError(err error) {
+	select {
+	case pw.errors <- err:
+	case <-pw.done:
+	}
+}
+
+func (pw *pollWatcher) Files() <-chan string {
+	return pw.files
+}
+
+func (pw *pollWatcher) Errors() <-chan error {
+	return pw.errors
+}
+
+func (pw *pollWatcher) Close() error {
+	pw.once.Do(func() {
+		close(pw.done)
+		pw.w.Close()
+	})
+
+	return nil
+}
@@ -0,0 +1,400 @@
+package ws
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Reason the run has ended for.
+type TerminationReason string
+
+const (
+	// Flow process has finished successfully.
+	ReasonSuccess TerminationReason = "success"
+	// Flow has reported an error or the process exited with an error.
+	ReasonError TerminationReason = "error"
+	// Run was stopped by the client.
+	ReasonClientStop TerminationReason = "client-stop"
+	// Run has exceeded its timeout.
+	ReasonTimeout TerminationReason = "timeout"
+	// Automation has not started in time.
+	ReasonWatchdog TerminationReason = "watchdog"
+	// Nobody has attached to the run after the client disconnected.
+	ReasonDisconnect TerminationReason = "disconnect"
+	// Run was replaced by a new run started by the same session.
+	ReasonReplaced TerminationReason = "replaced"
+	// Run was removed from the queue before it has started.
+	ReasonCanceled TerminationReason = "canceled"
+)
+
+// Check if the run was stopped before the flow process has finished.
+func (r TerminationReason) Stopped() bool {
+	return r != ReasonSuccess && r != ReasonError
+}
+
+// RobocatRun represents a single flow run. Run is not bound to a connection:
+// when the session that started the run disconnects, the run is detached and
+// its updates are buffered. Another session can attach to the run within
+// clean-up timeout and receive the updates it has missed.
+type RobocatRun struct {
+	Ref  string
+	Args *RunnerArguments
+	// Client that has started the run (nil if unknown).
+	Principal *Principal
+
+	// Run was started without session (over REST API).
+	headless bool
+	// Logs, files and result kept for REST API.
+	record *runRecord
+	// Span of the run (no-op span until the run is executed).
+	span trace.Span
+
+	// Queue state guarded by the runner mutex: priority and position of the
+	// queued run, channel closed once the run gets its turn and whether the
+	// run holds one of RUN_CONCURRENCY slots.
+	priority  int
+	position  int
+	turn      chan struct{}
+	executing bool
+	// Signaled (without blocking) when position of the queued run changes.
+	moved chan struct{}
+
+	mu          sync.Mutex
+	session     *Session
+	detachTimer *time.Timer
+	buffer      *updateBuffer
+	seq         uint64
+	reason      TerminationReason
+	err         error
+
+	startedAt time.Time
+	logs      int64
+	files     int64
+	// Output files sent during the run (only the latest version of each).
+	outputs []RunFile
+	// Output files sent in chunks - their updates are replayed from disk.
+	transfers []*outputTransfer
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func newRobocatRun(ref string, args *RunnerArguments, bufferSize int, bufferBytes int64) *RobocatRun {
+	run := &RobocatRun{
+		Ref:       ref,
+		Args:      args,
+		buffer:    newUpdateBuffer(bufferSize, bufferBytes),
+		startedAt: time.Now(),
+		span:      trace.SpanFromContext(context.Background()),
+		priority:  args.Priority,
+		turn:      make(chan struct{}),
+		moved:     make(chan struct{}, 1),
+	}
+
+	run.ctx, run.cancel = context.WithCancel(context.Background())
+
+	return run
+}
+
+// Mark the run as started once it has got its turn, so that time spent in
+// the queue is not included in its duration.
+func (run *RobocatRun) start() {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	run.startedAt = time.Now()
+}
+
+// Session the run is currently attached to (nil if the run is detached).
+func (run *RobocatRun) Session() *Session {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	return run.session
+}
+
+// Reason the run has ended for (empty if the run is still in progress).
+func (run *RobocatRun) Reason() TerminationReason {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	return run.reason
+}
+
+// End the run for the given reason. Only the first reason is recorded.
+func (run *RobocatRun) end(reason TerminationReason) {
+	run.mu.Lock()
+	if len(run.reason) == 0 {
+		run.reason = reason
+	}
+	run.mu.Unlock()
+
+	run.cancel()
+}
+
+// End the run because of the error. Error is only recorded if the run is
+// still in progress, since stopping the run may cause errors on its own.
+func (run *RobocatRun) fail(reason TerminationReason, err error) {
+	run.mu.Lock()
+	if len(run.reason) == 0 {
+		run.reason = reason
+		run.err = err
+	}
+	run.mu.Unlock()
+
+	run.cancel()
+}
+
+// Error the run has failed with (if any).
+func (run *RobocatRun) Err() error {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	return run.err
+}
+
+func (run *RobocatRun) countLog() {
+	atomic.AddInt64(&run.logs, 1)
+}
+
+func (run *RobocatRun) countFile() {
+	atomic.AddInt64(&run.files, 1)
+}
+
+// Add output file to the manifest of the run replacing its previous version.
+func (run *RobocatRun) addOutput(file RunFile) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	for i := range run.outputs {
+		if run.outputs[i].Path == file.Path {
+			run.outputs[i] = file
+			return
+		}
+	}
+
+	run.outputs = append(run.outputs, file)
+}
+
+// Output files sent during the run.
+func (run *RobocatRun) Outputs() []RunFile {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	return append([]RunFile(nil), run.outputs...)
+}
+
+// Build result of the finished run from the error returned by the process.
+func (run *RobocatRun) result(processErr error) *RunResult {
+	exitCode, signal := exitStatus(processErr)
+	finishedAt := time.Now()
+
+	run.mu.Lock()
+	startedAt := run.startedAt
+	run.mu.Unlock()
+
+	return &RunResult{
+		ExitCode:   exitCode,
+		Signal:     signal,
+		StartedAt:  startedAt,
+		FinishedAt: finishedAt,
+		Duration:   finishedAt.Sub(startedAt),
+		Reason:     run.Reason(),
+		Logs:       int(atomic.LoadInt64(&run.logs)),
+		Files:      int(atomic.LoadInt64(&run.files)),
+	}
+}
+
+// Check if the run is still in progress.
+func (run *RobocatRun) Active() bool {
+	return run.ctx.Err() == nil
+}
+
+// Attach the run to the session, replay buffered updates with sequence number
+// greater than seq and send all further updates to the session. When the
+// session is closed the run is stopped unless another session attaches to
+// it within the timeout.
+func (run *RobocatRun) attach(session *Session, seq uint64, timeout time.Duration) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	if run.detachTimer != nil {
+		run.detachTimer.Stop()
+		run.detachTimer = nil
+	}
+
+	run.session = session
+
+	err := run.replay(session, seq)
+	if err != nil {
+		log.Debugw("Unable to replay update", "error", err, "ref", run.Ref)
+	}
+
+	go func() {
+		select {
+		case <-run.ctx.Done():
+		case <-session.Context().Done():
+			run.detach(session, timeout)
+		}
+	}()
+}
+
+// Send updates with sequence number greater than seq to the session in
+// order - buffered updates along with updates of output transfers, which are
+// read from disk right before they are sent. Must be called with run mutex
+// held.
+func (run *RobocatRun) replay(session *Session, seq uint64) error {
+	type missedUpdate struct {
+		seq    uint64
+		update *Message
+		// Update of the transfer with the index (when update is not set).
+		transfer *outputTransfer
+		index    int
+	}
+
+	missed := make([]missedUpdate, 0)
+
+	for _, update := range run.buffer.since(seq) {
+		missed = append(missed, missedUpdate{seq: update.Seq, update: update})
+	}
+
+	for _, transfer := range run.transfers {
+		for i, s := range transfer.seqs {
+			if s > seq {
+				missed = append(missed, missedUpdate{seq: s, transfer: transfer, index: i})
+			}
+		}
+	}
+
+	sort.Slice(missed, func(i, j int) bool {
+		return missed[i].seq < missed[j].seq
+	})
+
+	for _, m := range missed {
+		update := m.update
+
+		if update == nil {
+			var err error
+
+			update, err = m.transfer.update(m.index)
+			if err != nil {
+				return err
+			}
+
+			update.Ref = run.Ref
+			update.Seq = m.seq
+		}
+
+		err := session.sendUpdate(update)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (run *RobocatRun) detach(session *Session, timeout time.Duration) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	if run.session != session {
+		// Another session has already attached to the run.
+		return
+	}
+
+	run.session = nil
+
+	if run.headless {
+		// Headless runs keep running until finished or stopped explicitly.
+		return
+	}
+
+	log.Debugw("Client disconnected - scheduling clean-up...", "ref", run.Ref)
+
+	run.detachTimer = time.AfterFunc(timeout, func() {
+		log.Debugw("Nobody attached to the run - stopping...", "ref", run.Ref)
+		run.end(ReasonDisconnect)
+	})
+}
+
+// Send an update of the run to the attached session. Updates are numbered
+// and buffered, so they are delivered even when the run is detached at the
+// moment - as long as some session attaches to it later.
+func (run *RobocatRun) reply(name string, body ...interface{}) error {
+	update, err := newUpdate(name, body...)
+	if err != nil {
+		return err
+	}
+
+	// Lock is held while sending the update to preserve order of updates.
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	run.seq++
+
+	update.Ref = run.Ref
+	update.Seq = run.seq
+
+	// Body is encoded in advance with the codec of the attached session (or
+	// the preferred one), so that its size is known to the buffer and it is
+	// not encoded again when sent.
+	codec := CBORCodec
+	if run.session != nil {
+		codec = run.session.codec
+	}
+
+	if _, err := update.encodeBody(codec); err != nil {
+		return err
+	}
+
+	run.buffer.push(update)
+
+	if run.record != nil {
+		run.record.add(update)
+	}
+
+	if run.session == nil {
+		return nil
+	}
+
+	return run.session.sendUpdate(update)
+}
+
+// Send an update of the output transfer. Such updates are numbered like any
+// other update, however they are not buffered - they are read from the
+// spooled file again when replayed.
+func (run *RobocatRun) replyTransfer(transfer *outputTransfer, update *Message) error {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	run.seq++
+
+	update.Ref = run.Ref
+	update.Seq = run.seq
+
+	if len(transfer.seqs) == 0 {
+		run.transfers = append(run.transfers, transfer)
+	}
+
+	transfer.seqs = append(transfer.seqs, run.seq)
+
+	if run.record != nil {
+		run.record.add(update)
+	}
+
+	if run.session == nil {
+		return nil
+	}
+
+	return run.session.sendUpdate(update)
+}
+
+func (run *RobocatRun) replyWithError(err error) error {
+	return run.reply("error", NewErrorBody(err))
+}
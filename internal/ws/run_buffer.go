@@ -0,0 +1,63 @@
+package ws
+
+// Fixed size ring buffer keeping the latest updates of a run, so that they
+// can be replayed to a client that re-attaches after disconnect. Besides the
+// number of updates, total size of their bodies is limited as well (unless
+// maxBytes is 0).
+type updateBuffer struct {
+	items    []*Message
+	start    int
+	size     int
+	bytes    int64
+	maxBytes int64
+}
+
+func newUpdateBuffer(capacity int, maxBytes int64) *updateBuffer {
+	if capacity < 1 {
+		capacity = 1
+	}
+
+	return &updateBuffer{
+		items:    make([]*Message, capacity),
+		maxBytes: maxBytes,
+	}
+}
+
+func (b *updateBuffer) push(update *Message) {
+	capacity := len(b.items)
+
+	if b.size == capacity {
+		// Buffer is full - drop the oldest update.
+		b.dropOldest()
+	}
+
+	b.items[(b.start+b.size)%capacity] = update
+	b.size++
+	b.bytes += int64(update.size())
+
+	// The latest update is kept even if it exceeds the limit on its own.
+	for b.maxBytes > 0 && b.bytes > b.maxBytes && b.size > 1 {
+		b.dropOldest()
+	}
+}
+
+func (b *updateBuffer) dropOldest() {
+	b.bytes -= int64(b.items[b.start].size())
+	b.items[b.start] = nil
+	b.start = (b.start + 1) % len(b.items)
+	b.size--
+}
+
+// Updates with sequence number greater than the given one in order.
+func (b *updateBuffer) since(seq uint64) []*Message {
+	updates := make([]*Message, 0)
+
+	for i := 0; i < b.size; i++ {
+		update := b.items[(b.start+i)%len(b.items)]
+		if update.Seq > seq {
+			updates = append(updates, update)
+		}
+	}
+
+	return updates
+}
@@ -0,0 +1,49 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func sequenceOf(updates []*Message) []uint64 {
+	seq := make([]uint64, 0, len(updates))
+	for _, update := range updates {
+		seq = append(seq, update.Seq)
+	}
+
+	return seq
+}
+
+func TestUpdateBuffer(t *testing.T) {
+	buffer := newUpdateBuffer(3, 0)
+
+	for i := uint64(1); i <= 2; i++ {
+		buffer.push(&Message{Seq: i})
+	}
+
+	assert.Equal(t, []uint64{1, 2}, sequenceOf(buffer.since(0)))
+	assert.Equal(t, []uint64{2}, sequenceOf(buffer.since(1)))
+
+	for i := uint64(3); i <= 5; i++ {
+		buffer.push(&Message{Seq: i})
+	}
+
+	assert.Equal(t, []uint64{3, 4, 5}, sequenceOf(buffer.since(0)))
+	assert.Equal(t, []uint64{5}, sequenceOf(buffer.since(4)))
+	assert.Empty(t, buffer.since(5))
+}
+
+func TestUpdateBufferBytes(t *testing.T) {
+	buffer := newUpdateBuffer(10, 10)
+
+	for i := uint64(1); i <= 3; i++ {
+		buffer.push(&Message{Seq: i, Body: []byte("1234")})
+	}
+
+	assert.Equal(t, []uint64{2, 3}, sequenceOf(buffer.since(0)))
+
+	buffer.push(&Message{Seq: 4, Body: []byte("1234567890ab")})
+
+	assert.Equal(t, []uint64{4}, sequenceOf(buffer.since(0)))
+}
@@ -0,0 +1,165 @@
+package ws
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Line of the flow log kept in the run record.
+type RunLogLine struct {
+	// Stream the line was printed to ("log" or "stderr").
+	Stream string `json:"stream"`
+	Text   string `json:"text"`
+}
+
+// Logs, output files and result of a run collected from its updates, so that
+// runs can be inspected over REST API while they are in progress and after
+// they are finished. Only the latest version of every output file is kept
+// and the oldest files (then the oldest log lines) are dropped once total
+// size of the record exceeds the limit.
+type runRecord struct {
+	mu sync.Mutex
+
+	logs   []RunLogLine
+	files  []*RobocatFile
+	result *RunResult
+	err    *ErrorBody
+
+	// Total size of log lines and payloads of the files.
+	size    int64
+	maxSize int64
+
+	// Output files that are being transferred in chunks keyed by ID.
+	transfers map[string]*RobocatFile
+	payloads  map[string]*bytes.Buffer
+}
+
+func newRunRecord(maxSize int64) *runRecord {
+	return &runRecord{
+		maxSize:   maxSize,
+		transfers: make(map[string]*RobocatFile),
+		payloads:  make(map[string]*bytes.Buffer),
+	}
+}
+
+// Record the update of the run.
+func (rec *runRecord) add(update *Message) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	switch value := update.value.(type) {
+	case string:
+		if update.Name == "log" || update.Name == "stderr" {
+			rec.logs = append(rec.logs, RunLogLine{Stream: update.Name, Text: value})
+			rec.size += int64(len(value))
+			rec.trim()
+		}
+	case RobocatFile:
+		file := value
+		rec.addFile(&file)
+	case TransferBegin:
+		// Files that would not fit into the record anyway are not
+		// collected.
+		if value.Size > rec.maxSize {
+			return
+		}
+
+		rec.transfers[value.ID] = &RobocatFile{Path: value.Path, MimeType: value.MimeType}
+		rec.payloads[value.ID] = bytes.NewBuffer(make([]byte, 0, value.Size))
+	case TransferChunk:
+		if payload, ok := rec.payloads[value.ID]; ok {
+			payload.Write(value.Payload)
+		}
+	case TransferEnd:
+		file, ok := rec.transfers[value.ID]
+		if !ok {
+			return
+		}
+
+		file.Payload = rec.payloads[value.ID].Bytes()
+
+		delete(rec.transfers, value.ID)
+		delete(rec.payloads, value.ID)
+
+		// Corrupted file does not replace the previous version.
+		if Checksum(file.Payload) == value.Checksum {
+			rec.addFile(file)
+		}
+	case *RunResult:
+		rec.result = value
+	case *ErrorBody:
+		rec.err = value
+	}
+}
+
+// Add the file replacing the previous version with the same path. Must be
+// called with record mutex held.
+func (rec *runRecord) addFile(file *RobocatFile) {
+	for i, previous := range rec.files {
+		if previous.Path == file.Path {
+			rec.size -= int64(len(previous.Payload))
+			rec.files = append(rec.files[:i], rec.files[i+1:]...)
+			break
+		}
+	}
+
+	rec.files = append(rec.files, file)
+	rec.size += int64(len(file.Payload))
+	rec.trim()
+}
+
+// Drop the oldest files and then the oldest log lines until the record fits
+// into the limit. Must be called with record mutex held.
+func (rec *runRecord) trim() {
+	for rec.size > rec.maxSize && len(rec.files) > 0 {
+		rec.size -= int64(len(rec.files[0].Payload))
+		rec.files = rec.files[1:]
+	}
+
+	for rec.size > rec.maxSize && len(rec.logs) > 0 {
+		rec.size -= int64(len(rec.logs[0].Text))
+		rec.logs = rec.logs[1:]
+	}
+}
+
+// Recorded log lines of the stream (all streams when empty).
+func (rec *runRecord) Logs(stream string) []RunLogLine {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	logs := make([]RunLogLine, 0, len(rec.logs))
+	for _, line := range rec.logs {
+		if len(stream) == 0 || line.Stream == stream {
+			logs = append(logs, line)
+		}
+	}
+
+	return logs
+}
+
+// Recorded output files in order they were last written.
+func (rec *runRecord) Files() []*RobocatFile {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	files := make([]*RobocatFile, len(rec.files))
+	copy(files, rec.files)
+
+	return files
+}
+
+// Result of the finished run (nil if the run is in progress).
+func (rec *runRecord) Result() *RunResult {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.result
+}
+
+// Error the run has failed with (if any).
+func (rec *runRecord) Err() *ErrorBody {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+
+	return rec.err
+}
@@ -0,0 +1,70 @@
+package ws
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func record(t *testing.T, rec *runRecord, name string, body interface{}) {
+	update, err := newUpdate(name, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec.add(update)
+}
+
+func recordedPaths(rec *runRecord) []string {
+	paths := make([]string, 0)
+	for _, file := range rec.Files() {
+		paths = append(paths, file.Path)
+	}
+
+	return paths
+}
+
+func TestRunRecordVersions(t *testing.T) {
+	rec := newRunRecord(100)
+
+	for _, payload := range []string{"first", "second", "third"} {
+		record(t, rec, "output", RobocatFile{Path: "a.txt", Payload: []byte(payload)})
+	}
+
+	record(t, rec, "output", RobocatFile{Path: "b.txt", Payload: []byte("b")})
+
+	// Only the latest version of the file is kept.
+	files := rec.Files()
+	if assert.Len(t, files, 2) {
+		assert.Equal(t, "third", string(files[0].Payload))
+	}
+
+	assert.Equal(t, int64(len("third")+len("b")), rec.size)
+
+	record(t, rec, "output", RobocatFile{Path: "a.txt", Payload: []byte("fourth")})
+	assert.Equal(t, []string{"b.txt", "a.txt"}, recordedPaths(rec))
+}
+
+func TestRunRecordMaxSize(t *testing.T) {
+	rec := newRunRecord(10)
+
+	record(t, rec, "log", "12345")
+	record(t, rec, "output", RobocatFile{Path: "a.bin", Payload: make([]byte, 4)})
+	record(t, rec, "output", RobocatFile{Path: "b.bin", Payload: make([]byte, 4)})
+
+	// The oldest files are dropped first.
+	assert.Equal(t, []string{"b.bin"}, recordedPaths(rec))
+	assert.Len(t, rec.Logs(""), 1)
+
+	record(t, rec, "log", "67890")
+	record(t, rec, "log", "x")
+	assert.Empty(t, rec.Files())
+	assert.Equal(t, []RunLogLine{{"log", "67890"}, {"log", "x"}}, rec.Logs(""))
+
+	// Files larger than the limit are not collected at all.
+	record(t, rec, "output.begin", TransferBegin{ID: "1", Path: "large.bin", Size: 11})
+	record(t, rec, "output.chunk", TransferChunk{ID: "1", Payload: make([]byte, 11)})
+	record(t, rec, "output.end", TransferEnd{ID: "1", Checksum: Checksum(make([]byte, 11))})
+	assert.Empty(t, rec.Files())
+	assert.Len(t, rec.Logs(""), 2)
+}
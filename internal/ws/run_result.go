@@ -0,0 +1,55 @@
+package ws
+
+import (
+	"errors"
+	"syscall"
+	"time"
+)
+
+// Structured result of a run sent as "result" update right before the final
+// status of the run.
+type RunResult struct {
+	// Exit code of the flow process (-1 if the process was killed by signal
+	// or has not exited).
+	ExitCode int `json:"exitCode"`
+	// Signal that killed the process (if any).
+	Signal     string    `json:"signal,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	// Duration of the run in nanoseconds.
+	Duration time.Duration     `json:"duration"`
+	Reason   TerminationReason `json:"reason"`
+	// Number of log lines produced by the flow.
+	Logs int `json:"logs"`
+	// Number of output files sent by the flow.
+	Files int `json:"files"`
+}
+
+// Error reported by a process that has exited with non-zero code.
+type ExitCodeError interface {
+	error
+	ExitCode() int
+}
+
+// Extract exit code and signal from error returned by ExecutorProcess.Wait.
+func exitStatus(err error) (int, string) {
+	if err == nil {
+		return 0, ""
+	}
+
+	var exitErr ExitCodeError
+	if !errors.As(err, &exitErr) {
+		return -1, ""
+	}
+
+	signal := ""
+
+	if sys, ok := exitErr.(interface{ Sys() any }); ok {
+		status, ok := sys.Sys().(syscall.WaitStatus)
+		if ok && status.Signaled() {
+			signal = status.Signal().String()
+		}
+	}
+
+	return exitErr.ExitCode(), signal
+}
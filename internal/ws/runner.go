@@ -2,25 +2,54 @@ package ws
 
 import (
 	"context"
-	"encoding/json"
-	"os/exec"
+	"errors"
+	"fmt"
+	"os"
 	"path"
 	"path/filepath"
+	"sync"
+	"time"
 )
 
+// Time to wait for the flow process to exit after it was stopped.
+const processExitTimeout = 10 * time.Second
+
 type RobocatRunner struct {
-	abortScheduledCleanupSignal chan bool
-	cleanupScheduled            bool
-	input                       *RobocatInput
+	// Directory with flow inputs and outputs ("flow" by default).
+	FlowPath string
+
+	mu sync.Mutex
+
+	executor Executor
+	input    *RobocatInput
+	// Metrics of the server the runner is registered on.
+	metrics *Metrics
 
-	ctx    context.Context
-	cancel context.CancelFunc
+	// Runs that are currently in progress keyed by ref.
+	runs map[string]*RobocatRun
+	// Finished runs kept for REST API keyed by ref.
+	records map[string]*RobocatRun
+	// Runs waiting for their turn ordered by priority.
+	queue []*RobocatRun
+	// Number of runs that have got their turn and are not finished yet.
+	executing int
+	// Start of the latest run using shared directories.
+	sharedRunStarted time.Time
+	// Store of finished runs (opened on first use).
+	historyStore *historyStore
+	// Store of output files retained after runs (opened on first use).
+	artifactStore *artifactStore
+	// Scheduler of recurring runs (loaded on first use).
+	scheduler *scheduler
 }
 
-func NewRobocatRunner() *RobocatRunner {
+func NewRobocatRunner(executor Executor) *RobocatRunner {
 	runner := &RobocatRunner{
-		abortScheduledCleanupSignal: make(chan bool),
-		cleanupScheduled:            false,
+		FlowPath: "flow",
+		executor: executor,
+		metrics:  NewMetrics(),
+		runs:     make(map[string]*RobocatRun),
+		records:  make(map[string]*RobocatRun),
 	}
 
 	runner.input = NewRobocatInput(runner)
@@ -28,12 +57,46 @@ func NewRobocatRunner() *RobocatRunner {
 	return runner
 }
 
+// Register runner commands on the server.
+func (r *RobocatRunner) Register(server *Server) {
+	server.executor = r.executor
+	r.metrics = server.metrics
+
+	server.On("run", r.Handle)
+	server.On("stop", r.Stop)
+	server.On("attach", r.Attach)
+	server.On("input", r.GetInput().Handle)
+	server.On("input.begin", r.GetInput().Begin)
+	server.On("input.chunk", r.GetInput().Chunk)
+	server.On("input.end", r.GetInput().End)
+	server.On("history", r.History)
+	server.On("history.get", r.HistoryGet)
+	server.On("artifacts.list", r.ArtifactsList)
+	server.On("artifacts.get", r.ArtifactsGet)
+	server.On("queue.list", r.QueueList)
+	server.On("queue.cancel", r.QueueCancel)
+	server.On("schedule.add", r.ScheduleAdd)
+	server.On("schedule.list", r.ScheduleList)
+	server.On("schedule.remove", r.ScheduleRemove)
+
+	server.Route(APIRunsPath, r)
+
+	server.AddReadinessCheck("flow-path", r.checkFlowPath)
+	server.AddReadinessCheck("executor", r.executor.Check)
+	server.AddReadinessCheck("run", r.checkAccepting)
+	server.AddReadinessDetail("run", r.runsInProgress)
+}
+
 func (r *RobocatRunner) GetInput() *RobocatInput {
 	return r.input
 }
 
+func (r *RobocatRunner) GetExecutor() Executor {
+	return r.executor
+}
+
 func (r *RobocatRunner) GetFlowBasePath(elem ...string) (string, error) {
-	finalPath, err := filepath.Abs("flow")
+	finalPath, err := filepath.Abs(r.FlowPath)
 	if err != nil {
 		return finalPath, err
 	}
@@ -45,105 +108,482 @@ func (r *RobocatRunner) GetFlowBasePath(elem ...string) (string, error) {
 	return finalPath, nil
 }
 
+// Check that flow inputs and outputs can be written.
+func (r *RobocatRunner) checkFlowPath() error {
+	basePath, err := r.GetFlowBasePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(basePath, ".readyz-*")
+	if err != nil {
+		return err
+	}
+
+	file.Close()
+
+	return os.Remove(file.Name())
+}
+
+// Check that another run would be accepted - started right away or queued.
+func (r *RobocatRunner) checkAccepting() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.executing < r.concurrency() && len(r.queue) == 0 {
+		return nil
+	}
+
+	if len(r.queue) < r.queueLength() {
+		return nil
+	}
+
+	return r.busyError()
+}
+
+// Describe runs in progress and waiting for their turn (if any).
+func (r *RobocatRunner) runsInProgress() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var detail string
+
+	switch r.executing {
+	case 0:
+	case 1:
+		for _, run := range r.runs {
+			if run.executing {
+				detail = fmt.Sprintf("run %s is in progress", run.Ref)
+			}
+		}
+	default:
+		detail = fmt.Sprintf("%d runs are in progress", r.executing)
+	}
+
+	if len(r.queue) > 0 {
+		if len(detail) > 0 {
+			detail += ", "
+		}
+
+		detail += fmt.Sprintf("%d queued", len(r.queue))
+	}
+
+	return detail
+}
+
+// Get run that is currently in progress by its ref.
+func (r *RobocatRunner) GetRun(ref string) (*RobocatRun, bool) {
+	run, ok := r.findRun(ref)
+	if !ok || !run.Active() {
+		return nil, false
+	}
+
+	return run, true
+}
+
+// Find run by its ref - including finished runs that are still retained.
+func (r *RobocatRunner) findRun(ref string) (*RobocatRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	run, ok := r.runs[ref]
+	return run, ok
+}
+
+// Check if the ref is used by another run - registered (running or queued),
+// retained for REST API or kept on disk (run directory or artifacts). Refs
+// are chosen by clients, so reusing them would let one client take over runs
+// of another one.
+func (r *RobocatRunner) refInUse(ref string) bool {
+	r.mu.Lock()
+	_, registered := r.runs[ref]
+	_, retained := r.records[ref]
+	r.mu.Unlock()
+
+	if registered || retained {
+		return true
+	}
+
+	if r.runIsolation() {
+		if path, err := r.runPath(ref); err == nil {
+			if _, err := os.Stat(path); err == nil {
+				return true
+			}
+		}
+	}
+
+	if artifacts, err := r.artifacts(); err == nil && artifactRefPattern.MatchString(ref) {
+		if _, err := os.Stat(artifacts.runPath(ref)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Register a new run for the session. Runs never stop each other - once
+// RUN_CONCURRENCY runs are in progress the run waits for its turn in the
+// queue and busy error is returned only when the queue is full. Runs started
+// without session (over REST API) are not attached to any session and are
+// never stopped on disconnect.
+func (r *RobocatRunner) acquire(
+	session *Session,
+	principal *Principal,
+	ref string,
+	args *RunnerArguments,
+) (*RobocatRun, *Error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, registered := r.runs[ref]
+	_, retained := r.records[ref]
+
+	if registered || retained {
+		return nil, NewError(ErrorInvalidArguments, "run %s already exists", ref)
+	}
+
+	queued := false
+
+	if r.executing >= r.concurrency() || len(r.queue) > 0 {
+		if len(r.queue) >= r.queueLength() {
+			return nil, r.busyError()
+		}
+
+		queued = true
+	}
+
+	run := newRobocatRun(ref, args, r.bufferSize(), r.bufferBytes())
+	run.Principal = principal
+	run.record = newRunRecord(r.recordMaxSize())
+
+	if session != nil {
+		run.attach(session, 0, r.cleanupTimeout())
+	} else {
+		run.headless = true
+	}
+
+	r.runs[ref] = run
+
+	if queued {
+		r.enqueue(run)
+	} else {
+		r.dispatch(run)
+	}
+
+	return run, nil
+}
+
+// Stop the run and remove it from the registry once clean-up timeout
+// passes, so that a client reconnecting in the meantime can still attach to
+// the run and receive its final updates.
+func (r *RobocatRunner) release(run *RobocatRun) {
+	run.cancel()
+
+	time.AfterFunc(r.cleanupTimeout(), func() {
+		r.mu.Lock()
+		if r.runs[run.Ref] == run {
+			delete(r.runs, run.Ref)
+		}
+		r.mu.Unlock()
+
+		// Output transfers cannot be replayed once the run is removed.
+		r.removeOutputTransfers(run)
+	})
+}
+
 func (r *RobocatRunner) Handle(
 	ctx context.Context,
 	message *Message,
 ) {
-	r.ctx, r.cancel = context.WithCancel(ctx)
+	var args *RunnerArguments
 
-	// In case of quick disconnect right after connection TagUI flow can
-	// still be running, so we try to kill previously running TagUI instance
-	// using scheduled clean-up. However, when the flow is run again we must
-	// abort scheduled clean-up and instead clean-up manually.
-	r.abortScheduledCleanup()
-	r.cleanup()
+	err := message.Decode(&args)
+	if err != nil || args == nil {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "unable to deserialize body: %v", err)
+		return
+	}
 
-	var args *RunnerArguments
+	timeout, err := args.GetTimeout()
+	if err != nil {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, err.Error())
+		return
+	}
+
+	// Checked before inputs are seeded, so that files of another run are
+	// left intact.
+	if r.refInUse(message.Ref) {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "run %s already exists", message.Ref)
+		return
+	}
+
+	session := message.Session()
 
-	err := json.Unmarshal(message.Body, &args)
+	err = r.seedSessionInputs(message.Ref, session)
 	if err != nil {
-		message.ReplyWithErrorf("unable to deserialize body: %s", err)
-		r.cancel()
+		message.ReplyWithErrorCode(ErrorInternal, "unable to seed inputs: %s", err)
+		return
+	}
+
+	run, rejection := r.acquire(session, session.Principal(), message.Ref, args)
+	if rejection != nil && rejection.Code != ErrorBusy {
+		message.ReplyWithError(rejection)
+		return
+	}
+
+	if rejection != nil {
+		log.Debugw("Another flow is already running - run rejected", "ref", message.Ref)
+		r.metrics.reject(RejectBusy)
+		r.removeRunDirectory(message.Ref)
+		message.ReplyWithError(rejection)
 		return
 	}
 
-	// Run the flow using base wrapper script (which is 'run' command
-	// inside container).
-	cmd := exec.Command("run", args.ToArray()...)
+	if !r.waitTurn(run) {
+		r.finish(run, nil)
+		return
+	}
+
+	r.execute(ctx, run, timeout)
+}
+
+// Start the flow process of the acquired run and stream its updates until
+// the run is finished. Context is only used as a parent of the run span.
+func (r *RobocatRunner) execute(ctx context.Context, run *RobocatRun, timeout time.Duration) {
+	args := run.Args
+
+	run.start()
+	r.metrics.runsStarted.Inc()
+	ctx = r.startRunSpan(ctx, run)
 
-	out, err := cmd.StdoutPipe()
+	// In case of quick disconnect right after connection the flow can
+	// still be running, so we try to kill previously running instance
+	// before starting a new one - unless other runs are in progress.
+	if r.runsExecuting() == 1 {
+		r.cleanup()
+	}
+
+	err := r.prepareRunDirectory(run)
 	if err != nil {
-		message.ReplyWithErrorf("unable to allocate stdout pipe: %s", err)
-		r.cancel()
+		run.fail(ReasonError, NewError(
+			ErrorInternal, "unable to prepare run directory: %s", err,
+		))
+		r.finish(run, err)
 		return
 	}
 
-	go r.watchLogs(r.ctx, message, out)
-	go r.watchOutput(r.ctx, message)
+	outputWatched := make(chan struct{})
+
+	go func() {
+		r.watchOutput(run)
+		close(outputWatched)
+	}()
 
-	cmdContext, cmdFinished := context.WithCancel(r.ctx)
+	_, startSpan := tracer().Start(ctx, "process.start")
+	process, err := r.executor.Start(args)
+	endSpan(startSpan, err)
+
+	if err != nil {
+		run.fail(ReasonError, NewError(
+			ErrorInternal, "unable to start %s: %s", r.executor.Name(), err,
+		))
+		<-outputWatched
+		r.finish(run, err)
+		return
+	}
+
+	if timeout > 0 {
+		timer := time.AfterFunc(timeout, func() {
+			log.Debugw("Run timeout reached", "timeout", timeout, "ref", run.Ref)
+			run.end(ReasonTimeout)
+		})
+		defer timer.Stop()
+	}
+
+	logsWatched := make(chan struct{})
+	watchdog := newStartWatchdog()
+
+	var logs sync.WaitGroup
+	logs.Add(2)
 
-	// Run command asynchrously using cmd.Run() method because it updates
-	// cmd.ProcessState upon process completion, so we can detect when
-	// the process ends.
 	go func() {
-		err = cmd.Start()
-		if err != nil {
-			message.ReplyWithErrorf("unable to start TagUI: %s", err)
-			r.cancel()
-			return
-		}
+		defer logs.Done()
+		r.watchLogs(run, process.Stdout(), "log", watchdog)
+	}()
 
-		err := cmd.Wait()
+	go func() {
+		defer logs.Done()
+		r.watchLogs(run, process.Stderr(), "stderr", watchdog)
+	}()
 
-		if err != nil {
-			message.ReplyWithErrorf("run finished with error: %s", err)
-			r.cancel()
-			return
+	go func() {
+		logs.Wait()
+		watchdog.disarm()
+		close(logsWatched)
+	}()
+
+	var processErr error
+	processExited := make(chan struct{})
+	exited := make(chan struct{})
+
+	go func() {
+		processErr = process.Wait()
+		close(processExited)
+
+		// Final status must not overtake the last log lines.
+		<-logsWatched
+
+		if processErr != nil {
+			exitCode, signal := exitStatus(processErr)
+
+			run.fail(ReasonError, NewError(
+				ErrorProcessExit, "run finished with error: %s", processErr,
+			).WithDetails(map[string]interface{}{
+				"exitCode": exitCode,
+				"signal":   signal,
+			}))
 		} else {
-			cmdFinished()
+			run.end(ReasonSuccess)
 		}
+
+		close(exited)
 	}()
 
-	log.Debugw("Running TagUI flow", "flow", args.Flow, "ref", message.Ref)
+	log.Debugw(
+		"Running flow",
+		"flow", args.Flow,
+		"executor", r.executor.Name(),
+		"principal", run.Principal.String(),
+		"ref", run.Ref,
+	)
 
-	message.Reply("status", "ok")
+	run.reply("status", "ok")
 
-loop:
-	for {
-		select {
-		// Waiting for parent (request) context to end or process state to
-		// change - whichever comes first.
-		case <-ctx.Done():
-			log.Debugw("TagUI disconnected - scheduling clean-up...", "ref", message.Ref)
-			go r.scheduleCleanup()
-			break loop
-		case <-r.ctx.Done():
-			log.Debugw("Received stop signal - stopping...", "ref", message.Ref)
-			go r.scheduleCleanup()
-			break loop
-		case <-cmdContext.Done():
-			log.Debugw("TagUI run finished", "ref", message.Ref)
-			message.Reply("status", "success")
-			break loop
-		}
+	// Waiting for the run to be stopped or process to exit - whichever
+	// comes first.
+	<-run.ctx.Done()
+
+	select {
+	case <-processExited:
+	default:
+		log.Debugw("Received stop signal - stopping...", "reason", run.Reason(), "ref", run.Ref)
+		r.executor.Stop(process)
+	}
+
+	var exitErr error
+
+	select {
+	case <-exited:
+		exitErr = processErr
+	case <-time.After(processExitTimeout):
+		log.Warnw("Flow process has not exited after stop", "ref", run.Ref)
+		exitErr = errors.New("process has not exited")
+	}
+
+	// Last output files are sent right after the run is stopped, so they
+	// must not be overtaken by the result either.
+	<-outputWatched
+
+	r.finish(run, exitErr)
+}
+
+// Send result and final status of the run and release the run.
+func (r *RobocatRunner) finish(run *RobocatRun, processErr error) {
+	reason := run.Reason()
+
+	log.Debugw("Flow run finished", "reason", reason, "ref", run.Ref)
+
+	result := run.result(processErr)
+	r.metrics.finishRun(run, result)
+	r.recordHistory(run, result)
+	endRunSpan(run, result)
+
+	// Directory is removed before the client learns that the run is
+	// finished.
+	r.cleanupRunDirectory(run)
+
+	run.reply("result", result)
+
+	if err := run.Err(); err != nil {
+		run.replyWithError(err)
+	}
+
+	if reason == ReasonSuccess {
+		run.reply("status", "success")
+	} else if reason.Stopped() {
+		// Final status of the run explaining why it was stopped.
+		run.reply("stopped", reason)
+	}
+
+	if run.record != nil {
+		r.retainRecord(run)
 	}
 
-	r.cancel()
+	r.release(run)
+	r.leave(run)
+
+	go r.collectArtifacts()
+	go r.collectTransfers()
 }
 
+// Stop the run referenced in the message body. When no ref is specified all
+// runs attached to the session of the message are stopped.
 func (r *RobocatRunner) Stop(
 	ctx context.Context,
 	message *Message,
 ) {
-	if r.ctx == nil || r.ctx.Err() != nil {
-		log.Debugw("TagUI run is not running - cannot stop", "ref", message.Ref)
-		message.ReplyWithErrorf("flow is not running - cannot stop")
+	var args *StopArguments
+
+	if len(message.Body) > 0 {
+		err := message.Decode(&args)
+		if err != nil {
+			message.ReplyWithErrorCode(ErrorInvalidArguments, "unable to deserialize body: %s", err)
+			return
+		}
+	}
+
+	stopped := 0
+	denied := false
+
+	r.mu.Lock()
+	for _, run := range r.runs {
+		if !run.Active() {
+			continue
+		}
+
+		if args != nil && len(args.Ref) > 0 {
+			if run.Ref != args.Ref {
+				continue
+			}
+
+			if !message.Session().Principal().controls(run) {
+				denied = true
+				continue
+			}
+		} else if run.Session() != message.Session() {
+			continue
+		}
+
+		run.end(ReasonClientStop)
+		stopped++
+	}
+	r.mu.Unlock()
+
+	if denied {
+		message.ReplyWithErrorCode(ErrorUnauthorized, "run %s was started by another client", args.Ref)
+		return
+	}
+
+	if stopped == 0 {
+		log.Debugw("Flow is not running - cannot stop", "ref", message.Ref)
+		message.ReplyWithErrorCode(ErrorNotRunning, "flow is not running - cannot stop")
 		return
 	}
 
-	log.Debugw("Sending stop signal...", "ref", message.Ref)
+	log.Debugw("Sent stop signal", "ref", message.Ref)
 
-	r.cancel()
 	message.Reply("status", "ok")
 }
@@ -0,0 +1,332 @@
+package ws
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/oklog/ulid/v2"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Path prefix of REST API for runs.
+const APIRunsPath = "/api/runs"
+
+// Run status reported by REST API.
+type RunStatus struct {
+	Ref  string `json:"ref"`
+	Flow string `json:"flow"`
+	// "running", "success", "error" or "stopped".
+	Status string `json:"status"`
+	// Result of the finished run.
+	Result *RunResult `json:"result,omitempty"`
+	// Error the run has failed with (if any).
+	Error *ErrorBody `json:"error,omitempty"`
+}
+
+// Output file listed by REST API.
+type RunFile struct {
+	Path     string `json:"path"`
+	MimeType string `json:"type"`
+	Size     int    `json:"size"`
+}
+
+// Time to keep logs and outputs of runs after they are finished.
+func (r *RobocatRunner) recordRetention() time.Duration {
+	retention, err := time.ParseDuration(os.Getenv("API_RUN_RETENTION"))
+	if err != nil {
+		retention = time.Hour
+	}
+
+	return retention
+}
+
+// Max total size of logs and output files kept in the record of a run.
+func (r *RobocatRunner) recordMaxSize() int64 {
+	size, err := units.FromHumanSize(os.Getenv("API_RUN_MAX_SIZE"))
+	if err != nil || size < 1 {
+		size = 16 * units.MB
+	}
+
+	return size
+}
+
+// Keep record of the finished run until retention period passes.
+func (r *RobocatRunner) retainRecord(run *RobocatRun) {
+	r.mu.Lock()
+	r.records[run.Ref] = run
+	r.mu.Unlock()
+
+	time.AfterFunc(r.recordRetention(), func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.records[run.Ref] == run {
+			delete(r.records, run.Ref)
+		}
+	})
+}
+
+// Find recorded run by its ref - in progress or finished within retention
+// period.
+func (r *RobocatRunner) findRecordedRun(ref string) (*RobocatRun, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if run, ok := r.records[ref]; ok {
+		return run, true
+	}
+
+	if run, ok := r.runs[ref]; ok && run.record != nil {
+		return run, true
+	}
+
+	return nil, false
+}
+
+func runStatus(run *RobocatRun) *RunStatus {
+	status := &RunStatus{
+		Ref:    run.Ref,
+		Flow:   run.Args.Flow,
+		Status: "running",
+		Result: run.record.Result(),
+		Error:  run.record.Err(),
+	}
+
+	if status.Result != nil {
+		status.Status = resultStatus(status.Result)
+	}
+
+	return status
+}
+
+// Status of the finished run - "success", "error" or "stopped".
+func resultStatus(result *RunResult) string {
+	switch reason := result.Reason; {
+	case reason == ReasonSuccess:
+		return "success"
+	case reason.Stopped():
+		return "stopped"
+	default:
+		return "error"
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+var apiErrorStatus = map[ErrorCode]int{
+	ErrorInvalidArguments: http.StatusBadRequest,
+	ErrorUnauthorized:     http.StatusForbidden,
+	ErrorBusy:             http.StatusConflict,
+	ErrorNotRunning:       http.StatusConflict,
+	ErrorFlowNotFound:     http.StatusNotFound,
+	ErrorNotFound:         http.StatusNotFound,
+}
+
+func writeAPIError(w http.ResponseWriter, err error) {
+	body := NewErrorBody(err)
+
+	status, ok := apiErrorStatus[body.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+
+	writeJSON(w, status, body)
+}
+
+// REST API for clients unable to keep websocket connection open (i.e. cron
+// jobs and shell scripts):
+//
+//	POST   /api/runs                    start a run
+//	GET    /api/runs/{ref}              status and result of the run
+//	GET    /api/runs/{ref}/logs         log lines of the run (text)
+//	GET    /api/runs/{ref}/files        list of output files
+//	GET    /api/runs/{ref}/files/{path} download output file
+//	DELETE /api/runs/{ref}              stop the run
+//
+// Runs started over REST API are not bound to any connection. Logs and output
+// files of every run (including runs started over websocket) are kept for
+// API_RUN_RETENTION after the run is finished. Runs can only be inspected and
+// stopped by clients that have started them or have "admin" scope.
+func (r *RobocatRunner) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, APIRunsPath), "/")
+	parts := strings.SplitN(path, "/", 3)
+
+	command := "run"
+	if req.Method == http.MethodDelete {
+		command = "stop"
+	}
+
+	principal := PrincipalFromContext(req.Context())
+	if principal != nil && !principal.Allows(command) {
+		writeAPIError(w, NewError(
+			ErrorUnauthorized, "%s scope is required", requiredScopes(command),
+		))
+		return
+	}
+
+	switch {
+	case len(path) == 0 && req.Method == http.MethodPost:
+		r.startHeadlessRun(w, req, principal)
+	case len(path) == 0:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		r.withRecordedRun(w, principal, parts[0], func(run *RobocatRun) {
+			writeJSON(w, http.StatusOK, runStatus(run))
+		})
+	case len(parts) == 1 && req.Method == http.MethodDelete:
+		r.stopRunByRef(w, principal, parts[0])
+	case len(parts) == 2 && parts[1] == "logs" && req.Method == http.MethodGet:
+		r.withRecordedRun(w, principal, parts[0], func(run *RobocatRun) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+			for _, line := range run.record.Logs(req.URL.Query().Get("stream")) {
+				w.Write([]byte(line.Text + "\n"))
+			}
+		})
+	case len(parts) == 2 && parts[1] == "files" && req.Method == http.MethodGet:
+		r.withRecordedRun(w, principal, parts[0], func(run *RobocatRun) {
+			files := make([]RunFile, 0)
+			for _, file := range run.record.Files() {
+				files = append(files, RunFile{
+					Path:     file.Path,
+					MimeType: file.MimeType,
+					Size:     len(file.Payload),
+				})
+			}
+
+			writeJSON(w, http.StatusOK, files)
+		})
+	case len(parts) == 3 && parts[1] == "files" && req.Method == http.MethodGet:
+		r.withRecordedRun(w, principal, parts[0], func(run *RobocatRun) {
+			for _, file := range run.record.Files() {
+				if file.Path == parts[2] {
+					serveRunFile(w, file)
+					return
+				}
+			}
+
+			http.NotFound(w, req)
+		})
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *RobocatRunner) withRecordedRun(
+	w http.ResponseWriter,
+	principal *Principal,
+	ref string,
+	f func(run *RobocatRun),
+) {
+	run, ok := r.findRecordedRun(ref)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, &ErrorBody{
+			Code:    ErrorNotRunning,
+			Message: "run " + ref + " not found",
+		})
+		return
+	}
+
+	if !principal.controls(run) {
+		writeAPIError(w, NewError(ErrorUnauthorized, "run %s was started by another client", ref))
+		return
+	}
+
+	f(run)
+}
+
+func (r *RobocatRunner) startHeadlessRun(w http.ResponseWriter, req *http.Request, principal *Principal) {
+	var args *RunnerArguments
+
+	err := json.NewDecoder(req.Body).Decode(&args)
+	if err != nil || args == nil || len(args.Flow) == 0 {
+		writeAPIError(w, NewError(ErrorInvalidArguments, "expected run arguments with flow name: %v", err))
+		return
+	}
+
+	timeout, err := args.GetTimeout()
+	if err != nil {
+		writeAPIError(w, NewError(ErrorInvalidArguments, err.Error()))
+		return
+	}
+
+	run, rejection := r.acquire(nil, principal, ulid.Make().String(), args)
+	if rejection != nil {
+		if rejection.Code == ErrorBusy {
+			log.Debug("Another flow is already running - REST run rejected")
+			r.metrics.reject(RejectBusy)
+		}
+
+		writeAPIError(w, rejection)
+		return
+	}
+
+	// Run span is linked to the caller when the request has "traceparent"
+	// header.
+	ctx := tracePropagator.Extract(context.Background(), propagation.HeaderCarrier(req.Header))
+
+	go func() {
+		if !r.waitTurn(run) {
+			r.finish(run, nil)
+			return
+		}
+
+		r.execute(ctx, run, timeout)
+	}()
+
+	w.Header().Set("Location", APIRunsPath+"/"+run.Ref)
+	writeJSON(w, http.StatusAccepted, runStatus(run))
+}
+
+func (r *RobocatRunner) stopRunByRef(w http.ResponseWriter, principal *Principal, ref string) {
+	run, ok := r.findRun(ref)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, &ErrorBody{
+			Code:    ErrorNotRunning,
+			Message: "run " + ref + " not found",
+		})
+		return
+	}
+
+	if !principal.controls(run) {
+		writeAPIError(w, NewError(ErrorUnauthorized, "run %s was started by another client", ref))
+		return
+	}
+
+	if !run.Active() {
+		writeAPIError(w, NewError(ErrorNotRunning, "run %s is not running", ref))
+		return
+	}
+
+	run.end(ReasonClientStop)
+
+	log.Debugw("Sent stop signal", "ref", ref)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func serveRunFile(w http.ResponseWriter, file *RobocatFile) {
+	mimeType := file.MimeType
+	if len(mimeType) == 0 {
+		mimeType = "application/octet-stream"
+	}
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(
+		"attachment", map[string]string{"filename": filepath.Base(file.Path)},
+	))
+
+	w.Write(file.Payload)
+}
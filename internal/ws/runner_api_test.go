@@ -0,0 +1,362 @@
+package ws_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	robocat "github.com/robocat-ai/robocat/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+func apiURL(s *testServer, path string) string {
+	return strings.Replace(s.address, "ws://", "http://", 1) + ws.APIRunsPath + path
+}
+
+func apiRequest(t *testing.T, method string, url string, body string) *http.Response {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		res.Body.Close()
+	})
+
+	return res
+}
+
+func decodeResponse(t *testing.T, res *http.Response, v interface{}) {
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func startAPIRun(t *testing.T, s *testServer, args string) *ws.RunStatus {
+	res := apiRequest(t, http.MethodPost, apiURL(s, ""), args)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	var status *ws.RunStatus
+	decodeResponse(t, res, &status)
+
+	assert.Equal(t, ws.APIRunsPath+"/"+status.Ref, res.Header.Get("Location"))
+
+	return status
+}
+
+// Poll run status until the run is finished.
+func waitAPIRun(t *testing.T, s *testServer, ref string) *ws.RunStatus {
+	var status *ws.RunStatus
+
+	assert.Eventually(t, func() bool {
+		res := apiRequest(t, http.MethodGet, apiURL(s, "/"+ref), "")
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+
+		decodeResponse(t, res, &status)
+
+		return status.Status != "running"
+	}, 10*time.Second, 50*time.Millisecond)
+
+	return status
+}
+
+func TestAPIRun(t *testing.T) {
+	var s *testServer
+	s = startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		// Giving output watcher time to start.
+		time.Sleep(200 * time.Millisecond)
+
+		fmt.Fprintf(stdout, "running %s with %s\n", args.Flow, args.Data)
+		fmt.Fprintln(stderr, "warning")
+
+		output, err := s.runner.GetFlowBasePath("output")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(filepath.Join(output, "result.json"), []byte(`{"ok":true}`), 0644)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(500 * time.Millisecond)
+
+		return nil
+	})
+
+	started := startAPIRun(t, s, `{"flow":"fake","data":"input"}`)
+	assert.Equal(t, "fake", started.Flow)
+	assert.Equal(t, "running", started.Status)
+
+	status := waitAPIRun(t, s, started.Ref)
+	assert.Equal(t, "success", status.Status)
+	if assert.NotNil(t, status.Result) {
+		assert.Equal(t, 0, status.Result.ExitCode)
+		assert.Equal(t, 2, status.Result.Logs)
+		assert.Equal(t, 1, status.Result.Files)
+	}
+
+	res := apiRequest(t, http.MethodGet, apiURL(s, "/"+started.Ref+"/logs"), "")
+	logs, _ := io.ReadAll(res.Body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(logs), "running fake with input\n")
+	assert.Contains(t, string(logs), "warning\n")
+
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/"+started.Ref+"/logs?stream=stderr"), "")
+	logs, _ = io.ReadAll(res.Body)
+	assert.Equal(t, "warning\n", string(logs))
+
+	var files []ws.RunFile
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/"+started.Ref+"/files"), "")
+	decodeResponse(t, res, &files)
+	assert.Equal(t, []ws.RunFile{{Path: "result.json", MimeType: "application/json", Size: 11}}, files)
+
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/"+started.Ref+"/files/result.json"), "")
+	payload, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "application/json", res.Header.Get("Content-Type"))
+	assert.Equal(t, `{"ok":true}`, string(payload))
+
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/"+started.Ref+"/files/missing.txt"), "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/unknown"), "")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestAPIWebsocketRun(t *testing.T) {
+	var s *testServer
+	s = startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		// Giving output watcher time to start.
+		time.Sleep(200 * time.Millisecond)
+
+		fmt.Fprintln(stdout, "started over websocket")
+
+		output, err := s.runner.GetFlowBasePath("output")
+		if err != nil {
+			return err
+		}
+
+		for _, payload := range []string{"first", "second"} {
+			err = os.WriteFile(filepath.Join(output, "result.txt"), []byte(payload), 0644)
+			if err != nil {
+				return err
+			}
+
+			time.Sleep(300 * time.Millisecond)
+		}
+
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+	assert.NoError(t, flow.Wait())
+
+	// Runs started over websocket are inspectable as well.
+	status := waitAPIRun(t, s, flow.Ref())
+	assert.Equal(t, "success", status.Status)
+
+	res := apiRequest(t, http.MethodGet, apiURL(s, "/"+flow.Ref()+"/logs"), "")
+	logs, _ := io.ReadAll(res.Body)
+	assert.Equal(t, "started over websocket\n", string(logs))
+
+	var files []ws.RunFile
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/"+flow.Ref()+"/files"), "")
+	decodeResponse(t, res, &files)
+	assert.Equal(t, []ws.RunFile{{Path: "result.txt", MimeType: "text/plain; charset=utf-8", Size: 6}}, files)
+}
+
+func TestAPIRunStop(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "0")
+
+	s := startTestServer(t, blockingScript)
+
+	started := startAPIRun(t, s, `{"flow":"fake"}`)
+
+	// Only one flow can run at a time when the queue is disabled.
+	res := apiRequest(t, http.MethodPost, apiURL(s, ""), `{"flow":"fake"}`)
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+
+	// Runs started over REST API are not replaced by websocket clients.
+	client := newTestClient(t, s.address)
+	flow := client.Flow("fake").Run()
+	assert.ErrorContains(t, flow.Wait(), "another flow is already running")
+
+	res = apiRequest(t, http.MethodDelete, apiURL(s, "/"+started.Ref), "")
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	status := waitAPIRun(t, s, started.Ref)
+	assert.Equal(t, "stopped", status.Status)
+	assert.Equal(t, ws.ReasonClientStop, status.Result.Reason)
+
+	res = apiRequest(t, http.MethodDelete, apiURL(s, "/"+started.Ref), "")
+	assert.Equal(t, http.StatusConflict, res.StatusCode)
+}
+
+func TestAPIRunInvalid(t *testing.T) {
+	s := startTestServer(t, noopScript)
+
+	res := apiRequest(t, http.MethodPost, apiURL(s, ""), `{"data":"no flow"}`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res = apiRequest(t, http.MethodPost, apiURL(s, ""), `{"flow":"fake","timeout":"soon"}`)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	var body *ws.ErrorBody
+	decodeResponse(t, res, &body)
+	assert.Equal(t, ws.ErrorInvalidArguments, body.Code)
+}
+
+func TestAPIRunAuth(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{
+			{Name: "cron", Key: "cron-key", Scopes: []string{"run"}},
+			{Name: "viewer", Key: "viewer-key", Scopes: []string{"input"}},
+		}
+	})
+
+	res := apiRequest(t, http.MethodPost, apiURL(s, ""), `{"flow":"fake"}`)
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+
+	res = apiRequest(t, http.MethodPost, apiURL(s, "?token=viewer-key"), `{"flow":"fake"}`)
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = apiRequest(t, http.MethodPost, apiURL(s, "?token=cron-key"), `{"flow":"fake"}`)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	var status *ws.RunStatus
+	decodeResponse(t, res, &status)
+
+	res = apiRequest(t, http.MethodDelete, apiURL(s, "/"+status.Ref+"?token=cron-key"), "")
+	assert.Equal(t, http.StatusForbidden, res.StatusCode, "'stop' scope is required")
+}
+
+func TestAPIRunOwnership(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "1")
+
+	s := startTestServer(t, blockingScript, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{
+			{Name: "alice", Key: "alice-key"},
+			{Name: "bob", Key: "bob-key"},
+			{Name: "ops", Key: "ops-key", Scopes: []string{"run", "stop", "admin"}},
+		}
+	})
+
+	start := func() *ws.RunStatus {
+		res := apiRequest(t, http.MethodPost, apiURL(s, "?token=alice-key"), `{"flow":"fake"}`)
+		assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+		var status *ws.RunStatus
+		decodeResponse(t, res, &status)
+
+		return status
+	}
+
+	running := start()
+	queued := start()
+
+	// Runs are hidden from and cannot be stopped by other clients.
+	res := apiRequest(t, http.MethodGet, apiURL(s, "/"+running.Ref+"?token=bob-key"), "")
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res = apiRequest(t, http.MethodDelete, apiURL(s, "/"+running.Ref+"?token=bob-key"), "")
+	assert.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	client, err := robocat.ConnectWithToken(s.address, "bob-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.CancelQueued(context.Background(), queued.Ref)
+	assert.ErrorIs(t, err, robocat.ErrUnauthorized)
+
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/"+running.Ref+"?token=alice-key"), "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// Admins control runs of everybody.
+	res = apiRequest(t, http.MethodDelete, apiURL(s, "/"+running.Ref+"?token=ops-key"), "")
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	res = apiRequest(t, http.MethodDelete, apiURL(s, "/"+queued.Ref+"?token=alice-key"), "")
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+}
+
+func TestDuplicateRef(t *testing.T) {
+	s := startTestServer(t, blockingScript, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{
+			{Name: "alice", Key: "alice-key"},
+			{Name: "bob", Key: "bob-key"},
+		}
+	})
+
+	res := apiRequest(t, http.MethodPost, apiURL(s, "?token=alice-key"), `{"flow":"fake"}`)
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	var started *ws.RunStatus
+	decodeResponse(t, res, &started)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, s.address+"?token=bob-key", &websocket.DialOptions{
+		Subprotocols: []string{ws.SubprotocolJSON},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	run := func() string {
+		command := `{"type":"command","name":"run","ref":"` + started.Ref + `","body":{"flow":"fake"}}`
+
+		err := conn.Write(ctx, websocket.MessageText, []byte(command))
+		assert.NoError(t, err)
+
+		_, reply, err := conn.Read(ctx)
+		assert.NoError(t, err)
+
+		return string(reply)
+	}
+
+	// Refs of other runs cannot be reused, so that the runs are not taken
+	// over - neither while they are running nor after they are finished.
+	assert.Contains(t, run(), `"code":"invalid-arguments"`)
+
+	res = apiRequest(t, http.MethodDelete, apiURL(s, "/"+started.Ref+"?token=alice-key"), "")
+	assert.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	assert.Eventually(t, func() bool {
+		res := apiRequest(t, http.MethodGet, apiURL(s, "/"+started.Ref+"?token=alice-key"), "")
+
+		var status *ws.RunStatus
+		decodeResponse(t, res, &status)
+
+		return status.Status == "stopped"
+	}, 5*time.Second, 50*time.Millisecond)
+
+	assert.Contains(t, run(), `"code":"invalid-arguments"`)
+
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/"+started.Ref+"?token=alice-key"), "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+}
@@ -3,12 +3,37 @@ package ws
 import (
 	"fmt"
 	"net/url"
+	"time"
 )
 
 type RunnerArguments struct {
 	Flow  string `json:"flow"`
 	Data  string `json:"data"`
 	Proxy string `json:"proxy"`
+	// Max duration of the run in time.ParseDuration format (i.e. "5m").
+	// Run is stopped by the server once the timeout is reached.
+	Timeout string `json:"timeout,omitempty"`
+	// Queued runs with higher priority are started first.
+	Priority int `json:"priority,omitempty"`
+
+	// Input and output directories of the run set by the server before the
+	// flow is started.
+	InputPath  string `json:"-"`
+	OutputPath string `json:"-"`
+}
+
+// Parsed run timeout (zero if timeout is not set).
+func (a *RunnerArguments) GetTimeout() (time.Duration, error) {
+	if len(a.Timeout) == 0 {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(a.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout: %w", err)
+	}
+
+	return timeout, nil
 }
 
 func (a *RunnerArguments) ToArray() []string {
@@ -33,3 +58,8 @@ func (a *RunnerArguments) ToArray() []string {
 
 	return args
 }
+
+type StopArguments struct {
+	// Ref of the run to stop.
+	Ref string `json:"ref,omitempty"`
+}
@@ -0,0 +1,195 @@
+package ws
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/docker/go-units"
+)
+
+// Store of output files retained after runs - ARTIFACTS_PATH or "artifacts"
+// in the flow directory by default.
+func (r *RobocatRunner) artifacts() (*artifactStore, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.artifactStore != nil {
+		return r.artifactStore, nil
+	}
+
+	path := os.Getenv("ARTIFACTS_PATH")
+	if len(path) == 0 {
+		var err error
+		path, err = r.GetFlowBasePath("artifacts")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.artifactStore = newArtifactStore(path)
+
+	return r.artifactStore, nil
+}
+
+// Time to retain artifacts after the run has written its last output file.
+func (r *RobocatRunner) artifactsTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("ARTIFACTS_TTL"))
+	if err != nil || ttl <= 0 {
+		ttl = 7 * 24 * time.Hour
+	}
+
+	return ttl
+}
+
+// Max total size of retained artifacts.
+func (r *RobocatRunner) artifactsMaxSize() int64 {
+	size, err := units.FromHumanSize(os.Getenv("ARTIFACTS_MAX_SIZE"))
+	if err != nil || size < 1 {
+		size = units.GB
+	}
+
+	return size
+}
+
+// Retain output file of the run. Failing to do so does not affect the run
+// itself - the file is still sent to the client.
+func (r *RobocatRunner) retainArtifact(
+	run *RobocatRun,
+	path string,
+	mimeType string,
+	size int64,
+	checksum string,
+	payload io.Reader,
+) {
+	artifacts, err := r.artifacts()
+	if err == nil {
+		err = artifacts.add(run.Ref, run.Args.Flow, run.Principal.owner(), Artifact{
+			Path:      path,
+			MimeType:  mimeType,
+			Size:      size,
+			SHA256:    checksum,
+			CreatedAt: time.Now(),
+		}, payload)
+	}
+
+	if err != nil {
+		log.Warnw("Unable to retain artifact", "error", err, "file", path, "ref", run.Ref)
+	}
+}
+
+// Remove old artifacts leaving those of the runs in progress intact.
+func (r *RobocatRunner) collectArtifacts() {
+	artifacts, err := r.artifacts()
+	if err == nil {
+		err = artifacts.collect(r.artifactsTTL(), r.artifactsMaxSize(), func(ref string) bool {
+			_, active := r.GetRun(ref)
+			return active
+		})
+	}
+
+	if err != nil {
+		log.Warnw("Unable to remove old artifacts", "error", err)
+	}
+}
+
+// Reply with manifest of the files retained for the run referenced in the
+// message body.
+func (r *RobocatRunner) ArtifactsList(
+	ctx context.Context,
+	message *Message,
+) {
+	var args *ArtifactsListArguments
+
+	err := message.Decode(&args)
+	if err != nil || args == nil || len(args.Ref) == 0 {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "expected ref of the run: %v", err)
+		return
+	}
+
+	manifest, ok := r.findManifest(message, args.Ref)
+	if !ok {
+		return
+	}
+
+	message.Reply("artifacts", manifest)
+}
+
+// Reply with a part of the retained file. Files are sent in parts, so that
+// clients with small read limit are able to download large files.
+func (r *RobocatRunner) ArtifactsGet(
+	ctx context.Context,
+	message *Message,
+) {
+	var args *ArtifactGetArguments
+
+	err := message.Decode(&args)
+	if err != nil || args == nil || len(args.Ref) == 0 || len(args.Path) == 0 {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "expected ref of the run and path of the file: %v", err)
+		return
+	}
+
+	manifest, ok := r.findManifest(message, args.Ref)
+	if !ok {
+		return
+	}
+
+	for _, artifact := range manifest.Files {
+		if artifact.Path != args.Path {
+			continue
+		}
+
+		if args.Offset < 0 || args.Offset > artifact.Size {
+			message.ReplyWithErrorCode(
+				ErrorInvalidArguments, "offset %d is out of range of %d bytes", args.Offset, artifact.Size,
+			)
+			return
+		}
+
+		artifacts, err := r.artifacts()
+		if err != nil {
+			message.ReplyWithError(err)
+			return
+		}
+
+		payload, err := artifacts.read(args.Ref, artifact, args.Offset, transferChunkSize())
+		if err != nil {
+			message.ReplyWithError(err)
+			return
+		}
+
+		message.Reply("artifact", &ArtifactPart{
+			Artifact: artifact,
+			Offset:   args.Offset,
+			Payload:  payload,
+		})
+		return
+	}
+
+	message.ReplyWithErrorCode(ErrorNotFound, "artifact %s of run %s not found", args.Path, args.Ref)
+}
+
+// Find manifest of the run replying with error when it cannot be found or
+// the run was started by another client.
+func (r *RobocatRunner) findManifest(message *Message, ref string) (*ArtifactManifest, bool) {
+	artifacts, err := r.artifacts()
+	if err != nil {
+		message.ReplyWithError(err)
+		return nil, false
+	}
+
+	manifest, err := artifacts.manifest(ref)
+	if err != nil {
+		message.ReplyWithError(err)
+		return nil, false
+	}
+
+	// Artifacts of other clients are not revealed.
+	if manifest == nil || !message.Session().Principal().owns(manifest.Principal) {
+		message.ReplyWithErrorCode(ErrorNotFound, "no artifacts retained for run %s", ref)
+		return nil, false
+	}
+
+	return manifest, true
+}
@@ -0,0 +1,153 @@
+package ws_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	robocat "github.com/robocat-ai/robocat/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArtifacts(t *testing.T) {
+	t.Setenv("TRANSFER_CHUNK_SIZE", "1KB")
+
+	large := bytes.Repeat([]byte("0123456789"), 500)
+
+	var s *testServer
+	s = startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		// Giving output watcher time to start.
+		time.Sleep(200 * time.Millisecond)
+
+		output, err := s.runner.GetFlowBasePath("output")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(filepath.Join(output, "result.txt"), []byte("first"), 0644)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(300 * time.Millisecond)
+
+		// Only the latest version of the file is retained.
+		err = os.WriteFile(filepath.Join(output, "result.txt"), []byte("second"), 0644)
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(filepath.Join(output, "large.bin"), large, 0644)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(300 * time.Millisecond)
+
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+	ctx := context.Background()
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Wait())
+
+	artifacts, err := client.Artifacts(flow.Ref())
+	assert.NoError(t, err)
+
+	if assert.Len(t, artifacts, 2) {
+		assert.Equal(t, "result.txt", artifacts[0].Path)
+		assert.Equal(t, int64(6), artifacts[0].Size)
+		assert.Equal(t, ws.Checksum([]byte("second")), artifacts[0].SHA256)
+		assert.False(t, artifacts[0].CreatedAt.IsZero())
+
+		assert.Equal(t, "large.bin", artifacts[1].Path)
+		assert.Equal(t, int64(len(large)), artifacts[1].Size)
+		assert.Equal(t, "application/octet-stream", artifacts[1].MimeType)
+	}
+
+	file, err := client.DownloadArtifact(ctx, flow.Ref(), "result.txt")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "second", file.Text())
+	}
+
+	// Downloaded in parts since the file is larger than a chunk.
+	file, err = client.DownloadArtifact(ctx, flow.Ref(), "large.bin")
+	if assert.NoError(t, err) {
+		assert.Equal(t, large, file.Payload)
+	}
+
+	_, err = client.DownloadArtifact(ctx, flow.Ref(), "missing.txt")
+	assert.ErrorIs(t, err, robocat.ErrNotFound)
+
+	_, err = client.Artifacts("missing")
+	assert.ErrorIs(t, err, robocat.ErrNotFound)
+
+	_, err = client.Artifacts("../output")
+	assert.ErrorIs(t, err, robocat.ErrNotFound)
+}
+
+func TestArtifactsOwnership(t *testing.T) {
+	var s *testServer
+	s = startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		// Giving output watcher time to start.
+		time.Sleep(200 * time.Millisecond)
+
+		output, err := s.runner.GetFlowBasePath("output", "secret.txt")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(output, []byte("secret"), 0644)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(300 * time.Millisecond)
+
+		return nil
+	}, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{
+			{Name: "alice", Key: "alice-key"},
+			{Name: "bob", Key: "bob-key"},
+			{Name: "ops", Key: "ops-key", Scopes: []string{"artifacts", "admin"}},
+		}
+	})
+
+	ctx := context.Background()
+
+	alice := newTokenClient(t, s.address, "alice-key")
+
+	flow := alice.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Wait())
+
+	artifacts, err := alice.Artifacts(flow.Ref())
+	assert.NoError(t, err)
+	assert.Len(t, artifacts, 1)
+
+	// Artifacts of other clients are hidden unless the client is admin.
+	bob := newTokenClient(t, s.address, "bob-key")
+
+	_, err = bob.Artifacts(flow.Ref())
+	assert.ErrorIs(t, err, robocat.ErrNotFound)
+
+	_, err = bob.DownloadArtifact(ctx, flow.Ref(), "secret.txt")
+	assert.ErrorIs(t, err, robocat.ErrNotFound)
+
+	ops := newTokenClient(t, s.address, "ops-key")
+
+	file, err := ops.DownloadArtifact(ctx, flow.Ref(), "secret.txt")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "secret", file.Text())
+	}
+}
@@ -0,0 +1,52 @@
+package ws
+
+import (
+	"context"
+)
+
+type AttachArguments struct {
+	// Ref of the run to attach to.
+	Ref string `json:"ref"`
+	// Sequence number of the last update received from the run. Buffered
+	// updates after it are replayed upon attaching.
+	Seq uint64 `json:"seq,omitempty"`
+}
+
+// Attach session of the message to a run, replay updates the session has
+// missed and stream further updates of the run to the session. Finished runs
+// can be attached to as well until clean-up timeout passes.
+func (r *RobocatRunner) Attach(
+	ctx context.Context,
+	message *Message,
+) {
+	var args *AttachArguments
+
+	err := message.Decode(&args)
+	if err != nil || args == nil {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "unable to deserialize body: %v", err)
+		return
+	}
+
+	run, ok := r.findRun(args.Ref)
+	if !ok {
+		log.Debugw("Flow is not running - cannot attach", "ref", args.Ref)
+		message.ReplyWithErrorCode(ErrorNotRunning, "flow %s is not running - cannot attach", args.Ref)
+		return
+	}
+
+	if !message.Session().Principal().controls(run) {
+		message.ReplyWithErrorCode(ErrorUnauthorized, "run %s was started by another client", args.Ref)
+		return
+	}
+
+	run.attach(message.Session(), args.Seq, r.cleanupTimeout())
+
+	log.Debugw(
+		"Session attached to the run",
+		"session", message.Session().ID(),
+		"ref", run.Ref,
+		"seq", args.Seq,
+	)
+
+	message.Reply("status", "ok")
+}
@@ -2,41 +2,47 @@ package ws
 
 import (
 	"os"
-	"os/exec"
+	"strconv"
 	"time"
-)
-
-func (r *RobocatRunner) scheduleCleanup() {
-	r.cleanupScheduled = true
 
-	log.Debug("Clean-up scheduled")
+	"github.com/docker/go-units"
+)
 
+// Time to wait for the client to attach to the run again after disconnect
+// before the run is stopped.
+func (r *RobocatRunner) cleanupTimeout() time.Duration {
 	cleanUpDuration, err := time.ParseDuration(os.Getenv("CLEANUP_TIMEOUT"))
 	if err != nil {
 		cleanUpDuration = time.Second
 	}
 
-	for {
-		select {
-		case <-r.abortScheduledCleanupSignal:
-			log.Debug("Scheduled clean-up aborted")
-			r.cleanupScheduled = false
-			return
-		case <-time.After(cleanUpDuration):
-			log.Debug("Cleaning up previous TagUI session")
-			r.cleanup()
-			r.cleanupScheduled = false
-			return
-		}
+	return cleanUpDuration
+}
+
+// Number of the latest updates of a run kept for replay after re-attaching.
+func (r *RobocatRunner) bufferSize() int {
+	size, err := strconv.Atoi(os.Getenv("RUN_BUFFER_SIZE"))
+	if err != nil || size < 1 {
+		size = 1000
 	}
+
+	return size
 }
 
-func (r *RobocatRunner) abortScheduledCleanup() {
-	if r.cleanupScheduled {
-		r.abortScheduledCleanupSignal <- true
+// Max total size of updates kept for replay, so that chunks of large output
+// files do not occupy too much memory.
+func (r *RobocatRunner) bufferBytes() int64 {
+	size, err := units.FromHumanSize(os.Getenv("RUN_BUFFER_BYTES"))
+	if err != nil || size < 1 {
+		size = 64 * units.MB
 	}
+
+	return size
 }
 
 func (r *RobocatRunner) cleanup() {
-	exec.Command("kill_tagui").Run()
+	err := r.executor.Cleanup()
+	if err != nil {
+		log.Debugw("Got error during clean-up", "error", err)
+	}
 }
@@ -0,0 +1,278 @@
+package ws
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// Scope of input files kept for runs started by the session that has
+// uploaded them.
+const InputScopeSession = "session"
+
+// What happens to the directory of the run once the run is finished.
+type RunCleanupPolicy string
+
+const (
+	// Directory is kept.
+	CleanupKeep RunCleanupPolicy = "keep"
+	// Directory is removed if the run has finished successfully, so that
+	// failed runs can be inspected.
+	CleanupOnSuccess RunCleanupPolicy = "on-success"
+	// Directory is always removed.
+	CleanupAlways RunCleanupPolicy = "always"
+)
+
+// Every run gets its own "runs/<ref>/input" and "runs/<ref>/output"
+// directories when RUN_ISOLATION is enabled, otherwise all runs share
+// "input" and "output" directories. Runs are never isolated when flows of
+// the executor cannot use directories of their run (i.e. TagUI).
+func (r *RobocatRunner) runIsolation() bool {
+	isolation, err := strconv.ParseBool(os.Getenv("RUN_ISOLATION"))
+	return err == nil && isolation && r.executor.Isolated()
+}
+
+// Copy shared inputs into input directory of the isolated run.
+func (r *RobocatRunner) seedRunInputs() bool {
+	seed, err := strconv.ParseBool(os.Getenv("RUN_SEED_INPUTS"))
+	return err != nil || seed
+}
+
+// Clear shared directories before runs that are not isolated, so that files
+// of the previous run do not leak into the next one.
+func (r *RobocatRunner) clearShared() bool {
+	clear, err := strconv.ParseBool(os.Getenv("RUN_CLEAR_SHARED"))
+	return err != nil || clear
+}
+
+// Clean-up policy of isolated run directories.
+func (r *RobocatRunner) runCleanupPolicy() RunCleanupPolicy {
+	switch policy := RunCleanupPolicy(os.Getenv("RUN_CLEANUP")); policy {
+	case CleanupKeep, CleanupAlways:
+		return policy
+	default:
+		return CleanupOnSuccess
+	}
+}
+
+// Path inside the directory of the run - shared flow directory is used
+// unless runs are isolated.
+func (r *RobocatRunner) runPath(ref string, elem ...string) (string, error) {
+	if !r.runIsolation() {
+		return r.GetFlowBasePath(elem...)
+	}
+
+	if !artifactRefPattern.MatchString(ref) {
+		return "", NewError(ErrorInvalidArguments, "ref cannot be used as directory name: '%s'", ref)
+	}
+
+	return r.GetFlowBasePath(append([]string{"runs", ref}, elem...)...)
+}
+
+// Directory of inputs kept for runs started by the session (shared input
+// directory unless runs are isolated).
+func (r *RobocatRunner) sessionInputPath(session *Session) (string, error) {
+	if !r.runIsolation() {
+		return r.GetFlowBasePath("input")
+	}
+
+	return r.GetFlowBasePath("sessions", session.ID(), "input")
+}
+
+// Copy inputs of the session into input directory of the isolated run
+// before the run is registered. Inputs of the session are removed once the
+// session is closed, which may happen before the queued run gets its turn.
+func (r *RobocatRunner) seedSessionInputs(ref string, session *Session) error {
+	if !r.runIsolation() || session == nil {
+		return nil
+	}
+
+	inputPath, err := r.runPath(ref, "input")
+	if err != nil {
+		return err
+	}
+
+	sessionPath, err := r.sessionInputPath(session)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(inputPath, 0755); err != nil {
+		return err
+	}
+
+	return copyDirectory(sessionPath, inputPath, true)
+}
+
+// Create input and output directories of the run and pass them to the
+// executor through run arguments. Isolated runs are seeded with shared
+// inputs - files that are already in the input directory of the run (i.e.
+// session inputs or inputs sent to the queued run) take precedence.
+func (r *RobocatRunner) prepareRunDirectory(run *RobocatRun) error {
+	inputPath, err := r.runPath(run.Ref, "input")
+	if err != nil {
+		return err
+	}
+
+	outputPath, err := r.runPath(run.Ref, "output")
+	if err != nil {
+		return err
+	}
+
+	for _, path := range []string{inputPath, outputPath} {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+	}
+
+	run.Args.InputPath = inputPath
+	run.Args.OutputPath = outputPath
+
+	if !r.runIsolation() {
+		// Directories of other runs in progress are left intact.
+		if r.clearShared() && r.runsExecuting() == 1 {
+			return r.clearSharedDirectories(inputPath, outputPath)
+		}
+
+		return nil
+	}
+
+	if !r.seedRunInputs() {
+		return nil
+	}
+
+	sharedPath, err := r.GetFlowBasePath("input")
+	if err != nil {
+		return err
+	}
+
+	err = copyDirectory(sharedPath, inputPath, false)
+	if err != nil {
+		return fmt.Errorf("unable to seed inputs: %w", err)
+	}
+
+	return nil
+}
+
+// Remove outputs of the previous run from shared directories along with
+// inputs written before the previous run was started - inputs written later
+// (i.e. for the queued run) are kept.
+func (r *RobocatRunner) clearSharedDirectories(inputPath string, outputPath string) error {
+	r.mu.Lock()
+	consumedBefore := r.sharedRunStarted
+	r.sharedRunStarted = time.Now()
+	r.mu.Unlock()
+
+	entries, err := os.ReadDir(outputPath)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(outputPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return filepath.Walk(inputPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.ModTime().After(consumedBefore) {
+			return err
+		}
+
+		return os.Remove(path)
+	})
+}
+
+// Remove directory of the isolated run according to clean-up policy.
+func (r *RobocatRunner) cleanupRunDirectory(run *RobocatRun) {
+	if !r.runIsolation() {
+		return
+	}
+
+	switch r.runCleanupPolicy() {
+	case CleanupKeep:
+		return
+	case CleanupOnSuccess:
+		if run.Reason() != ReasonSuccess {
+			return
+		}
+	}
+
+	r.removeRunDirectory(run.Ref)
+}
+
+// Remove directory of the isolated run (shared directories are never
+// removed).
+func (r *RobocatRunner) removeRunDirectory(ref string) {
+	if !r.runIsolation() {
+		return
+	}
+
+	runPath, err := r.runPath(ref)
+	if err == nil {
+		err = os.RemoveAll(runPath)
+	}
+
+	if err != nil {
+		log.Warnw("Unable to remove run directory", "error", err, "ref", ref)
+	}
+}
+
+// Copy files of the directory recursively (missing directory is treated as
+// empty one). Existing files are only replaced when overwrite is set.
+func copyDirectory(src string, dst string, overwrite bool) error {
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		target := filepath.Join(dst, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+
+		if !overwrite {
+			if _, err := os.Stat(target); err == nil {
+				return nil
+			}
+		}
+
+		return copyFile(path, target)
+	})
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
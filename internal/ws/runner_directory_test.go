@@ -0,0 +1,358 @@
+package ws_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	robocat "github.com/robocat-ai/robocat/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// Script printing names and contents of the inputs of the run and writing
+// output named after the flow.
+func isolatedScript(ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer) error {
+	// Giving output watcher time to start.
+	time.Sleep(200 * time.Millisecond)
+
+	entries, err := os.ReadDir(args.InputPath)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(args.InputPath, entry.Name()))
+		if err != nil {
+			return err
+		}
+
+		names = append(names, fmt.Sprintf("%s=%s", entry.Name(), content))
+	}
+
+	sort.Strings(names)
+	fmt.Fprintln(stdout, strings.Join(names, ","))
+
+	err = os.WriteFile(filepath.Join(args.OutputPath, args.Flow+".txt"), []byte(args.Flow), 0644)
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if args.Flow == "failing" {
+		return exitError(1)
+	}
+
+	return nil
+}
+
+func TestRunIsolation(t *testing.T) {
+	t.Setenv("RUN_ISOLATION", "true")
+	t.Setenv("RUN_CLEANUP", "keep")
+
+	s := startTestServer(t, isolatedScript)
+	ctx := context.Background()
+
+	client := newTestClient(t, s.address)
+
+	assert.NoError(t, client.Input("shared.txt", []byte("1")))
+	assert.NoError(t, client.InputTo(ctx, robocat.InputTarget{Session: true}, "session.txt", []byte("2")))
+
+	first := client.Flow("first").WithTimeout(5 * time.Second).Run()
+	lines := collectLines(first.Log())
+	assert.NoError(t, first.Wait())
+	assert.Equal(t, []string{"session.txt=2,shared.txt=1"}, <-lines)
+
+	// Session inputs are not visible to runs of other sessions.
+	another := newTestClient(t, s.address)
+
+	second := another.Flow("second").WithTimeout(5 * time.Second).Run()
+	lines = collectLines(second.Log())
+	assert.NoError(t, second.Wait())
+	assert.Equal(t, []string{"shared.txt=1"}, <-lines)
+
+	// Output of the first run does not leak into the second one.
+	entry, err := another.HistoryEntry(ctx, second.Ref())
+	if assert.NoError(t, err) {
+		assert.Equal(t, []robocat.HistoryFile{
+			{Path: "second.txt", MimeType: "text/plain; charset=utf-8", Size: 6},
+		}, entry.Files)
+	}
+
+	for _, ref := range []string{first.Ref(), second.Ref()} {
+		output, err := s.runner.GetFlowBasePath("runs", ref, "output")
+		assert.NoError(t, err)
+		assert.DirExists(t, output)
+	}
+
+	// Shared output directory is not used at all.
+	output, err := s.runner.GetFlowBasePath("output")
+	assert.NoError(t, err)
+	assert.NoDirExists(t, output)
+}
+
+func TestSharedDirectoriesCleared(t *testing.T) {
+	s := startTestServer(t, isolatedScript)
+	// Flows of the executor use shared directories like TagUI flows do.
+	s.executor.Shared = true
+
+	client := newTestClient(t, s.address)
+
+	for _, flow := range []string{"first", "second"} {
+		assert.NoError(t, client.Input(flow+"-input.txt", []byte(flow)))
+
+		run := client.Flow(flow).WithTimeout(5 * time.Second).Run()
+		lines := collectLines(run.Log())
+
+		assert.NoError(t, run.Wait())
+
+		// Inputs of the previous run are removed before the next one.
+		assert.Equal(t, []string{fmt.Sprintf("%s-input.txt=%s", flow, flow)}, <-lines)
+	}
+
+	output, err := s.runner.GetFlowBasePath("output")
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(output)
+	if assert.NoError(t, err) && assert.Len(t, entries, 1) {
+		assert.Equal(t, "second.txt", entries[0].Name())
+	}
+}
+
+func TestRunCleanupPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		// Run directory is kept after successful and failed run.
+		keepSucceeded bool
+		keepFailed    bool
+	}{
+		{policy: "keep", keepSucceeded: true, keepFailed: true},
+		{policy: "on-success", keepSucceeded: false, keepFailed: true},
+		{policy: "always", keepSucceeded: false, keepFailed: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			t.Setenv("RUN_ISOLATION", "true")
+			t.Setenv("RUN_CLEANUP", test.policy)
+
+			s := startTestServer(t, isolatedScript)
+			client := newTestClient(t, s.address)
+
+			succeeded := client.Flow("succeeding").WithTimeout(5 * time.Second).Run()
+			assert.NoError(t, succeeded.Wait())
+
+			failed := client.Flow("failing").WithTimeout(5 * time.Second).Run()
+			assert.Error(t, failed.Wait())
+
+			for ref, keep := range map[string]bool{
+				succeeded.Ref(): test.keepSucceeded,
+				failed.Ref():    test.keepFailed,
+			} {
+				path, err := s.runner.GetFlowBasePath("runs", ref)
+				assert.NoError(t, err)
+
+				if keep {
+					assert.DirExists(t, path)
+				} else {
+					assert.NoDirExists(t, path)
+				}
+			}
+		})
+	}
+}
+
+func TestRunInput(t *testing.T) {
+	t.Setenv("RUN_ISOLATION", "true")
+
+	s := startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintln(stdout, "waiting")
+
+		for {
+			content, err := os.ReadFile(filepath.Join(args.InputPath, "answer.txt"))
+			if err == nil {
+				fmt.Fprintln(stdout, string(content))
+				return nil
+			}
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+	})
+
+	client := newTestClient(t, s.address)
+	ctx := context.Background()
+
+	err := client.InputTo(ctx, robocat.InputTarget{Ref: "missing"}, "answer.txt", []byte("42"))
+	assert.ErrorIs(t, err, robocat.ErrNotRunning)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	lines := collectLines(flow.Log())
+
+	// Waiting for the run to start before sending the input.
+	time.Sleep(200 * time.Millisecond)
+
+	err = client.InputTo(ctx, robocat.InputTarget{Ref: flow.Ref()}, "answer.txt", []byte("42"))
+	assert.NoError(t, err)
+
+	assert.NoError(t, flow.Wait())
+	assert.Equal(t, []string{"waiting", "42"}, <-lines)
+}
+
+func TestRunInputOwnership(t *testing.T) {
+	t.Setenv("RUN_ISOLATION", "true")
+
+	s := startTestServer(t, blockingScript, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{
+			{Name: "alice", Key: "alice-key"},
+			{Name: "bob", Key: "bob-key"},
+		}
+	})
+
+	ctx := context.Background()
+
+	connect := func(token string) *robocat.Client {
+		client, err := robocat.ConnectWithToken(s.address, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+
+		return client
+	}
+
+	alice := connect("alice-key")
+	bob := connect("bob-key")
+
+	flow := alice.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.Equal(t, "started", <-flow.Log().Channel())
+
+	// Inputs can only be written for runs of the same client.
+	err := bob.InputTo(ctx, robocat.InputTarget{Ref: flow.Ref()}, "answer.txt", []byte("42"))
+	assert.ErrorIs(t, err, robocat.ErrUnauthorized)
+
+	err = alice.InputTo(ctx, robocat.InputTarget{Ref: flow.Ref()}, "answer.txt", []byte("42"))
+	assert.NoError(t, err)
+
+	// Inputs are never written outside of input directory.
+	err = alice.InputTo(ctx, robocat.InputTarget{Ref: flow.Ref()}, "../../../escaped.txt", []byte("42"))
+	assert.ErrorIs(t, err, robocat.ErrInvalidArguments)
+
+	escaped, err := s.runner.GetFlowBasePath("escaped.txt")
+	assert.NoError(t, err)
+	assert.NoFileExists(t, escaped)
+
+	assert.NoError(t, flow.Cancel(ctx))
+}
+
+func TestSessionInputRemoved(t *testing.T) {
+	t.Setenv("RUN_ISOLATION", "true")
+
+	s := startTestServer(t, noopScript)
+
+	client, err := robocat.Connect(s.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = client.InputTo(context.Background(), robocat.InputTarget{Session: true}, "session.txt", []byte("1"))
+	assert.NoError(t, err)
+
+	sessions, err := s.runner.GetFlowBasePath("sessions")
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(sessions)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	client.Close()
+
+	assert.Eventually(t, func() bool {
+		entries, err := os.ReadDir(sessions)
+		return err == nil && len(entries) == 0
+	}, 2*time.Second, 50*time.Millisecond)
+}
+
+func TestRunIsolationUnsupported(t *testing.T) {
+	t.Setenv("RUN_ISOLATION", "true")
+
+	s := startTestServer(t, isolatedScript)
+	s.executor.Shared = true
+
+	client := newTestClient(t, s.address)
+
+	flow := client.Flow("shared").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Wait())
+
+	// Flow gets shared directories, so its outputs are still delivered.
+	output, err := s.runner.GetFlowBasePath("output", "shared.txt")
+	assert.NoError(t, err)
+	assert.FileExists(t, output)
+
+	entry, err := client.HistoryEntry(context.Background(), flow.Ref())
+	if assert.NoError(t, err) {
+		assert.Len(t, entry.Files, 1)
+	}
+}
+
+func TestQueuedRunSessionInputs(t *testing.T) {
+	t.Setenv("RUN_ISOLATION", "true")
+	t.Setenv("RUN_CLEANUP", "keep")
+	t.Setenv("QUEUE_MAX_LENGTH", "1")
+
+	s := startTestServer(t, blockingScript)
+	ctx := context.Background()
+
+	client := newTestClient(t, s.address)
+
+	running := client.Flow("running").WithTimeout(10 * time.Second).Run()
+	assert.Equal(t, "started", <-running.Log().Channel())
+
+	another, err := robocat.Connect(s.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = another.InputTo(ctx, robocat.InputTarget{Session: true}, "session.txt", []byte("1"))
+	assert.NoError(t, err)
+
+	queued := another.Flow("queued").WithTimeout(10 * time.Second).Run()
+	assert.Eventually(t, func() bool {
+		return queued.QueuePosition() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Session inputs are removed along with the session while the run is
+	// still waiting for its turn.
+	another.Close()
+
+	sessions, err := s.runner.GetFlowBasePath("sessions")
+	assert.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		entries, err := os.ReadDir(sessions)
+		return err == nil && len(entries) == 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	input, err := s.runner.GetFlowBasePath("runs", queued.Ref(), "input", "session.txt")
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(input)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(content))
+
+	assert.NoError(t, running.Cancel(ctx))
+	assert.Error(t, running.Wait())
+}
@@ -0,0 +1,136 @@
+package ws
+
+import (
+	"context"
+	"os"
+)
+
+// Store of finished runs - HISTORY_FILE or "history.jsonl" in the flow
+// directory by default.
+func (r *RobocatRunner) history() (*historyStore, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.historyStore != nil {
+		return r.historyStore, nil
+	}
+
+	path := os.Getenv("HISTORY_FILE")
+	if len(path) == 0 {
+		var err error
+		path, err = r.GetFlowBasePath("history.jsonl")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r.historyStore = newHistoryStore(path)
+
+	return r.historyStore, nil
+}
+
+// Record the finished run in history. Failing to do so does not affect the
+// run itself.
+func (r *RobocatRunner) recordHistory(run *RobocatRun, result *RunResult) {
+	history, err := r.history()
+	if err == nil {
+		err = history.add(newHistoryEntry(run, result))
+	}
+
+	if err != nil {
+		log.Warnw("Unable to record run in history", "error", err, "ref", run.Ref)
+	}
+}
+
+func newHistoryEntry(run *RobocatRun, result *RunResult) *HistoryEntry {
+	entry := &HistoryEntry{
+		Ref:        run.Ref,
+		Flow:       run.Args.Flow,
+		Args:       redactArguments(run.Args),
+		Principal:  run.Principal.owner(),
+		Status:     resultStatus(result),
+		StartedAt:  result.StartedAt,
+		FinishedAt: result.FinishedAt,
+		Result:     result,
+		Files:      run.Outputs(),
+	}
+
+	if err := run.Err(); err != nil {
+		entry.Error = NewErrorBody(err)
+	}
+
+	if entry.Files == nil {
+		entry.Files = make([]RunFile, 0)
+	}
+
+	return entry
+}
+
+// Reply with history entries matching the filter in the message body.
+func (r *RobocatRunner) History(
+	ctx context.Context,
+	message *Message,
+) {
+	filter := &HistoryFilter{}
+
+	if len(message.Body) > 0 {
+		err := message.Decode(&filter)
+		if err != nil || filter == nil {
+			message.ReplyWithErrorCode(ErrorInvalidArguments, "unable to deserialize body: %v", err)
+			return
+		}
+	}
+
+	if err := filter.Validate(); err != nil {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, err.Error())
+		return
+	}
+
+	history, err := r.history()
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	entries, err := history.find(filter, message.Session().Principal())
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	message.Reply("history", entries)
+}
+
+// Reply with history entry of the run referenced in the message body.
+func (r *RobocatRunner) HistoryGet(
+	ctx context.Context,
+	message *Message,
+) {
+	var args *HistoryGetArguments
+
+	err := message.Decode(&args)
+	if err != nil || args == nil || len(args.Ref) == 0 {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "expected ref of the run: %v", err)
+		return
+	}
+
+	history, err := r.history()
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	entry, err := history.get(args.Ref)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	// Runs of other clients are not revealed.
+	if entry == nil || !message.Session().Principal().owns(entry.Principal) {
+		message.ReplyWithErrorCode(ErrorNotFound, "run %s not found in history", args.Ref)
+		return
+	}
+
+	message.Reply("history", entry)
+}
@@ -2,69 +2,111 @@ package ws
 
 import (
 	"bufio"
-	"context"
 	"io"
 	"os"
-	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Watchdog failing the run when automation does not produce any output
+// after it was started. It is shared between stdout and stderr watchers
+// since both streams indicate that automation is alive.
+type startWatchdog struct {
+	mu      sync.Mutex
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func newStartWatchdog() *startWatchdog {
+	timeout, err := time.ParseDuration(os.Getenv("AUTOMATION_START_TIMEOUT"))
+	if err != nil {
+		timeout = time.Minute
+	}
+
+	return &startWatchdog{timeout: timeout}
+}
+
+func (w *startWatchdog) arm(run *RobocatRun) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+
+	w.timer = time.AfterFunc(w.timeout, func() {
+		run.fail(ReasonWatchdog, NewError(
+			ErrorStartTimeout,
+			"automation start timeout reached (%s)",
+			w.timeout,
+		).WithDetails(map[string]interface{}{
+			"timeout": w.timeout.String(),
+		}))
+	})
+}
+
+func (w *startWatchdog) disarm() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// Read log lines from the stream and send them to the client as updates
+// with the specified name. Lines are checked for errors and automation
+// start markers the same way regardless of the stream they come from.
 func (r *RobocatRunner) watchLogs(
-	ctx context.Context,
-	message *Message,
+	run *RobocatRun,
 	stream io.Reader,
+	update string,
+	watchdog *startWatchdog,
 ) {
-	log.Debugw("Watching logs", "ref", message.Ref)
-
-	scanner := bufio.NewScanner(stream)
+	log.Debugw("Watching logs", "stream", update, "ref", run.Ref)
 
-	startPrefix := "START - automation started"
-	errorPrefix := "ERROR - "
+	// Process must not be blocked on writing output nobody reads.
+	defer io.Copy(io.Discard, stream)
 
-	var automationWatchdogTimer *time.Timer
-	automationWatchdogTimeout, err := time.ParseDuration(os.Getenv("AUTOMATION_START_TIMEOUT"))
-	if err != nil {
-		automationWatchdogTimeout = time.Minute
-	}
+	scanner := bufio.NewScanner(stream)
 
 loop:
 	for scanner.Scan() {
 		select {
-		case <-ctx.Done():
+		case <-run.ctx.Done():
 			// Stop logging when parent context is done.
 			break loop
 		default:
 			line := scanner.Text()
-			message.Reply("log", scanner.Text())
-
-			if strings.HasPrefix(line, startPrefix) {
-				automationWatchdogTimer = time.AfterFunc(
-					automationWatchdogTimeout, func() {
-						r.cancel()
-						message.ReplyWithErrorf(
-							"automation start timeout reached (%s)",
-							automationWatchdogTimeout,
-						)
-					},
-				)
-			} else if strings.HasPrefix(line, errorPrefix) {
-				r.cancel()
-				message.ReplyWithErrorf(
+			run.reply(update, line)
+			run.countLog()
+			run.span.AddEvent(update, trace.WithAttributes(attribute.String("robocat.line", line)))
+
+			event := r.executor.ParseLog(line)
+
+			switch event.Kind {
+			case LogStart:
+				watchdog.arm(run)
+			case LogError:
+				code := event.Code
+				if len(code) == 0 {
+					code = ErrorFlowErrorLine
+				}
+
+				run.fail(ReasonError, NewError(
+					code,
 					"got error during run execution: %s",
-					strings.TrimPrefix(line, errorPrefix),
-				)
+					event.Text,
+				))
 				break loop
-			} else {
-				if automationWatchdogTimer != nil {
-					automationWatchdogTimer.Stop()
-				}
+			default:
+				watchdog.disarm()
 			}
 		}
 	}
 
-	if automationWatchdogTimer != nil {
-		automationWatchdogTimer.Stop()
-	}
-
-	log.Debugw("Stopped watching logs", "ref", message.Ref)
+	log.Debugw("Stopped watching logs", "stream", update, "ref", run.Ref)
 }
@@ -2,117 +2,170 @@ package ws
 
 import (
 	"bytes"
-	"context"
 	"fmt"
 	"mime"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 
-	"github.com/radovskyb/watcher"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (r *RobocatRunner) watchOutputPath(
-	ctx context.Context,
-	message *Message,
-	path string,
-) error {
-	outputBasePath, err := r.GetFlowBasePath(path)
+// Delay before watching output directory again after the watcher failed or
+// the directory was removed.
+const outputRetryDelay = 300 * time.Millisecond
+
+func (r *RobocatRunner) watchOutputPath(run *RobocatRun) error {
+	outputBasePath := run.Args.OutputPath
+
+	err := os.MkdirAll(outputBasePath, 0755)
 	if err != nil {
-		log.Fatalw(err.Error(), "ref", message.Ref)
+		log.Warnw(err.Error(), "ref", run.Ref)
+		return err
 	}
 
-	err = os.MkdirAll(outputBasePath, 0755)
+	w, err := newOutputWatcher(outputBasePath)
 	if err != nil {
-		log.Warnw(err.Error(), "ref", message.Ref)
 		return err
 	}
+	defer w.Close()
 
-	w := watcher.New()
+	log.Debugw(fmt.Sprintf("Watching directory recusively: %s", outputBasePath), "ref", run.Ref)
 
-	w.FilterOps(watcher.Create, watcher.Write)
+	debouncer := newOutputDebouncer(r.outputDebounce())
 
-	if err := w.AddRecursive(outputBasePath); err != nil {
-		log.Fatal(err)
-	}
+	// Timer firing when the next debounced file is due.
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
 
-	go func() {
-		if err := w.Start(time.Millisecond * 300); err != nil {
-			log.Fatalw(err.Error(), "ref", message.Ref)
-		}
-	}()
-	defer w.Close()
+	send := func(paths []string) {
+		sort.Strings(paths)
 
-	log.Debugw(fmt.Sprintf("Watching directory recusively: %s", outputBasePath), "ref", message.Ref)
+		for _, p := range paths {
+			r.sendOutputFile(run, outputBasePath, p)
+		}
+	}
 
 	for {
 		select {
-		case event := <-w.Event:
-			log.Debugw("Got output path update", "path", event.Path, "ref", message.Ref)
-
-			if event.IsDir() {
-				continue
+		case p := <-w.Files():
+			log.Debugw("Got output path update", "path", p, "ref", run.Ref)
+
+			now := time.Now()
+			debouncer.touch(p, now)
+			send(debouncer.due(now))
+
+			if wait, ok := debouncer.next(now); ok {
+				timer.Stop()
+				select {
+				case <-timer.C:
+				default:
+				}
+				timer.Reset(wait)
 			}
+		case <-timer.C:
+			now := time.Now()
+			send(debouncer.due(now))
 
-			path, err := filepath.Rel(outputBasePath, event.Path)
-			if err != nil {
-				log.Warnw("Unable to form relative path", "error", err, "ref", message.Ref)
-				continue
+			if wait, ok := debouncer.next(now); ok {
+				timer.Reset(wait)
 			}
+		case err := <-w.Errors():
+			send(debouncer.flush())
 
-			ext := filepath.Ext(path)
-			if len(ext) == 0 {
-				ext = ".txt"
+			if err == errOutputRemoved {
+				log.Debugw(fmt.Sprintf("Output directory was removed: %s", outputBasePath), "ref", run.Ref)
+				return nil
 			}
 
-			mimeType := mime.TypeByExtension(ext)
+			return err
+		case <-run.ctx.Done():
+			// Files written right before the run was finished are sent
+			// without waiting for debounce window to pass.
+			send(debouncer.flush())
+			return nil
+		}
+	}
+}
 
-			payload, err := os.ReadFile(event.Path)
-			if err != nil {
-				log.Warnw("Unable to read file", "error", err, "file", event.Path, "ref", message.Ref)
-				continue
-			}
+// Read the file and send it to the client as an output update. Files larger
+// than transfer chunk size are streamed from disk in chunks (and sent as is,
+// even if they are text files).
+func (r *RobocatRunner) sendOutputFile(run *RobocatRun, basePath string, filePath string) {
+	info, err := os.Stat(filePath)
+	if err != nil || info.IsDir() {
+		return
+	}
 
-			if ext == ".txt" {
-				payload = bytes.TrimSpace(payload)
-			}
+	path, err := filepath.Rel(basePath, filePath)
+	if err != nil {
+		log.Warnw("Unable to form relative path", "error", err, "ref", run.Ref)
+		return
+	}
 
-			message.Reply("output", RobocatFile{
-				Path:     path,
-				MimeType: mimeType,
-				Payload:  payload,
-			})
-		case err := <-w.Error:
-			if err == watcher.ErrWatchedFileDeleted {
-				log.Debugw(fmt.Sprintf("Output directory was removed: %s", outputBasePath), "ref", message.Ref)
-				return nil
-			} else {
-				return err
-			}
-		case <-ctx.Done():
-			return nil
-		case <-w.Closed:
-			return nil
+	ext := filepath.Ext(path)
+	if len(ext) == 0 {
+		ext = ".txt"
+	}
+
+	mimeType := mime.TypeByExtension(ext)
+
+	var size int64
+
+	if info.Size() > int64(transferChunkSize()) {
+		size, err = r.sendOutputChunks(run, path, mimeType, filePath)
+		if err != nil {
+			log.Warnw("Unable to send file in chunks", "error", err, "file", filePath, "ref", run.Ref)
+			return
+		}
+	} else {
+		payload, err := os.ReadFile(filePath)
+		if err != nil {
+			log.Warnw("Unable to read file", "error", err, "file", filePath, "ref", run.Ref)
+			return
+		}
+
+		if ext == ".txt" {
+			payload = bytes.TrimSpace(payload)
 		}
+
+		size = int64(len(payload))
+
+		r.retainArtifact(run, path, mimeType, size, Checksum(payload), bytes.NewReader(payload))
+
+		run.reply("output", RobocatFile{
+			Path:     path,
+			MimeType: mimeType,
+			Payload:  payload,
+		})
 	}
+
+	run.countFile()
+	run.addOutput(RunFile{Path: path, MimeType: mimeType, Size: int(size)})
+	r.metrics.sendOutput(int(size))
+
+	run.span.AddEvent("output", trace.WithAttributes(
+		attribute.String("robocat.path", path),
+		attribute.String("robocat.type", mimeType),
+		attribute.Int64("robocat.size", size),
+	))
 }
 
-func (r *RobocatRunner) watchOutput(
-	ctx context.Context,
-	message *Message,
-) {
-loop:
+func (r *RobocatRunner) watchOutput(run *RobocatRun) {
 	for {
+		err := r.watchOutputPath(run)
+		if err != nil {
+			log.Warnw(fmt.Sprintf("Got output watcher error: %v", err), "ref", run.Ref)
+		}
+
 		select {
-		case <-ctx.Done():
-			break loop
-		default:
-			err := r.watchOutputPath(ctx, message, "output")
-			if err != nil {
-				log.Warnw(fmt.Sprintf("Got output watcher error: %v", err), "ref", message.Ref)
-			}
+		case <-run.ctx.Done():
+			log.Debugw("Stopped watching output", "ref", run.Ref)
+			return
+		case <-time.After(outputRetryDelay):
 		}
 	}
-
-	log.Debugw("Stopped watching output", "ref", message.Ref)
 }
@@ -0,0 +1,279 @@
+package ws
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strconv"
+)
+
+// Body of "queued" update sent while the run waits for its turn.
+type QueuedStatus struct {
+	// Position of the run in the queue starting from 1.
+	Position int `json:"position"`
+}
+
+// Queued run listed by "queue.list" command.
+type QueuedRun struct {
+	Ref      string `json:"ref"`
+	Flow     string `json:"flow"`
+	Priority int    `json:"priority"`
+	Position int    `json:"position"`
+	// Client that has started the run (empty if unknown).
+	Principal string `json:"principal,omitempty"`
+}
+
+// Body of "queue.cancel" command.
+type QueueCancelArguments struct {
+	Ref string `json:"ref"`
+}
+
+// Number of runs executed at the same time. Flows sharing input and output
+// directories must not run concurrently, so values greater than 1 are only
+// useful with RUN_ISOLATION. Executors unable to run several flows at once
+// (i.e. TagUI) always run one flow at a time.
+func (r *RobocatRunner) concurrency() int {
+	concurrency, err := strconv.Atoi(os.Getenv("RUN_CONCURRENCY"))
+	if err != nil || concurrency < 1 || !r.executor.Concurrent() {
+		concurrency = 1
+	}
+
+	return concurrency
+}
+
+// Max number of runs waiting for their turn (100 by default). Runs are
+// rejected as soon as all RUN_CONCURRENCY slots are taken when the queue is
+// disabled (0).
+func (r *RobocatRunner) queueLength() int {
+	length, err := strconv.Atoi(os.Getenv("QUEUE_MAX_LENGTH"))
+	if err != nil || length < 0 {
+		length = 100
+	}
+
+	return length
+}
+
+// Error the run is rejected with when it cannot be started or queued.
+func (r *RobocatRunner) busyError() *Error {
+	if r.queueLength() > 0 {
+		return NewError(ErrorBusy, "run queue is full")
+	}
+
+	return NewError(ErrorBusy, "another flow is already running")
+}
+
+// Number of runs that have got their turn and are not finished yet.
+func (r *RobocatRunner) runsExecuting() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.executing
+}
+
+// Put the run into the queue - runs with higher priority go first, runs
+// with the same priority are started in order they were queued. Must be
+// called with the runner mutex held.
+func (r *RobocatRunner) enqueue(run *RobocatRun) {
+	r.queue = append(r.queue, run)
+
+	sort.SliceStable(r.queue, func(i, j int) bool {
+		return r.queue[i].priority > r.queue[j].priority
+	})
+
+	log.Debugw("Run queued", "priority", run.priority, "queue", len(r.queue), "ref", run.Ref)
+
+	r.notifyQueued()
+}
+
+// Give the run its turn. Must be called with the runner mutex held.
+func (r *RobocatRunner) dispatch(run *RobocatRun) {
+	run.executing = true
+	r.executing++
+
+	close(run.turn)
+}
+
+// Give turn to queued runs while there are free slots and let the rest of
+// the queue know their new positions. Must be called with the runner mutex
+// held.
+func (r *RobocatRunner) dispatchQueued() {
+	dispatched := false
+
+	for len(r.queue) > 0 && r.executing < r.concurrency() {
+		run := r.queue[0]
+		r.queue = r.queue[1:]
+
+		run.position = 0
+
+		log.Debugw("Run dequeued", "queue", len(r.queue), "ref", run.Ref)
+
+		r.dispatch(run)
+		dispatched = true
+	}
+
+	if dispatched {
+		r.notifyQueued()
+	}
+}
+
+// Update positions of queued runs and let their waiters know. Must be called
+// with the runner mutex held - positions are sent by the waiters without the
+// mutex, so that slow clients do not block the runner.
+func (r *RobocatRunner) notifyQueued() {
+	r.metrics.queueLength.Set(float64(len(r.queue)))
+
+	for i, run := range r.queue {
+		if run.position == i+1 {
+			continue
+		}
+
+		run.position = i + 1
+
+		select {
+		case run.moved <- struct{}{}:
+		default:
+			// Waiter has not picked up the previous change yet.
+		}
+	}
+}
+
+// Remove the run from the queue (if it is still there). Must be called with
+// the runner mutex held.
+func (r *RobocatRunner) dequeue(run *RobocatRun) bool {
+	for i, queued := range r.queue {
+		if queued == run {
+			r.queue = append(r.queue[:i], r.queue[i+1:]...)
+			run.position = 0
+			r.notifyQueued()
+			return true
+		}
+	}
+
+	return false
+}
+
+// Position of the run in the queue (0 once the run has left the queue).
+func (r *RobocatRunner) queuePosition(run *RobocatRun) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return run.position
+}
+
+// Wait until the run gets its turn sending its position in the queue to the
+// client whenever it changes. Positions are sent by the same goroutine that
+// executes the run afterwards, so they never overtake updates of the run.
+// False is returned if the run was stopped while waiting in the queue - such
+// a run must be finished without being executed.
+func (r *RobocatRunner) waitTurn(run *RobocatRun) bool {
+loop:
+	for {
+		select {
+		case <-run.turn:
+			return true
+		case <-run.moved:
+			if position := r.queuePosition(run); position > 0 {
+				run.reply("queued", QueuedStatus{Position: position})
+			}
+		case <-run.ctx.Done():
+			break loop
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Run might have got its turn right before it was stopped, in which case
+	// the slot is freed once the run is finished.
+	if r.dequeue(run) {
+		log.Debugw("Queued run stopped", "reason", run.Reason(), "ref", run.Ref)
+	}
+
+	return false
+}
+
+// Free the slot of the finished run and give it to the next queued run.
+func (r *RobocatRunner) leave(run *RobocatRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if run.executing {
+		run.executing = false
+		r.executing--
+	}
+
+	r.dispatchQueued()
+}
+
+// Reply with runs waiting in the queue in order they are going to be
+// started.
+func (r *RobocatRunner) QueueList(
+	ctx context.Context,
+	message *Message,
+) {
+	r.mu.Lock()
+
+	queue := make([]QueuedRun, 0, len(r.queue))
+	for i, run := range r.queue {
+		queued := QueuedRun{
+			Ref:      run.Ref,
+			Flow:     run.Args.Flow,
+			Priority: run.priority,
+			Position: i + 1,
+		}
+
+		if run.Principal != nil {
+			queued.Principal = run.Principal.String()
+		}
+
+		queue = append(queue, queued)
+	}
+
+	r.mu.Unlock()
+
+	message.Reply("queue", queue)
+}
+
+// Remove the run referenced in the message body from the queue. Runs that
+// have already started must be stopped with "stop" command instead.
+func (r *RobocatRunner) QueueCancel(
+	ctx context.Context,
+	message *Message,
+) {
+	var args *QueueCancelArguments
+
+	err := message.Decode(&args)
+	if err != nil || args == nil || len(args.Ref) == 0 {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "expected ref of the run: %v", err)
+		return
+	}
+
+	r.mu.Lock()
+
+	var canceled *RobocatRun
+	for _, run := range r.queue {
+		if run.Ref == args.Ref {
+			canceled = run
+			break
+		}
+	}
+
+	r.mu.Unlock()
+
+	if canceled == nil {
+		message.ReplyWithErrorCode(ErrorNotFound, "run %s is not queued", args.Ref)
+		return
+	}
+
+	if !message.Session().Principal().controls(canceled) {
+		message.ReplyWithErrorCode(ErrorUnauthorized, "run %s was started by another client", args.Ref)
+		return
+	}
+
+	// Run is removed from the queue and finished by its waiter.
+	canceled.end(ReasonCanceled)
+
+	log.Debugw("Canceled queued run", "ref", args.Ref)
+
+	message.Reply("status", "ok")
+}
@@ -0,0 +1,253 @@
+package ws_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	robocat "github.com/robocat-ai/robocat/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+// Blocking script recording flows in order they were started.
+type startedFlows struct {
+	mu    sync.Mutex
+	flows []string
+}
+
+func (s *startedFlows) script(
+	ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+) error {
+	s.mu.Lock()
+	s.flows = append(s.flows, args.Flow)
+	s.mu.Unlock()
+
+	return blockingScript(ctx, args, stdout, stderr)
+}
+
+func (s *startedFlows) get() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.flows...)
+}
+
+func TestQueueByDefault(t *testing.T) {
+	s := startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		time.Sleep(200 * time.Millisecond)
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+
+	// Flows started back to back by the same client do not replace each
+	// other.
+	first := client.Flow("first").WithTimeout(10 * time.Second).Run()
+	second := client.Flow("second").WithTimeout(10 * time.Second).Run()
+
+	assert.NoError(t, first.Wait())
+	assert.NoError(t, second.Wait())
+
+	for _, flow := range []*robocat.RobocatFlow{first, second} {
+		if assert.NotNil(t, flow.Result()) {
+			assert.Equal(t, "success", flow.Result().Reason)
+		}
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "3")
+
+	started := &startedFlows{}
+	s := startTestServer(t, started.script)
+
+	client := newTestClient(t, s.address)
+	ctx := context.Background()
+
+	first := client.Flow("first").WithTimeout(10 * time.Second).Run()
+	assert.Equal(t, "started", <-first.Log().Channel())
+
+	flows := []*robocat.RobocatFlow{first}
+	for _, flow := range []struct {
+		name     string
+		priority int
+	}{
+		{name: "low", priority: 0},
+		{name: "second", priority: 0},
+		{name: "high", priority: 5},
+	} {
+		flows = append(flows, client.Flow(flow.name).
+			WithPriority(flow.priority).
+			WithTimeout(10*time.Second).
+			Run())
+	}
+
+	assert.Eventually(t, func() bool {
+		return flows[3].QueuePosition() == 1 && flows[2].QueuePosition() == 3
+	}, 2*time.Second, 10*time.Millisecond)
+
+	queue, err := client.Queue(ctx)
+	if assert.NoError(t, err) && assert.Len(t, queue, 3) {
+		for i, flow := range []string{"high", "low", "second"} {
+			assert.Equal(t, flow, queue[i].Flow)
+			assert.Equal(t, i+1, queue[i].Position)
+		}
+
+		assert.Equal(t, 5, queue[0].Priority)
+		assert.Equal(t, flows[3].Ref(), queue[0].Ref)
+	}
+
+	// Queue is full.
+	err = client.Flow("rejected").WithTimeout(10 * time.Second).Run().Wait()
+	assert.ErrorIs(t, err, robocat.ErrBusy)
+
+	// Queued flows are started one by one as soon as the previous one is
+	// finished.
+	ordered := []*robocat.RobocatFlow{flows[0], flows[3], flows[1], flows[2]}
+	for i, flow := range ordered {
+		assert.Eventually(t, func() bool {
+			return len(started.get()) == i+1
+		}, 2*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, 0, flow.QueuePosition())
+
+		assert.NoError(t, flow.Cancel(ctx))
+		assert.Error(t, flow.Wait())
+	}
+
+	assert.Equal(t, []string{"first", "high", "low", "second"}, started.get())
+}
+
+func TestQueueCancel(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "1")
+
+	s := startTestServer(t, blockingScript)
+
+	client := newTestClient(t, s.address)
+	ctx := context.Background()
+
+	running := client.Flow("running").WithTimeout(10 * time.Second).Run()
+	assert.Equal(t, "started", <-running.Log().Channel())
+
+	queued := client.Flow("queued").WithTimeout(10 * time.Second).Run()
+	assert.Eventually(t, func() bool {
+		return queued.QueuePosition() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	err := client.CancelQueued(ctx, running.Ref())
+	assert.ErrorIs(t, err, robocat.ErrNotFound)
+
+	assert.NoError(t, client.CancelQueued(ctx, queued.Ref()))
+
+	err = queued.Wait()
+	assert.ErrorContains(t, err, "flow was aborted: canceled")
+	assert.Equal(t, "canceled", queued.Result().Reason)
+
+	queue, err := client.Queue(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, queue)
+
+	// Canceled run has never been executed.
+	assert.Equal(t, 1, s.executor.Running())
+
+	assert.NoError(t, running.Cancel(ctx))
+	assert.Error(t, running.Wait())
+}
+
+func TestQueueConcurrency(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "1")
+	t.Setenv("RUN_CONCURRENCY", "2")
+
+	s := startTestServer(t, blockingScript)
+
+	client := newTestClient(t, s.address)
+	ctx := context.Background()
+
+	first := client.Flow("first").WithTimeout(10 * time.Second).Run()
+	second := client.Flow("second").WithTimeout(10 * time.Second).Run()
+
+	for _, flow := range []*robocat.RobocatFlow{first, second} {
+		assert.Equal(t, "started", <-flow.Log().Channel())
+	}
+
+	assert.Equal(t, 2, s.executor.Running())
+
+	third := client.Flow("third").WithTimeout(10 * time.Second).Run()
+	assert.Eventually(t, func() bool {
+		return third.QueuePosition() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, first.Cancel(ctx))
+	assert.Equal(t, "started", <-third.Log().Channel())
+	assert.Equal(t, 0, third.QueuePosition())
+
+	for _, flow := range []*robocat.RobocatFlow{second, third} {
+		assert.NoError(t, flow.Cancel(ctx))
+		assert.Error(t, flow.Wait())
+	}
+
+	assert.Error(t, first.Wait())
+}
+
+func TestQueueSequentialExecutor(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "1")
+	t.Setenv("RUN_CONCURRENCY", "2")
+
+	s := startTestServer(t, blockingScript)
+	s.executor.Sequential = true
+
+	client := newTestClient(t, s.address)
+	ctx := context.Background()
+
+	first := client.Flow("first").WithTimeout(10 * time.Second).Run()
+	assert.Equal(t, "started", <-first.Log().Channel())
+
+	// Executor cannot run flows concurrently despite RUN_CONCURRENCY.
+	second := client.Flow("second").WithTimeout(10 * time.Second).Run()
+	assert.Eventually(t, func() bool {
+		return second.QueuePosition() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, 1, s.executor.Running())
+
+	for _, flow := range []*robocat.RobocatFlow{first, second} {
+		assert.NoError(t, flow.Cancel(ctx))
+		assert.Error(t, flow.Wait())
+	}
+}
+
+func TestQueueDisconnect(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "1")
+
+	s := startTestServer(t, blockingScript)
+
+	client := newTestClient(t, s.address)
+	running := client.Flow("running").WithTimeout(10 * time.Second).Run()
+	assert.Equal(t, "started", <-running.Log().Channel())
+
+	another, err := robocat.Connect(s.address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queued := another.Flow("queued").WithTimeout(10 * time.Second).Run()
+	assert.Eventually(t, func() bool {
+		return queued.QueuePosition() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Runs of the closed session leave the queue.
+	another.Close()
+
+	assert.Eventually(t, func() bool {
+		queue, err := client.Queue(context.Background())
+		return err == nil && len(queue) == 0
+	}, 2*time.Second, 10*time.Millisecond)
+
+	assert.NoError(t, running.Cancel(context.Background()))
+	assert.Error(t, running.Wait())
+}
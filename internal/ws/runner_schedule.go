@@ -0,0 +1,513 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/robfig/cron/v3"
+)
+
+// Time to wait for the webhook to accept the result of a scheduled run.
+const webhookTimeout = 10 * time.Second
+
+// Client posting results to webhooks. Redirects are not followed, since
+// they could lead to a host that is not allowed.
+var webhookClient = &http.Client{
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Starts flows on their cron schedules. Runs are started the same way as
+// over REST API, so they are recorded in history and can be inspected with
+// REST API while they are retained.
+type scheduler struct {
+	runner *RobocatRunner
+	store  *scheduleStore
+	cron   *cron.Cron
+
+	mu sync.Mutex
+	// Jobs of the schedules keyed by schedule ID.
+	jobs map[string]*scheduledJob
+}
+
+// Job started by cron every time its schedule fires.
+type scheduledJob struct {
+	runner   *RobocatRunner
+	schedule *Schedule
+	spec     cron.Schedule
+	entry    cron.EntryID
+
+	// Held while the run of the schedule is in progress (or waits for its
+	// turn), so that runs of the same schedule never overlap.
+	running sync.Mutex
+
+	mu      sync.Mutex
+	last    *RobocatRun
+	removed bool
+	// Firing waits for the previous run to finish.
+	pending bool
+}
+
+// Logger of cron library writing to module log.
+type cronLogger struct{}
+
+func (cronLogger) Info(msg string, keysAndValues ...interface{}) {
+	log.Debugw(msg, keysAndValues...)
+}
+
+func (cronLogger) Error(err error, msg string, keysAndValues ...interface{}) {
+	log.Errorw(msg, append(keysAndValues, "error", err)...)
+}
+
+// Scheduler of the runner with schedules loaded from SCHEDULE_FILE or
+// "schedules.json" in the flow directory by default (created on first use).
+func (r *RobocatRunner) schedules() (*scheduler, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.scheduler != nil {
+		return r.scheduler, nil
+	}
+
+	path := os.Getenv("SCHEDULE_FILE")
+	if len(path) == 0 {
+		var err error
+		path, err = r.GetFlowBasePath("schedules.json")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s := &scheduler{
+		runner: r,
+		store:  newScheduleStore(path),
+		cron:   cron.New(cron.WithChain(cron.Recover(cronLogger{}))),
+		jobs:   make(map[string]*scheduledJob),
+	}
+
+	schedules, err := s.store.load()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, schedule := range schedules {
+		if _, ok := s.jobs[schedule.ID]; ok {
+			return nil, fmt.Errorf("%s: duplicate schedule id: '%s'", path, schedule.ID)
+		}
+
+		err := schedule.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s: schedule %s: %w", path, schedule.ID, err)
+		}
+
+		s.schedule(schedule)
+	}
+
+	r.scheduler = s
+
+	return s, nil
+}
+
+// Load schedules and start running flows on their schedules.
+func (r *RobocatRunner) StartScheduler() error {
+	s, err := r.schedules()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	log.Infof("Loaded %d schedules from %s", len(s.jobs), s.store.path)
+	s.mu.Unlock()
+
+	s.cron.Start()
+
+	return nil
+}
+
+// Stop starting new scheduled runs - runs in progress are not affected.
+func (r *RobocatRunner) StopScheduler() {
+	r.mu.Lock()
+	s := r.scheduler
+	r.mu.Unlock()
+
+	if s != nil {
+		s.cron.Stop()
+	}
+}
+
+// Add job of the validated schedule to cron. Must be called with scheduler
+// mutex held (or before the scheduler is shared).
+func (s *scheduler) schedule(schedule *Schedule) *scheduledJob {
+	// Expression is known to be valid at this point.
+	spec, _ := schedule.parse()
+
+	job := &scheduledJob{
+		runner:   s.runner,
+		schedule: schedule,
+		spec:     spec,
+	}
+
+	job.entry = s.cron.Schedule(spec, job)
+	s.jobs[schedule.ID] = job
+
+	return job
+}
+
+func (s *scheduler) list() []*Schedule {
+	schedules := make([]*Schedule, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		schedules = append(schedules, job.schedule)
+	}
+
+	return schedules
+}
+
+// Store the validated schedule and start running it.
+func (s *scheduler) add(schedule *Schedule) (*scheduledJob, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.jobs[schedule.ID]; ok {
+		return nil, NewError(ErrorInvalidArguments, "schedule %s already exists", schedule.ID)
+	}
+
+	err := s.store.save(append(s.list(), schedule))
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugw("Schedule added", "schedule", schedule.ID, "cron", schedule.Cron, "flow", schedule.Flow)
+
+	return s.schedule(schedule), nil
+}
+
+// Remove the schedule of the principal - its run in progress (if any) is
+// not stopped.
+func (s *scheduler) remove(id string, principal *Principal) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Schedules of other clients are not revealed.
+	job, ok := s.jobs[id]
+	if !ok || !principal.owns(job.schedule.principal().owner()) {
+		return NewError(ErrorNotFound, "schedule %s does not exist", id)
+	}
+
+	delete(s.jobs, id)
+
+	err := s.store.save(s.list())
+	if err != nil {
+		s.jobs[id] = job
+		return err
+	}
+
+	s.cron.Remove(job.entry)
+
+	job.mu.Lock()
+	job.removed = true
+	job.mu.Unlock()
+
+	log.Debugw("Schedule removed", "schedule", id)
+
+	return nil
+}
+
+// Schedules of the principal (all schedules for admins) with their next run
+// time ordered by ID.
+func (s *scheduler) status(principal *Principal) []ScheduleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	statuses := make([]ScheduleStatus, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		if principal.owns(job.schedule.principal().owner()) {
+			statuses = append(statuses, job.status())
+		}
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].ID < statuses[j].ID
+	})
+
+	return statuses
+}
+
+func (j *scheduledJob) status() ScheduleStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	status := ScheduleStatus{
+		Schedule: *j.schedule,
+		Next:     j.spec.Next(time.Now()),
+	}
+
+	status.Overlap = j.schedule.overlap()
+
+	// Secrets are redacted the same way as in history.
+	args := redactArguments(j.schedule.Arguments())
+	status.Data = args.Data
+	status.Proxy = args.Proxy
+
+	if j.last != nil {
+		status.LastRef = j.last.Ref
+	}
+
+	return status
+}
+
+// Start the run of the schedule according to its overlap policy and wait
+// for it to finish.
+func (j *scheduledJob) Run() {
+	id := j.schedule.ID
+
+	switch j.schedule.overlap() {
+	case OverlapSkip:
+		if !j.running.TryLock() {
+			log.Infow("Previous run is in progress - scheduled run skipped", "schedule", id)
+			return
+		}
+	case OverlapReplace:
+		j.mu.Lock()
+		if last := j.last; !j.removed && last != nil && last.Active() {
+			log.Infow("Replacing previous scheduled run", "schedule", id, "ref", last.Ref)
+			last.end(ReasonReplaced)
+		}
+		j.mu.Unlock()
+
+		if !j.waitPrevious() {
+			return
+		}
+	default:
+		if !j.waitPrevious() {
+			return
+		}
+	}
+
+	defer j.running.Unlock()
+
+	j.runner.runScheduled(j)
+}
+
+// Wait for the previous run of the schedule to finish unless another firing
+// is already waiting for it. At most one firing is kept pending, so that
+// runs slower than the schedule do not pile up blocked cron goroutines.
+func (j *scheduledJob) waitPrevious() bool {
+	if j.running.TryLock() {
+		return true
+	}
+
+	j.mu.Lock()
+	if j.pending {
+		j.mu.Unlock()
+		log.Infow("Scheduled run is already pending - firing dropped", "schedule", j.schedule.ID)
+		return false
+	}
+	j.pending = true
+	j.mu.Unlock()
+
+	j.running.Lock()
+
+	j.mu.Lock()
+	j.pending = false
+	j.mu.Unlock()
+
+	return true
+}
+
+// Register the run of the job unless its schedule was removed in the
+// meantime (i.e. while the run was waiting for the previous one to finish).
+// Neither run nor error is returned in the latter case.
+func (j *scheduledJob) acquire() (*RobocatRun, *Error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.removed {
+		return nil, nil
+	}
+
+	schedule := j.schedule
+
+	run, rejection := j.runner.acquire(nil, schedule.principal(), ulid.Make().String(), schedule.Arguments())
+	if rejection != nil {
+		return nil, rejection
+	}
+
+	j.last = run
+
+	return run, nil
+}
+
+// Start the run of the scheduled job without session and wait for it to
+// finish.
+func (r *RobocatRunner) runScheduled(job *scheduledJob) {
+	schedule := job.schedule
+
+	// Timeout is known to be valid at this point.
+	timeout, _ := schedule.Arguments().GetTimeout()
+
+	run, rejection := job.acquire()
+	if rejection != nil && rejection.Code == ErrorBusy {
+		log.Warnw("Another flow is already running - scheduled run rejected", "schedule", schedule.ID)
+		r.metrics.reject(RejectBusy)
+		return
+	}
+
+	if rejection != nil {
+		log.Warnw("Scheduled run rejected", "error", rejection, "schedule", schedule.ID)
+		return
+	}
+
+	if run == nil {
+		return
+	}
+
+	log.Infow("Starting scheduled run", "schedule", schedule.ID, "flow", schedule.Flow, "ref", run.Ref)
+
+	if r.waitTurn(run) {
+		r.execute(context.Background(), run, timeout)
+	} else {
+		r.finish(run, nil)
+	}
+
+	if len(schedule.Webhook) > 0 {
+		r.postWebhook(schedule, run)
+	}
+}
+
+// Post history entry of the finished scheduled run to its webhook. Failing
+// to do so is only logged.
+func (r *RobocatRunner) postWebhook(schedule *Schedule, run *RobocatRun) {
+	result := run.record.Result()
+	if result == nil {
+		return
+	}
+
+	body, err := json.Marshal(newHistoryEntry(run, result))
+	if err != nil {
+		log.Warnw("Unable to encode webhook body", "error", err, "ref", run.Ref)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, schedule.Webhook, bytes.NewReader(body))
+	if err != nil {
+		log.Warnw("Unable to create webhook request", "error", err, "ref", run.Ref)
+		return
+	}
+
+	// Allowed hosts might have changed since the schedule was added.
+	if !webhookAllowed(req.URL) {
+		log.Warnw("Webhook host is not allowed - result not posted", "host", req.URL.Host, "schedule", schedule.ID)
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		log.Warnw("Unable to post result to webhook", "error", err, "schedule", schedule.ID, "ref", run.Ref)
+		return
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		log.Warnw("Webhook rejected result", "status", res.Status, "schedule", schedule.ID, "ref", run.Ref)
+		return
+	}
+
+	log.Debugw("Posted result to webhook", "schedule", schedule.ID, "ref", run.Ref)
+}
+
+// Add schedule from the message body and reply with its status.
+func (r *RobocatRunner) ScheduleAdd(
+	ctx context.Context,
+	message *Message,
+) {
+	var schedule *Schedule
+
+	err := message.Decode(&schedule)
+	if err != nil || schedule == nil {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "unable to deserialize body: %v", err)
+		return
+	}
+
+	if len(schedule.ID) == 0 {
+		schedule.ID = ulid.Make().String()
+	}
+
+	schedule.Owner = newScheduleOwner(message.Session().Principal())
+
+	err = schedule.Validate()
+	if err != nil {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, err.Error())
+		return
+	}
+
+	s, err := r.schedules()
+	if err != nil {
+		message.ReplyWithErrorCode(ErrorInternal, "unable to load schedules: %s", err)
+		return
+	}
+
+	job, err := s.add(schedule)
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	message.Reply("schedule", job.status())
+}
+
+// Reply with schedules of the client (all schedules for admins) ordered by
+// ID.
+func (r *RobocatRunner) ScheduleList(
+	ctx context.Context,
+	message *Message,
+) {
+	s, err := r.schedules()
+	if err != nil {
+		message.ReplyWithErrorCode(ErrorInternal, "unable to load schedules: %s", err)
+		return
+	}
+
+	message.Reply("schedules", s.status(message.Session().Principal()))
+}
+
+// Remove the schedule referenced in the message body.
+func (r *RobocatRunner) ScheduleRemove(
+	ctx context.Context,
+	message *Message,
+) {
+	var args *ScheduleRemoveArguments
+
+	err := message.Decode(&args)
+	if err != nil || args == nil || len(args.ID) == 0 {
+		message.ReplyWithErrorCode(ErrorInvalidArguments, "expected schedule id: %v", err)
+		return
+	}
+
+	s, err := r.schedules()
+	if err != nil {
+		message.ReplyWithErrorCode(ErrorInternal, "unable to load schedules: %s", err)
+		return
+	}
+
+	err = s.remove(args.ID, message.Session().Principal())
+	if err != nil {
+		message.ReplyWithError(err)
+		return
+	}
+
+	message.Reply("status", "ok")
+}
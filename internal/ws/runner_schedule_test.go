@@ -0,0 +1,318 @@
+package ws_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	robocat "github.com/robocat-ai/robocat/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func startTestScheduler(t *testing.T, s *testServer) {
+	assert.NoError(t, s.runner.StartScheduler())
+	t.Cleanup(s.runner.StopScheduler)
+}
+
+func TestScheduleCommands(t *testing.T) {
+	s := startTestServer(t, noopScript)
+
+	client := newTestClient(t, s.address)
+	ctx := context.Background()
+
+	for _, schedule := range []robocat.Schedule{
+		{Cron: "not cron", Flow: "fake"},
+		{Cron: "@daily"},
+		{Cron: "@daily", Flow: "fake", TimeZone: "Mars/Olympus"},
+		{Cron: "@daily", Flow: "fake", Overlap: "sometimes"},
+		{Cron: "@daily", Flow: "fake", Webhook: "ftp://example.com"},
+		{Cron: "@daily", Flow: "fake", ID: "../escape"},
+		// Webhook hosts are not allowed unless listed.
+		{Cron: "@daily", Flow: "fake", Webhook: "http://169.254.169.254/latest"},
+	} {
+		_, err := client.AddSchedule(ctx, schedule)
+		assert.ErrorIs(t, err, robocat.ErrInvalidArguments, schedule)
+	}
+
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	daily, err := client.AddSchedule(ctx, robocat.Schedule{
+		ID:       "daily",
+		Cron:     "0 6 * * *",
+		TimeZone: "Asia/Tokyo",
+		Flow:     "fake",
+		Data:     `{"password":"secret"}`,
+		Timeout:  time.Minute,
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "daily", daily.ID)
+		assert.Equal(t, time.Minute, daily.Timeout)
+		assert.Equal(t, `{"password":"xxxxx"}`, daily.Data)
+
+		next := daily.Next.In(tokyo)
+		assert.Equal(t, 6, next.Hour())
+		assert.Equal(t, 0, next.Minute())
+		assert.True(t, next.After(time.Now()))
+	}
+
+	_, err = client.AddSchedule(ctx, robocat.Schedule{ID: "daily", Cron: "@daily", Flow: "fake"})
+	assert.ErrorIs(t, err, robocat.ErrInvalidArguments)
+
+	hourly, err := client.AddSchedule(ctx, robocat.Schedule{Cron: "@every 1h", Flow: "fake"})
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, hourly.ID)
+	}
+
+	schedules, err := client.Schedules(ctx)
+	if assert.NoError(t, err) && assert.Len(t, schedules, 2) {
+		assert.Equal(t, "daily", schedules[1].ID)
+		assert.Equal(t, "Asia/Tokyo", schedules[1].TimeZone)
+		assert.Equal(t, `{"password":"xxxxx"}`, schedules[1].Data)
+	}
+
+	// Schedules are kept in the file.
+	path, err := s.runner.GetFlowBasePath("schedules.json")
+	assert.NoError(t, err)
+
+	var stored []ws.Schedule
+
+	data, err := os.ReadFile(path)
+	if assert.NoError(t, err) && assert.NoError(t, json.Unmarshal(data, &stored)) {
+		assert.Len(t, stored, 2)
+		// Secrets are only redacted in replies.
+		assert.Equal(t, `{"password":"secret"}`, stored[1].Data)
+	}
+
+	assert.NoError(t, client.RemoveSchedule(ctx, "daily"))
+	assert.ErrorIs(t, client.RemoveSchedule(ctx, "daily"), robocat.ErrNotFound)
+
+	schedules, err = client.Schedules(ctx)
+	if assert.NoError(t, err) && assert.Len(t, schedules, 1) {
+		assert.Equal(t, hourly.ID, schedules[0].ID)
+	}
+}
+
+func TestScheduleOwnership(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{
+			{Name: "alice", Key: "alice-key"},
+			{Name: "bob", Key: "bob-key"},
+			{Name: "ops", Key: "ops-key", Scopes: []string{"schedule", "run", "admin"}},
+		}
+	})
+
+	ctx := context.Background()
+
+	alice := newTokenClient(t, s.address, "alice-key")
+	bob := newTokenClient(t, s.address, "bob-key")
+	ops := newTokenClient(t, s.address, "ops-key")
+
+	_, err := alice.AddSchedule(ctx, robocat.Schedule{ID: "daily", Cron: "@daily", Flow: "fake"})
+	assert.NoError(t, err)
+
+	// Schedules of other clients are hidden unless the client is admin.
+	schedules, err := bob.Schedules(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, schedules)
+
+	assert.ErrorIs(t, bob.RemoveSchedule(ctx, "daily"), robocat.ErrNotFound)
+
+	schedules, err = alice.Schedules(ctx)
+	if assert.NoError(t, err) && assert.Len(t, schedules, 1) {
+		assert.Equal(t, "daily", schedules[0].ID)
+	}
+
+	schedules, err = ops.Schedules(ctx)
+	if assert.NoError(t, err) {
+		assert.Len(t, schedules, 1)
+	}
+
+	// Owner is kept in the file.
+	path, err := s.runner.GetFlowBasePath("schedules.json")
+	assert.NoError(t, err)
+
+	var stored []ws.Schedule
+
+	data, err := os.ReadFile(path)
+	if assert.NoError(t, err) && assert.NoError(t, json.Unmarshal(data, &stored)) && assert.Len(t, stored, 1) {
+		assert.Equal(t, &ws.ScheduleOwner{Name: "alice", Method: "api-key"}, stored[0].Owner)
+	}
+
+	assert.NoError(t, ops.RemoveSchedule(ctx, "daily"))
+}
+
+func TestScheduleFile(t *testing.T) {
+	path := t.TempDir() + "/schedules.json"
+	t.Setenv("SCHEDULE_FILE", path)
+
+	err := os.WriteFile(path, []byte(`[
+		{"id": "nightly", "cron": "0 2 * * *", "flow": "fake", "owner": {"method": "anonymous"}}
+	]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := startTestServer(t, noopScript)
+	startTestScheduler(t, s)
+
+	client := newTestClient(t, s.address)
+
+	schedules, err := client.Schedules(context.Background())
+	if assert.NoError(t, err) && assert.Len(t, schedules, 1) {
+		assert.Equal(t, "nightly", schedules[0].ID)
+		assert.Equal(t, "skip", schedules[0].Overlap)
+		assert.Equal(t, 2, schedules[0].Next.Hour())
+	}
+
+	err = os.WriteFile(path, []byte(`[{"id": "broken", "cron": "0 2 * *", "flow": "fake"}]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	another := startTestServer(t, noopScript)
+	assert.ErrorContains(t, another.runner.StartScheduler(), "schedule broken: invalid cron expression")
+}
+
+func TestScheduledRun(t *testing.T) {
+	entries := make(chan ws.HistoryEntry, 10)
+
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var entry ws.HistoryEntry
+
+		err := json.NewDecoder(req.Body).Decode(&entry)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		entries <- entry
+	}))
+	t.Cleanup(webhook.Close)
+
+	webhookURL, err := url.Parse(webhook.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("SCHEDULE_WEBHOOK_HOSTS", "example.com, "+webhookURL.Host)
+
+	s := startTestServer(t, noopScript)
+	startTestScheduler(t, s)
+
+	client := newTestClient(t, s.address)
+	ctx := context.Background()
+
+	_, err = client.AddSchedule(ctx, robocat.Schedule{
+		ID:      "secondly",
+		Cron:    "* * * * * *",
+		Flow:    "fake",
+		Data:    `{"token":"secret"}`,
+		Webhook: webhook.URL,
+	})
+	assert.NoError(t, err)
+
+	var entry ws.HistoryEntry
+
+	select {
+	case entry = <-entries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not called")
+	}
+
+	assert.NoError(t, client.RemoveSchedule(ctx, "secondly"))
+
+	assert.Equal(t, "fake", entry.Flow)
+	assert.Equal(t, "success", entry.Status)
+	// Scheduled runs are started on behalf of the client adding the schedule.
+	assert.Equal(t, "anonymous", entry.Principal)
+	assert.Equal(t, `{"token":"xxxxx"}`, entry.Args.Data)
+
+	// Run is recorded in history as well.
+	recorded, err := client.HistoryEntry(ctx, entry.Ref)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "anonymous", recorded.Principal)
+	}
+}
+
+// Script blocking for a few seconds unless it is stopped earlier.
+func slowScript(ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer) error {
+	select {
+	case <-ctx.Done():
+	case <-time.After(3 * time.Second):
+	}
+
+	return nil
+}
+
+func TestScheduleOverlap(t *testing.T) {
+	tests := []struct {
+		overlap string
+		// Previous runs are finished with the reason.
+		reason string
+	}{
+		{overlap: "skip"},
+		// Only one firing waits for the first run, others are dropped.
+		{overlap: "queue"},
+		{overlap: "replace", reason: "replaced"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.overlap, func(t *testing.T) {
+			s := startTestServer(t, slowScript)
+			startTestScheduler(t, s)
+
+			client := newTestClient(t, s.address)
+			ctx := context.Background()
+
+			_, err := client.AddSchedule(ctx, robocat.Schedule{
+				ID:      "secondly",
+				Cron:    "* * * * * *",
+				Flow:    "fake",
+				Overlap: test.overlap,
+			})
+			assert.NoError(t, err)
+
+			// Letting the schedule fire a few times.
+			time.Sleep(2500 * time.Millisecond)
+
+			assert.NoError(t, client.RemoveSchedule(ctx, "secondly"))
+			assert.Equal(t, 1, s.executor.Running())
+
+			history, err := client.History(ctx, robocat.HistoryFilter{})
+			assert.NoError(t, err)
+
+			if len(test.reason) == 0 {
+				// The first run is still in progress.
+				assert.Empty(t, history)
+				assert.Equal(t, 1, s.executor.Started())
+			} else if assert.NotEmpty(t, history) {
+				for _, entry := range history {
+					assert.Equal(t, test.reason, entry.Result.Reason)
+				}
+
+				assert.Equal(t, len(history)+1, s.executor.Started())
+			}
+
+			// Run in progress is not stopped along with the schedule.
+			assert.Eventually(t, func() bool {
+				return s.executor.Running() == 0
+			}, 5*time.Second, 10*time.Millisecond)
+
+			// Run is recorded in history right after its process exits.
+			assert.Eventually(t, func() bool {
+				history, err := client.History(ctx, robocat.HistoryFilter{Status: "success"})
+				return err == nil && len(history) == 1
+			}, 2*time.Second, 10*time.Millisecond)
+		})
+	}
+}
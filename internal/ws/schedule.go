@@ -0,0 +1,254 @@
+package ws
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// What happens when the schedule fires while its previous run is still in
+// progress.
+type OverlapPolicy string
+
+const (
+	// New run is not started.
+	OverlapSkip OverlapPolicy = "skip"
+	// New run is started once the previous one is finished.
+	OverlapQueue OverlapPolicy = "queue"
+	// Previous run is stopped and the new one is started.
+	OverlapReplace OverlapPolicy = "replace"
+)
+
+// Cron expressions with optional seconds field and descriptors (i.e.
+// "@daily" or "@every 1h").
+var cronParser = cron.NewParser(
+	cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor,
+)
+
+// Flow started by the server on cron schedule.
+type Schedule struct {
+	// Unique ID of the schedule (generated when empty).
+	ID string `json:"id"`
+	// Cron expression (i.e. "0 6 * * *" or "@daily").
+	Cron string `json:"cron"`
+	// IANA time zone the expression is evaluated in (i.e. "Europe/Berlin"),
+	// local time zone of the server by default.
+	TimeZone string `json:"timezone,omitempty"`
+	Flow     string `json:"flow"`
+	Data     string `json:"data,omitempty"`
+	Proxy    string `json:"proxy,omitempty"`
+	// Max duration of every run in time.ParseDuration format.
+	Timeout string `json:"timeout,omitempty"`
+	// "skip" (default), "queue" or "replace".
+	Overlap OverlapPolicy `json:"overlap,omitempty"`
+	// URL the history entry of every finished run is posted to as JSON.
+	Webhook string `json:"webhook,omitempty"`
+	// Client that has added the schedule (set by the server) - runs of the
+	// schedule are started on its behalf.
+	Owner *ScheduleOwner `json:"owner,omitempty"`
+}
+
+// Client that has added the schedule.
+type ScheduleOwner struct {
+	Name   string `json:"name,omitempty"`
+	Method string `json:"method"`
+}
+
+func newScheduleOwner(principal *Principal) *ScheduleOwner {
+	if principal == nil {
+		return nil
+	}
+
+	return &ScheduleOwner{Name: principal.Name, Method: principal.Method}
+}
+
+// Principal runs of the schedule are started on behalf of - schedules
+// without owner (i.e. added before owners were recorded) run on their own
+// behalf and are only managed by admins.
+func (s *Schedule) principal() *Principal {
+	if s.Owner == nil {
+		return &Principal{Name: s.ID, Method: "schedule"}
+	}
+
+	return &Principal{Name: s.Owner.Name, Method: s.Owner.Method}
+}
+
+func (s *Schedule) Validate() error {
+	if !artifactRefPattern.MatchString(s.ID) {
+		return fmt.Errorf("invalid schedule id: '%s'", s.ID)
+	}
+
+	if len(s.Flow) == 0 {
+		return errors.New("flow must not be empty")
+	}
+
+	if _, err := s.parse(); err != nil {
+		return err
+	}
+
+	if _, err := s.Arguments().GetTimeout(); err != nil {
+		return err
+	}
+
+	switch s.Overlap {
+	case "", OverlapSkip, OverlapQueue, OverlapReplace:
+	default:
+		return fmt.Errorf("unknown overlap policy: %s", s.Overlap)
+	}
+
+	if len(s.Webhook) > 0 {
+		u, err := url.Parse(s.Webhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			return fmt.Errorf("webhook must be http(s) URL: '%s'", s.Webhook)
+		}
+
+		if !webhookAllowed(u) {
+			return fmt.Errorf("webhook host is not allowed: '%s'", u.Host)
+		}
+	}
+
+	return nil
+}
+
+// Check that the host of the webhook is listed in SCHEDULE_WEBHOOK_HOSTS
+// (comma-separated hosts with optional ports) - webhooks are posted by the
+// server, so arbitrary URLs would let clients reach internal services.
+// Webhooks are disabled when no hosts are listed.
+func webhookAllowed(u *url.URL) bool {
+	for _, host := range strings.Split(os.Getenv("SCHEDULE_WEBHOOK_HOSTS"), ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if len(host) == 0 {
+			continue
+		}
+
+		if host == strings.ToLower(u.Host) || host == strings.ToLower(u.Hostname()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Parse cron expression in time zone of the schedule.
+func (s *Schedule) parse() (cron.Schedule, error) {
+	if strings.HasPrefix(s.Cron, "TZ=") || strings.HasPrefix(s.Cron, "CRON_TZ=") {
+		return nil, errors.New("time zone must be set with timezone field")
+	}
+
+	location := time.Local
+	if len(s.TimeZone) > 0 {
+		var err error
+		location, err = time.LoadLocation(s.TimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time zone: %w", err)
+		}
+	}
+
+	schedule, err := cronParser.Parse(s.Cron)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression: %w", err)
+	}
+
+	if spec, ok := schedule.(*cron.SpecSchedule); ok {
+		spec.Location = location
+	}
+
+	return schedule, nil
+}
+
+func (s *Schedule) overlap() OverlapPolicy {
+	if len(s.Overlap) == 0 {
+		return OverlapSkip
+	}
+
+	return s.Overlap
+}
+
+// Arguments of runs started by the schedule.
+func (s *Schedule) Arguments() *RunnerArguments {
+	return &RunnerArguments{
+		Flow:    s.Flow,
+		Data:    s.Data,
+		Proxy:   s.Proxy,
+		Timeout: s.Timeout,
+	}
+}
+
+// Schedule listed by "schedule.list" command.
+type ScheduleStatus struct {
+	Schedule
+	// Next time the schedule fires.
+	Next time.Time `json:"next"`
+	// Ref of the last run started by the schedule (empty if none).
+	LastRef string `json:"lastRef,omitempty"`
+}
+
+// Body of "schedule.remove" command.
+type ScheduleRemoveArguments struct {
+	ID string `json:"id"`
+}
+
+// Schedules stored as JSON array, so that the file can be edited by hand as
+// well as managed with commands.
+type scheduleStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newScheduleStore(path string) *scheduleStore {
+	return &scheduleStore{path: path}
+}
+
+// Read all schedules (missing file has no schedules).
+func (s *scheduleStore) load() ([]*Schedule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return make([]*Schedule, 0), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var schedules []*Schedule
+
+	err = json.Unmarshal(data, &schedules)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", s.path, err)
+	}
+
+	return schedules, nil
+}
+
+// Replace stored schedules - they are written ordered by ID.
+func (s *scheduleStore) save(schedules []*Schedule) error {
+	sorted := append([]*Schedule{}, schedules...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ID < sorted[j].ID
+	})
+
+	data, err := json.MarshalIndent(sorted, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(s.path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
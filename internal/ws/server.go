@@ -1,76 +1,105 @@
 package ws
 
 import (
-	"context"
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/docker/go-units"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"nhooyr.io/websocket"
 )
 
+// Default max size of a single message read from the client.
+const DefaultReadLimit = units.MB
+
 type Server struct {
+	// Credentials of the single user allowed to connect with Basic auth
+	// (ignored when Credentials provider is set).
 	Username string
 	Password string
+	// Provider of credentials for Basic authentication.
+	Credentials CredentialProvider
+	// API keys accepted as bearer tokens.
+	APIKeys []APIKey
+	// Secret for verification of HMAC-signed access tokens (tokens are not
+	// accepted when empty).
+	TokenSecret []byte
+	// Max number of bytes to read for a single message.
+	ReadLimit int64
+
+	sessions *sessionRegistry
+	// Executor advertised in server info (set when runner is registered).
+	executor Executor
 
-	state ServerState
+	registeredCallbacks map[string]CommandCallback
+	// HTTP handlers mounted alongside the websocket endpoint keyed by path
+	// prefix.
+	routes map[string]*route
 
-	updates  chan *Message
-	commands chan *Message
+	readinessChecks  []readinessCheck
+	readinessDetails []readinessDetail
+	startedAt        time.Time
 
-	registeredCallbacks map[string]CommandCallback
+	metrics *Metrics
+}
+
+type route struct {
+	handler http.Handler
+	// Route is served without authentication.
+	public bool
 }
 
 func NewServer() *Server {
 	server := &Server{
+		ReadLimit:           DefaultReadLimit,
+		sessions:            newSessionRegistry(),
 		registeredCallbacks: make(map[string]CommandCallback),
+		routes:              make(map[string]*route),
+		startedAt:           time.Now(),
+		metrics:             NewMetrics(),
 	}
 
-	return server
-}
+	server.RouteProbes(true)
+	server.Route(MetricsPath, server.metrics.Handler())
 
-func (s *Server) authenticateRequest(r *http.Request) bool {
-	// return len(s.apiKey) > 0 && r.URL.Query().Get("key") != s.apiKey
-
-	if len(s.Username) > 0 || len(s.Password) > 0 {
-		username, password, ok := r.BasicAuth()
-		if !ok {
-			return false
-		}
-
-		return s.Username == username && s.Password == password
-	}
-
-	return true
+	return server
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	client := r.RemoteAddr
 
+	route := s.route(r.URL.Path)
+	if route != nil && route.public {
+		route.handler.ServeHTTP(w, r)
+		return
+	}
+
 	log := log.With("client", client)
 
 	log.Info("Got incoming connection")
 
-	if s.state.active {
-		log.Debug("There is already an active connection - request rejected")
+	principal, err := s.authenticateRequest(r)
+	if err != nil {
+		log.Debugw("Unable to authenticate - request rejected", "error", err)
+		s.metrics.reject(RejectUnauthorized)
 
-		w.WriteHeader(http.StatusBadRequest)
+		w.WriteHeader(http.StatusUnauthorized)
 		r.Close = true
 
 		return
 	}
 
-	if !s.authenticateRequest(r) {
-		log.Debug("Unable to authenticate - request rejected")
-
-		w.WriteHeader(http.StatusUnauthorized)
-		r.Close = true
-
+	if route != nil {
+		route.handler.ServeHTTP(w, r.WithContext(withPrincipal(r.Context(), principal)))
 		return
 	}
 
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
-		Subprotocols: []string{"robocat"},
+		Subprotocols: Subprotocols,
 	})
 	if err != nil {
 		log.Error(err)
@@ -78,90 +107,155 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	defer c.Close(websocket.StatusInternalError, "server closed connection")
 
-	if c.Subprotocol() != "robocat" {
+	c.SetReadLimit(s.ReadLimit)
+
+	if !isSupportedSubprotocol(c.Subprotocol()) {
 		log.Info("Connection closed - client must speak the robocat subprotocol")
+		s.metrics.reject(RejectSubprotocol)
 		c.Close(websocket.StatusPolicyViolation, "client must speak the robocat subprotocol")
 		return
 	}
 
-	ctx, cancel := context.WithCancel(r.Context())
-	defer cancel()
+	session := newSession(r.Context(), s, client, CodecForSubprotocol(c.Subprotocol()))
+	session.principal = principal
+	defer session.Close()
+
+	log = log.With(
+		"session", session.ID(),
+		"subprotocol", session.Subprotocol(),
+		"principal", principal.String(),
+	)
+
+	s.sessions.add(session)
+	defer s.sessions.remove(session)
+
+	s.metrics.connectionsActive.Inc()
+	defer s.metrics.connectionsActive.Dec()
 
-	s.state.initialize()
-	defer s.state.reset()
+	log.Infow("Session started", "sessions", s.sessions.count())
 
-	go s.listenForCommands(c, ctx, cancel)
-	go s.listenForUpdates(c, ctx)
+	go s.listenForCommands(c, session)
+	go s.listenForUpdates(c, session)
 
-	<-ctx.Done()
+	<-session.Context().Done()
 
 	c.Close(websocket.StatusNormalClosure, "")
 
 	log.Info("Connection closed")
 }
 
-func (s *Server) ConnectionEstablished() bool {
-	return s.state.active
+// Mount HTTP handler for requests with the path prefix (i.e. "/api/runs").
+// Requests are authenticated the same way as websocket connections and the
+// principal is available with PrincipalFromContext.
+func (s *Server) Route(prefix string, handler http.Handler) {
+	s.routes[strings.TrimSuffix(prefix, "/")] = &route{handler: handler}
 }
 
-func (s *Server) sendUpdate(update *Message) error {
-	if !s.ConnectionEstablished() {
-		err := errors.New("connection was not established yet")
-		return err
-	}
+// Mount HTTP handler for requests with the path prefix that is served
+// without authentication.
+func (s *Server) RoutePublic(prefix string, handler http.Handler) {
+	s.routes[strings.TrimSuffix(prefix, "/")] = &route{handler: handler, public: true}
+}
 
-	if s.updates != nil {
-		s.updates <- update
-		return nil
+func (s *Server) route(path string) *route {
+	for prefix, route := range s.routes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return route
+		}
 	}
 
-	return errors.New("update channel has not been initialized")
+	return nil
 }
 
+// Metrics of the server and its runs.
+func (s *Server) Metrics() *Metrics {
+	return s.metrics
+}
+
+// Check if there is at least one client connected to the server.
+func (s *Server) ConnectionEstablished() bool {
+	return s.sessions.count() > 0
+}
+
+// Get connected session by its identifier.
+func (s *Server) Session(id string) (*Session, bool) {
+	return s.sessions.get(id)
+}
+
+// Send an update to every connected session.
 func (s *Server) Send(name string, body ...interface{}) error {
-	update, err := newUpdate(name, body...)
-	if err != nil {
-		return err
+	if !s.ConnectionEstablished() {
+		return errors.New("connection was not established yet")
 	}
 
-	return s.sendUpdate(update)
+	var lastErr error
+
+	for _, session := range s.sessions.all() {
+		err := session.Send(name, body...)
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
 }
 
 func (s *Server) SendError(err error) error {
-	return s.Send("error", err.Error())
+	return s.Send("error", NewErrorBody(err))
 }
 
 func (s *Server) SendErrorf(format string, a ...any) error {
 	return s.SendError(fmt.Errorf(format, a...))
 }
 
-func (s *Server) processCommand(ctx context.Context, message *Message) error {
-	message.server = s
+func (s *Server) processCommand(session *Session, message *Message) error {
+	message.session = session
+
+	s.metrics.countCommand(message.Name, s.hasCommand(message.Name))
 
 	if message.Name == "ping" {
 		return message.Reply("pong")
-	} else {
-		s.broadcastEvent(ctx, message.Name, message)
+	} else if message.Name == "hello" {
+		// Detecting executor version can take a while, so commands that
+		// follow must not be blocked.
+		go message.Reply("hello", s.Info())
+		return nil
 	}
 
-	return nil
+	// Span is ended once the command callback returns.
+	ctx, span := tracer().Start(
+		message.ExtractTrace(session.Context()),
+		"robocat "+message.Name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("robocat.command", message.Name),
+			attribute.String("robocat.ref", message.Ref),
+			attribute.String("robocat.session", session.ID()),
+			attribute.String("robocat.principal", session.Principal().String()),
+		),
+	)
+
+	err := authorizeCommand(session.Principal(), message)
+	if err == nil && !s.broadcastEvent(ctx, message.Name, message) {
+		err = NewError(ErrorUnknownCommand, "unknown command: '%s'", message.Name)
+	}
+
+	if err != nil {
+		endSpan(span, err)
+	}
+
+	return err
 }
 
-func (s *Server) readCommand(
-	c *websocket.Conn,
-	ctx context.Context,
-	cancel context.CancelFunc,
-) {
-	typ, bytes, err := c.Read(ctx)
+func (s *Server) readCommand(c *websocket.Conn, session *Session) {
+	log := log.With("session", session.ID(), "principal", session.Principal().String())
+
+	typ, bytes, err := c.Read(session.Context())
 	status := websocket.CloseStatus(err)
 
 	if status != -1 {
 		log.Debugw("Got close request", "status", status.String())
-		cancel()
-		return
-	} else if !s.ConnectionEstablished() {
-		log.Debug("Read message while connection was not established")
-		cancel()
+		session.Close()
 		return
 	} else if err != nil {
 		log.Debugw(
@@ -169,18 +263,24 @@ func (s *Server) readCommand(
 			"error", err,
 			"message", string(bytes),
 		)
-		cancel()
+		session.Close()
 		return
 	} else {
-		if typ != websocket.MessageText {
-			log.Debug("Only text messages are allowed")
-			s.SendError(fmt.Errorf("only text messages are allowed"))
+		if typ != session.codec.MessageType() {
+			log.Debugw("Unexpected message type", "type", typ.String())
+			session.SendError(NewError(
+				ErrorInvalidArguments,
+				"only %s messages are allowed with %s subprotocol",
+				session.codec.MessageType(), session.Subprotocol(),
+			))
 			return
 		}
 
-		log.With("command", string(bytes)).Debug("Received command")
+		if typ == websocket.MessageText {
+			log.With("command", string(bytes)).Debug("Received command")
+		}
 
-		command, err := commandFromBytes(bytes)
+		command, err := commandFromBytes(session.codec, bytes)
 		if err != nil {
 			log.Debugw(
 				"Got error while trying to parse command",
@@ -188,12 +288,12 @@ func (s *Server) readCommand(
 				"error", err,
 			)
 
-			s.SendError(err)
+			session.SendError(NewError(ErrorInvalidArguments, err.Error()))
 
 			return
 		}
 
-		err = s.processCommand(ctx, command)
+		err = s.processCommand(session, command)
 		if err != nil {
 			log.Debugw(
 				"Got error while processing command",
@@ -201,57 +301,56 @@ func (s *Server) readCommand(
 				"error", err,
 			)
 
-			s.SendError(err)
+			command.ReplyWithError(err)
 
 			return
 		}
 	}
 }
 
-func (s *Server) listenForCommands(
-	c *websocket.Conn,
-	ctx context.Context,
-	cancel context.CancelFunc,
-) {
-	s.commands = make(chan *Message)
+func isSupportedSubprotocol(subprotocol string) bool {
+	for _, supported := range Subprotocols {
+		if subprotocol == supported {
+			return true
+		}
+	}
+
+	return false
+}
 
+func (s *Server) listenForCommands(c *websocket.Conn, session *Session) {
 	for {
 		select {
-		case <-ctx.Done():
+		case <-session.Context().Done():
 			return
 		default:
-			s.readCommand(c, ctx, cancel)
+			s.readCommand(c, session)
 		}
 	}
 }
 
-func (s *Server) listenForUpdates(c *websocket.Conn, ctx context.Context) {
-	s.updates = make(chan *Message)
+func (s *Server) listenForUpdates(c *websocket.Conn, session *Session) {
+	ctx := session.Context()
 
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case update := <-s.updates:
-			if !s.ConnectionEstablished() {
-				log.Debug("Handshake was not established yet")
-				continue
-			}
-
-			bytes, err := update.Bytes()
+		case update := <-session.updates:
+			bytes, err := session.codec.EncodeMessage(update)
 			if err != nil {
 				log.Debugf(
-					"Got error while trying to send update '%v': %s",
-					string(bytes), err,
+					"Got error while trying to encode update '%s': %s",
+					update.Name, err,
 				)
 				continue
 			}
 
-			err = c.Write(ctx, websocket.MessageText, bytes)
+			err = c.Write(ctx, session.codec.MessageType(), bytes)
 			if err != nil {
 				log.Debugf(
-					"Got error while trying to send update '%v': %s",
-					string(bytes), err,
+					"Got error while trying to send update '%s': %s",
+					update.Name, err,
 				)
 				continue
 			}
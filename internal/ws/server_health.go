@@ -0,0 +1,169 @@
+package ws
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/shared"
+)
+
+// Paths of probes served without authentication by default, so that
+// orchestrators (i.e. Kubernetes or docker-compose) can check the server
+// without credentials and without occupying websocket connection.
+const (
+	// Liveness probe - server is able to handle requests.
+	HealthzPath = "/healthz"
+	// Readiness probe - server is able to accept a new run (it may wait for
+	// its turn in the queue).
+	ReadyzPath = "/readyz"
+	// Build version and uptime of the server.
+	InfoPath = "/info"
+)
+
+// Loopback address liveness probe is served on for container healthcheck
+// (HEALTHCHECK_LISTEN).
+const DefaultHealthCheckAddress = "127.0.0.1:8081"
+
+// Check performed by readiness probe - returns error when the server is not
+// ready to start a run.
+type ReadinessCheck func() error
+
+type readinessCheck struct {
+	name  string
+	check ReadinessCheck
+}
+
+// Detail reported by readiness probe without affecting readiness - returns
+// empty string when there is nothing to report.
+type ReadinessDetail func() string
+
+type readinessDetail struct {
+	name   string
+	detail ReadinessDetail
+}
+
+// Body of readiness probe response.
+type Readiness struct {
+	Ready bool `json:"ready"`
+	// Result of every check ("ok" or error message) keyed by check name.
+	Checks map[string]string `json:"checks"`
+	// Informational details (i.e. runs in progress) keyed by name.
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Body of info endpoint response.
+type ServerStatus struct {
+	// Build version of robocat.
+	Version  string `json:"version"`
+	Protocol int    `json:"protocol"`
+	// Name and version of the executor running flows.
+	Executor        string    `json:"executor,omitempty"`
+	ExecutorVersion string    `json:"executorVersion,omitempty"`
+	StartedAt       time.Time `json:"startedAt"`
+	// Time since the server was started (i.e. "1h2m3s").
+	Uptime string `json:"uptime"`
+	// Number of connected websocket sessions.
+	Sessions int `json:"sessions"`
+}
+
+// Add named check to readiness probe.
+func (s *Server) AddReadinessCheck(name string, check ReadinessCheck) {
+	s.readinessChecks = append(s.readinessChecks, readinessCheck{name, check})
+}
+
+// Add named detail to readiness probe.
+func (s *Server) AddReadinessDetail(name string, detail ReadinessDetail) {
+	s.readinessDetails = append(s.readinessDetails, readinessDetail{name, detail})
+}
+
+// Mount probes - they are authenticated like every other route unless
+// public (default).
+func (s *Server) RouteProbes(public bool) {
+	route := s.Route
+	if public {
+		route = s.RoutePublic
+	}
+
+	route(HealthzPath, http.HandlerFunc(s.serveHealthz))
+	route(ReadyzPath, http.HandlerFunc(s.serveReadyz))
+	route(InfoPath, http.HandlerFunc(s.serveInfo))
+}
+
+// Serve only liveness probe over plain HTTP, so that container healthcheck
+// works when the server requires client certificates or authenticates
+// probes. Listener is expected to be bound to loopback interface.
+func (s *Server) ServeHealthCheck(listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc(HealthzPath, s.serveHealthz)
+
+	server := &http.Server{
+		Handler:      mux,
+		ReadTimeout:  time.Second * 10,
+		WriteTimeout: time.Second * 10,
+	}
+
+	return server.Serve(listener)
+}
+
+func (s *Server) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// Run all readiness checks - server is ready only if all checks have passed.
+func (s *Server) Readiness() *Readiness {
+	readiness := &Readiness{
+		Ready:  true,
+		Checks: make(map[string]string),
+	}
+
+	for _, c := range s.readinessChecks {
+		if err := c.check(); err != nil {
+			readiness.Ready = false
+			readiness.Checks[c.name] = err.Error()
+		} else {
+			readiness.Checks[c.name] = "ok"
+		}
+	}
+
+	for _, d := range s.readinessDetails {
+		if detail := d.detail(); len(detail) > 0 {
+			if readiness.Details == nil {
+				readiness.Details = make(map[string]string)
+			}
+
+			readiness.Details[d.name] = detail
+		}
+	}
+
+	return readiness
+}
+
+func (s *Server) serveReadyz(w http.ResponseWriter, r *http.Request) {
+	readiness := s.Readiness()
+
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+
+	writeJSON(w, status, readiness)
+}
+
+func (s *Server) serveInfo(w http.ResponseWriter, r *http.Request) {
+	status := &ServerStatus{
+		Version:   shared.Version,
+		Protocol:  ProtocolVersion,
+		StartedAt: s.startedAt,
+		Uptime:    time.Since(s.startedAt).Round(time.Second).String(),
+		Sessions:  s.sessions.count(),
+	}
+
+	if s.executor != nil {
+		status.Executor = s.executor.Name()
+		status.ExecutorVersion = s.executor.Version()
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
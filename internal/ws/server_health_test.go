@@ -0,0 +1,201 @@
+package ws_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/shared"
+	"github.com/robocat-ai/robocat/internal/ws"
+	"github.com/stretchr/testify/assert"
+)
+
+func probeURL(s *testServer, path string) string {
+	return strings.Replace(s.address, "ws://", "http://", 1) + path
+}
+
+func getReadiness(t *testing.T, s *testServer) (int, *ws.Readiness) {
+	res := apiRequest(t, http.MethodGet, probeURL(s, ws.ReadyzPath), "")
+
+	var readiness *ws.Readiness
+	decodeResponse(t, res, &readiness)
+
+	return res.StatusCode, readiness
+}
+
+func TestHealthz(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.Username = "robocat"
+		server.Password = "secret"
+	})
+
+	// Probes are available without credentials.
+	res := apiRequest(t, http.MethodGet, probeURL(s, ws.HealthzPath), "")
+	body, _ := io.ReadAll(res.Body)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "ok\n", string(body))
+
+	status, _ := getReadiness(t, s)
+	assert.Equal(t, http.StatusOK, status)
+
+	// Everything else still requires credentials.
+	res = apiRequest(t, http.MethodGet, apiURL(s, "/unknown"), "")
+	assert.Equal(t, http.StatusUnauthorized, res.StatusCode)
+}
+
+func TestPrivateProbes(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.Username = "robocat"
+		server.Password = "secret"
+		server.RouteProbes(false)
+	})
+
+	for _, path := range []string{ws.HealthzPath, ws.ReadyzPath, ws.InfoPath} {
+		res := apiRequest(t, http.MethodGet, probeURL(s, path), "")
+		assert.Equal(t, http.StatusUnauthorized, res.StatusCode, path)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, probeURL(s, ws.HealthzPath), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.SetBasicAuth("robocat", "secret")
+
+	res, err := http.DefaultClient.Do(req)
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+	}
+}
+
+func TestHealthCheckListener(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.RouteProbes(false)
+	})
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go s.server.ServeHealthCheck(listener)
+
+	address := "http://" + listener.Addr().String()
+
+	// Liveness probe is served without authentication...
+	res := apiRequest(t, http.MethodGet, address+ws.HealthzPath, "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	// ...but nothing else is.
+	for _, path := range []string{ws.ReadyzPath, ws.InfoPath, "/api/runs"} {
+		res := apiRequest(t, http.MethodGet, address+path, "")
+		assert.Equal(t, http.StatusNotFound, res.StatusCode, path)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	s := startTestServer(t, blockingScript)
+
+	status, readiness := getReadiness(t, s)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, &ws.Readiness{
+		Ready: true,
+		Checks: map[string]string{
+			"flow-path": "ok",
+			"executor":  "ok",
+			"run":       "ok",
+		},
+	}, readiness)
+
+	client := newTestClient(t, s.address)
+
+	flow := client.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+	assert.Equal(t, "started", <-flow.Log().Channel())
+
+	// Server is still ready while new runs are queued.
+	status, readiness = getReadiness(t, s)
+	assert.Equal(t, http.StatusOK, status)
+	assert.True(t, readiness.Ready)
+	assert.Equal(t, "ok", readiness.Checks["run"])
+	assert.Equal(t, map[string]string{"run": "run " + flow.Ref() + " is in progress"}, readiness.Details)
+
+	assert.NoError(t, flow.Cancel(context.Background()))
+	flow.Wait()
+
+	assert.Eventually(t, func() bool {
+		_, readiness := getReadiness(t, s)
+		return readiness.Details == nil
+	}, 5*time.Second, 50*time.Millisecond)
+}
+
+func TestReadyzQueueFull(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "1")
+
+	s := startTestServer(t, blockingScript)
+	ctx := context.Background()
+
+	client := newTestClient(t, s.address)
+
+	running := client.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.Equal(t, "started", <-running.Log().Channel())
+
+	queued := client.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.Eventually(t, func() bool {
+		return queued.QueuePosition() == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	// Server is not ready once the next run would be rejected.
+	status, readiness := getReadiness(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.False(t, readiness.Ready)
+	assert.Equal(t, "run queue is full", readiness.Checks["run"])
+	assert.Equal(t, "run "+running.Ref()+" is in progress, 1 queued", readiness.Details["run"])
+
+	assert.NoError(t, queued.Cancel(ctx))
+	assert.NoError(t, running.Cancel(ctx))
+	running.Wait()
+}
+
+func TestReadyzFlowPath(t *testing.T) {
+	s := startTestServer(t, noopScript)
+
+	// Flow directory cannot be created in place of a regular file.
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0644))
+	s.runner.FlowPath = file
+
+	status, readiness := getReadiness(t, s)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.NotEqual(t, "ok", readiness.Checks["flow-path"])
+	assert.Equal(t, "ok", readiness.Checks["executor"])
+}
+
+func TestInfoEndpoint(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.TokenSecret = []byte("secret")
+	})
+
+	res := apiRequest(t, http.MethodGet, probeURL(s, ws.InfoPath), "")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	var status *ws.ServerStatus
+	decodeResponse(t, res, &status)
+
+	assert.Equal(t, shared.Version, status.Version)
+	assert.Equal(t, ws.ProtocolVersion, status.Protocol)
+	assert.Equal(t, "fake", status.Executor)
+	assert.Equal(t, "fake", status.ExecutorVersion)
+	assert.False(t, status.StartedAt.IsZero())
+	assert.NotEmpty(t, status.Uptime)
+}
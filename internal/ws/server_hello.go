@@ -0,0 +1,85 @@
+package ws
+
+import (
+	"sort"
+
+	"github.com/robocat-ai/robocat/internal/shared"
+)
+
+// Version of the protocol spoken by the server. Servers without "hello"
+// command speak version 1.
+const ProtocolVersion = 2
+
+// Optional features of the protocol advertised by the server.
+const (
+	// Runs can be re-attached to with "attach" command and missed updates
+	// are replayed.
+	FeatureAttach = "attach"
+	// Runs can be stopped by ref with "stop" command.
+	FeatureStopRef = "stop-ref"
+	// Errors are sent with machine-readable codes.
+	FeatureErrorCodes = "error-codes"
+	// Structured "result" update is sent when the run is finished.
+	FeatureResult = "result"
+	// Standard error of the flow is sent as "stderr" updates.
+	FeatureStderr = "stderr"
+	// Large files are transferred in chunks.
+	FeatureChunkedTransfer = "chunked-transfer"
+	// Run timeout is enforced by the server.
+	FeatureServerTimeout = "server-timeout"
+)
+
+var serverFeatures = []string{
+	FeatureAttach,
+	FeatureStopRef,
+	FeatureErrorCodes,
+	FeatureResult,
+	FeatureStderr,
+	FeatureChunkedTransfer,
+	FeatureServerTimeout,
+}
+
+// Body of "hello" update describing the server.
+type ServerInfo struct {
+	// Version of the protocol.
+	Protocol int `json:"protocol"`
+	// Build version of robocat.
+	Version string `json:"version"`
+	// Subprotocols supported by the server in order of preference.
+	Subprotocols []string `json:"subprotocols"`
+	// Name and version of the executor running flows.
+	Executor        string `json:"executor,omitempty"`
+	ExecutorVersion string `json:"executorVersion,omitempty"`
+	// Max size of a single message accepted by the server.
+	MaxMessageSize int64 `json:"maxMessageSize"`
+	// Commands supported by the server.
+	Commands []string `json:"commands"`
+	// Optional protocol features supported by the server.
+	Features []string `json:"features"`
+}
+
+// Information about the server sent in reply to "hello" command.
+func (s *Server) Info() *ServerInfo {
+	commands := []string{"ping", "hello"}
+	for name := range s.registeredCallbacks {
+		commands = append(commands, name)
+	}
+
+	sort.Strings(commands)
+
+	info := &ServerInfo{
+		Protocol:       ProtocolVersion,
+		Version:        shared.Version,
+		Subprotocols:   Subprotocols,
+		MaxMessageSize: s.ReadLimit,
+		Commands:       commands,
+		Features:       serverFeatures,
+	}
+
+	if s.executor != nil {
+		info.Executor = s.executor.Name()
+		info.ExecutorVersion = s.executor.Version()
+	}
+
+	return info
+}
@@ -0,0 +1,1008 @@
+package ws_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/robocat-ai/robocat/internal/ws"
+	robocat "github.com/robocat-ai/robocat/pkg/client"
+	"github.com/stretchr/testify/assert"
+	"nhooyr.io/websocket"
+)
+
+type testServer struct {
+	executor *ws.FakeExecutor
+	runner   *ws.RobocatRunner
+	server   *ws.Server
+	listener *trackingListener
+	address  string
+}
+
+// Listener keeping track of accepted connections, so that they can be
+// dropped to simulate network failures.
+type trackingListener struct {
+	net.Listener
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func (l *trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.mu.Lock()
+		l.conns = append(l.conns, conn)
+		l.mu.Unlock()
+	}
+
+	return conn, err
+}
+
+func (l *trackingListener) dropConnections() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, conn := range l.conns {
+		conn.Close()
+	}
+
+	l.conns = nil
+}
+
+func startTestServer(t *testing.T, script ws.FakeScript, configure ...func(*ws.Server)) *testServer {
+	executor := ws.NewFakeExecutor(script)
+
+	runner := ws.NewRobocatRunner(executor)
+	runner.FlowPath = t.TempDir()
+
+	server := ws.NewServer()
+	runner.Register(server)
+
+	for _, c := range configure {
+		c(server)
+	}
+
+	s := httptest.NewUnstartedServer(server)
+	listener := &trackingListener{Listener: s.Listener}
+	s.Listener = listener
+	s.Start()
+	t.Cleanup(s.Close)
+
+	return &testServer{
+		executor: executor,
+		runner:   runner,
+		server:   server,
+		listener: listener,
+		address:  fmt.Sprintf("ws://%s", s.Listener.Addr().String()),
+	}
+}
+
+func newTestServer(t *testing.T, script ws.FakeScript) (*ws.FakeExecutor, string) {
+	s := startTestServer(t, script)
+	return s.executor, s.address
+}
+
+func newTestClient(t *testing.T, address string) *robocat.Client {
+	client, err := robocat.Connect(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	return client
+}
+
+// Client authenticated with the API key.
+func newTokenClient(t *testing.T, address string, token string) *robocat.Client {
+	client, err := robocat.ConnectWithToken(address, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	return client
+}
+
+func TestFakeFlow(t *testing.T) {
+	executor, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintf(stdout, "running %s\n", args.Flow)
+		fmt.Fprintln(stdout, "done")
+		return nil
+	})
+
+	client := newTestClient(t, address)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	lines := collectLines(flow.Log())
+
+	err := flow.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, executor.Started())
+	assert.Equal(t, []string{"running fake", "done"}, <-lines)
+
+	result := flow.Result()
+	if assert.NotNil(t, result) {
+		assert.Equal(t, 0, result.ExitCode)
+		assert.Equal(t, "success", result.Reason)
+		assert.Equal(t, 2, result.Logs)
+		assert.Equal(t, 0, result.Files)
+		assert.True(t, result.Duration > 0)
+	}
+}
+
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e)
+}
+
+func (e exitError) ExitCode() int {
+	return int(e)
+}
+
+func TestFlowExitCode(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		return exitError(3)
+	})
+
+	client := newTestClient(t, address)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+
+	err := flow.Wait()
+	assert.ErrorContains(t, err, "run finished with error: exit status 3")
+	assert.ErrorIs(t, err, robocat.ErrProcessExit)
+
+	result := flow.Result()
+	if assert.NotNil(t, result) {
+		assert.Equal(t, 3, result.ExitCode)
+		assert.Equal(t, "error", result.Reason)
+	}
+}
+
+func collectLines(stream *robocat.RobocatLogStream) <-chan []string {
+	result := make(chan []string, 1)
+
+	go func() {
+		lines := make([]string, 0)
+		for line := range stream.Channel() {
+			lines = append(lines, line)
+		}
+
+		result <- lines
+	}()
+
+	return result
+}
+
+func TestFakeFlowError(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintln(stdout, "ERROR - something went wrong")
+		<-ctx.Done()
+		return nil
+	})
+
+	client := newTestClient(t, address)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	err := flow.Wait()
+	assert.ErrorContains(t, err, "something went wrong")
+	assert.ErrorIs(t, err, robocat.ErrFlowErrorLine)
+}
+
+func TestFlowStderr(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintln(stdout, "running")
+		fmt.Fprintln(stderr, "warning: something is off")
+		return nil
+	})
+
+	client := newTestClient(t, address)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	lines := collectLines(flow.Log())
+	stderrLines := collectLines(flow.Stderr())
+
+	assert.NoError(t, flow.Wait())
+	assert.Equal(t, []string{"running"}, <-lines)
+	assert.Equal(t, []string{"warning: something is off"}, <-stderrLines)
+}
+
+func TestFlowStderrError(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintln(stderr, "ERROR - cannot find fake.tag")
+		<-ctx.Done()
+		return nil
+	})
+
+	client := newTestClient(t, address)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	err := flow.Wait()
+	assert.ErrorIs(t, err, robocat.ErrFlowNotFound)
+}
+
+func TestFlowOutput(t *testing.T) {
+	for _, watcher := range []string{"notify", "poll"} {
+		t.Run(watcher, func(t *testing.T) {
+			t.Setenv("OUTPUT_WATCHER", watcher)
+
+			var s *testServer
+			s = startTestServer(t, func(
+				ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+			) error {
+				// Giving output watcher time to start.
+				time.Sleep(200 * time.Millisecond)
+
+				output, err := s.runner.GetFlowBasePath("output", "nested")
+				if err != nil {
+					return err
+				}
+
+				if err := os.MkdirAll(output, 0755); err != nil {
+					return err
+				}
+
+				for i := 1; i <= 3; i++ {
+					err := os.WriteFile(filepath.Join(output, "result.txt"), []byte(fmt.Sprint(i)), 0644)
+					if err != nil {
+						return err
+					}
+
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				time.Sleep(700 * time.Millisecond)
+
+				return nil
+			})
+
+			client := newTestClient(t, s.address)
+
+			flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+			assert.NoError(t, flow.Err())
+
+			files := make(chan []*robocat.File, 1)
+			go func() {
+				received := make([]*robocat.File, 0)
+				for file := range flow.Files().Channel() {
+					received = append(received, file)
+				}
+
+				files <- received
+			}()
+
+			assert.NoError(t, flow.Wait())
+
+			received := <-files
+			if assert.Len(t, received, 1) {
+				assert.Equal(t, filepath.Join("nested", "result.txt"), received[0].Path)
+				assert.Equal(t, "3", received[0].Text())
+			}
+		})
+	}
+}
+
+func TestChunkedInput(t *testing.T) {
+	s := startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+	assert.NoError(t, client.SetChunkSize("1KB"))
+
+	content := make([]byte, 5500)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	err := client.Input("nested/large.bin", content)
+	assert.NoError(t, err)
+
+	inputPath, err := s.runner.GetFlowBasePath("input", "nested", "large.bin")
+	assert.NoError(t, err)
+
+	written, err := os.ReadFile(inputPath)
+	assert.NoError(t, err)
+	assert.Equal(t, content, written)
+}
+
+func TestChunkedInputResume(t *testing.T) {
+	s := startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+	client.SetReconnectPolicy(&robocat.ReconnectPolicy{
+		InitialDelay: 20 * time.Millisecond,
+		MaxAttempts:  5,
+	})
+	assert.NoError(t, client.SetChunkSize("1KB"))
+
+	content := make([]byte, 500*1000)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	uploaded := make(chan error, 1)
+	go func() {
+		uploaded <- client.Input("large.bin", content)
+	}()
+
+	// Interrupting the upload in the middle of the transfer.
+	time.Sleep(50 * time.Millisecond)
+	s.listener.dropConnections()
+
+	assert.NoError(t, <-uploaded)
+
+	inputPath, err := s.runner.GetFlowBasePath("input", "large.bin")
+	assert.NoError(t, err)
+
+	written, err := os.ReadFile(inputPath)
+	assert.NoError(t, err)
+	assert.Equal(t, content, written)
+}
+
+func TestChunkedInputTraversal(t *testing.T) {
+	s := startTestServer(t, noopScript)
+
+	client := newTestClient(t, s.address)
+	assert.NoError(t, client.SetChunkSize("1KB"))
+
+	err := client.Input("../escaped.bin", make([]byte, 5000))
+	assert.ErrorContains(t, err, "outside of input directory")
+
+	escaped, err := s.runner.GetFlowBasePath("escaped.bin")
+	assert.NoError(t, err)
+	assert.NoFileExists(t, escaped)
+}
+
+func TestInputTransferOwner(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{
+			{Name: "alice", Key: "alice-key"},
+			{Name: "bob", Key: "bob-key"},
+		}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	command := func(token string, message string) string {
+		conn, _, err := websocket.Dial(ctx, s.address+"?token="+token, &websocket.DialOptions{
+			Subprotocols: []string{ws.SubprotocolJSON},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close(websocket.StatusNormalClosure, "")
+
+		err = conn.Write(ctx, websocket.MessageText, []byte(message))
+		assert.NoError(t, err)
+
+		_, reply, err := conn.Read(ctx)
+		assert.NoError(t, err)
+
+		return string(reply)
+	}
+
+	begin := `{"type":"command","name":"input.begin","ref":"1","body":{"id":"shared","path":"file.bin"}}`
+	chunk := `{"type":"command","name":"input.chunk","ref":"2","body":{"id":"shared","offset":0,"payload":"AAEC"}}`
+
+	assert.Contains(t, command("alice-key", begin), `"name":"transfer"`)
+
+	// Transfer IDs are only valid for the client that has started the
+	// transfer.
+	assert.Contains(t, command("bob-key", chunk), "was not started")
+	assert.Contains(t, command("alice-key", chunk), `"offset":3`)
+
+	// The same ID is a separate transfer for another client.
+	assert.Contains(t, command("bob-key", begin), `"offset":0`)
+}
+
+func TestStaleTransfers(t *testing.T) {
+	t.Setenv("TRANSFER_TTL", "1h")
+
+	s := startTestServer(t, noopScript)
+
+	stale, err := s.runner.GetFlowBasePath(".transfers", "input", "owner", "stale")
+	assert.NoError(t, err)
+	fresh, err := s.runner.GetFlowBasePath(".transfers", "input", "owner", "fresh")
+	assert.NoError(t, err)
+	spooled, err := s.runner.GetFlowBasePath(".transfers", "output", "finished", "spooled")
+	assert.NoError(t, err)
+
+	for _, path := range []string{stale, fresh, spooled} {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte("partial"), 0644))
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	assert.NoError(t, os.Chtimes(stale, old, old))
+
+	client := newTestClient(t, s.address)
+	assert.NoError(t, client.SetChunkSize("1KB"))
+
+	// Stale transfers are removed when another transfer is started.
+	assert.NoError(t, client.Input("large.bin", make([]byte, 5000)))
+
+	assert.NoFileExists(t, stale)
+	assert.FileExists(t, fresh)
+	assert.NoDirExists(t, filepath.Dir(spooled))
+}
+
+func TestChunkedOutput(t *testing.T) {
+	t.Setenv("TRANSFER_CHUNK_SIZE", "1KB")
+
+	content := make([]byte, 5500)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	var s *testServer
+	s = startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		// Giving output watcher time to start.
+		time.Sleep(200 * time.Millisecond)
+
+		output, err := s.runner.GetFlowBasePath("output", "large.bin")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(output, content, 0644)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(300 * time.Millisecond)
+
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	files := make(chan []*robocat.File, 1)
+	go func() {
+		received := make([]*robocat.File, 0)
+		for file := range flow.Files().Channel() {
+			received = append(received, file)
+		}
+
+		files <- received
+	}()
+
+	assert.NoError(t, flow.Wait())
+
+	received := <-files
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, "large.bin", received[0].Path)
+		assert.Equal(t, content, received[0].Payload)
+	}
+}
+
+func TestBinarySubprotocol(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		return nil
+	})
+
+	client := newTestClient(t, address)
+	assert.Equal(t, ws.SubprotocolCBOR, client.Subprotocol())
+	assert.NoError(t, client.Ping())
+}
+
+func TestJSONSubprotocol(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Older clients only speak JSON subprotocol.
+	conn, _, err := websocket.Dial(ctx, address, &websocket.DialOptions{
+		Subprotocols: []string{ws.SubprotocolJSON},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	assert.Equal(t, ws.SubprotocolJSON, conn.Subprotocol())
+
+	err = conn.Write(ctx, websocket.MessageText, []byte(`{"type":"command","name":"ping","ref":"1"}`))
+	assert.NoError(t, err)
+
+	typ, bytes, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, websocket.MessageText, typ)
+	assert.JSONEq(t, `{"type":"update","name":"pong","ref":"1"}`, string(bytes))
+}
+
+func TestServerInfo(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		return nil
+	})
+
+	client := newTestClient(t, address)
+
+	info := client.ServerInfo()
+	if assert.NotNil(t, info) {
+		assert.Equal(t, ws.ProtocolVersion, info.Protocol)
+		assert.Equal(t, "fake", info.Executor)
+		assert.Equal(t, "fake", info.ExecutorVersion)
+		assert.Equal(t, int64(ws.DefaultReadLimit), info.MaxMessageSize)
+		assert.True(t, info.HasCommand("run"))
+		assert.True(t, info.HasCommand("attach"))
+		assert.False(t, info.HasCommand("unknown"))
+		assert.True(t, info.HasFeature(ws.FeatureAttach))
+	}
+}
+
+func TestUnknownCommand(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		return nil
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, _, err := websocket.Dial(ctx, address, &websocket.DialOptions{
+		Subprotocols: []string{ws.SubprotocolJSON},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	err = conn.Write(ctx, websocket.MessageText, []byte(`{"type":"command","name":"unknown","ref":"1"}`))
+	assert.NoError(t, err)
+
+	_, bytes, err := conn.Read(ctx)
+	assert.NoError(t, err)
+	assert.JSONEq(
+		t,
+		`{"type":"update","name":"error","ref":"1","body":{"code":"unknown-command","message":"unknown command: 'unknown'"}}`,
+		string(bytes),
+	)
+}
+
+func noopScript(ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer) error {
+	return nil
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{
+			{Name: "ci", Key: "run-only", Scopes: []string{"run"}},
+		}
+	})
+
+	_, err := robocat.ConnectWithToken(s.address, "unknown")
+	assert.Error(t, err)
+
+	client, err := robocat.ConnectWithToken(s.address, "run-only")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	assert.NoError(t, client.Ping())
+
+	err = client.Input("file.txt", []byte("test"))
+	assert.ErrorIs(t, err, robocat.ErrUnauthorized)
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Wait())
+}
+
+func TestTokenAuth(t *testing.T) {
+	secret := []byte("secret")
+
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.TokenSecret = secret
+	})
+
+	expired, err := ws.SignToken(secret, ws.TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "expired",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+	})
+	assert.NoError(t, err)
+
+	_, err = robocat.ConnectWithToken(s.address, expired)
+	assert.Error(t, err)
+
+	eternal, err := ws.SignToken(secret, ws.TokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: "eternal"},
+	})
+	assert.NoError(t, err)
+
+	_, err = robocat.ConnectWithToken(s.address, eternal)
+	assert.Error(t, err)
+
+	token, err := ws.SignToken(secret, ws.TokenClaims{
+		Scope: "input",
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "uploader",
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+	})
+	assert.NoError(t, err)
+
+	client, err := robocat.ConnectWithToken(s.address, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	assert.NoError(t, client.Input("file.txt", []byte("test")))
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.ErrorIs(t, flow.Wait(), robocat.ErrUnauthorized)
+}
+
+type userCredentials map[string]string
+
+func (c userCredentials) Authenticate(username string, password string) (*ws.Principal, error) {
+	if c[username] != password {
+		return nil, fmt.Errorf("invalid password for %s", username)
+	}
+
+	return &ws.Principal{Name: username, Method: "basic"}, nil
+}
+
+func TestCredentialProvider(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.Credentials = userCredentials{"alice": "first", "bob": "second"}
+	})
+
+	_, err := robocat.Connect(s.address, robocat.Credentials{Username: "alice", Password: "second"})
+	assert.Error(t, err)
+
+	client, err := robocat.Connect(s.address, robocat.Credentials{Username: "bob", Password: "second"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).Run()
+	assert.NoError(t, flow.Wait())
+}
+
+func TestTokenSubprotocol(t *testing.T) {
+	s := startTestServer(t, noopScript, func(server *ws.Server) {
+		server.APIKeys = []ws.APIKey{{Name: "browser", Key: "key"}}
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Browsers are unable to set headers, so token is sent as subprotocol.
+	conn, _, err := websocket.Dial(ctx, s.address, &websocket.DialOptions{
+		Subprotocols: []string{ws.SubprotocolJSON, ws.TokenSubprotocolPrefix + "key"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close(websocket.StatusNormalClosure, "")
+
+	assert.Equal(t, ws.SubprotocolJSON, conn.Subprotocol())
+
+	_, _, err = websocket.Dial(ctx, s.address+"?token=wrong", &websocket.DialOptions{
+		Subprotocols: []string{ws.SubprotocolJSON},
+	})
+	assert.Error(t, err)
+}
+
+func TestConcurrentSessions(t *testing.T) {
+	_, address := newTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		return nil
+	})
+
+	wg := sync.WaitGroup{}
+
+	for i := 0; i < 3; i++ {
+		client := newTestClient(t, address)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, client.Ping())
+		}()
+	}
+
+	wg.Wait()
+}
+
+func TestReconnectAndAttach(t *testing.T) {
+	t.Setenv("CLEANUP_TIMEOUT", "5s")
+
+	resume := make(chan struct{})
+
+	s := startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintln(stdout, "before")
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-resume:
+		}
+
+		fmt.Fprintln(stdout, "after")
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+	client.SetReconnectPolicy(&robocat.ReconnectPolicy{
+		InitialDelay: 50 * time.Millisecond,
+		MaxAttempts:  5,
+	})
+
+	flow := client.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	assert.Equal(t, "before", <-flow.Log().Channel())
+
+	run, ok := s.runner.GetRun(flow.Ref())
+	assert.True(t, ok)
+
+	session := run.Session()
+	s.listener.dropConnections()
+
+	assert.Eventually(t, func() bool {
+		current := run.Session()
+		return current != nil && current != session
+	}, 5*time.Second, 10*time.Millisecond)
+
+	close(resume)
+
+	assert.Equal(t, "after", <-flow.Log().Channel())
+	assert.NoError(t, flow.Wait())
+}
+
+func TestReplayMissedUpdates(t *testing.T) {
+	t.Setenv("CLEANUP_TIMEOUT", "5s")
+
+	resume := make(chan struct{})
+	written := make(chan struct{})
+
+	s := startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintln(stdout, "first")
+
+		<-resume
+
+		fmt.Fprintln(stdout, "second")
+		fmt.Fprintln(stdout, "third")
+		close(written)
+
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+	client.SetReconnectPolicy(&robocat.ReconnectPolicy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxAttempts:  5,
+	})
+
+	flow := client.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	assert.Equal(t, "first", <-flow.Log().Channel())
+
+	s.listener.dropConnections()
+
+	// Logs are written while the client is disconnected.
+	close(resume)
+	<-written
+
+	assert.Equal(t, "second", <-flow.Log().Channel())
+	assert.Equal(t, "third", <-flow.Log().Channel())
+	assert.NoError(t, flow.Wait())
+}
+
+func TestReplayMissedOutputChunks(t *testing.T) {
+	t.Setenv("CLEANUP_TIMEOUT", "5s")
+	t.Setenv("TRANSFER_CHUNK_SIZE", "1KB")
+	// Chunks do not fit into the replay buffer.
+	t.Setenv("RUN_BUFFER_SIZE", "2")
+
+	content := make([]byte, 5500)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	resume := make(chan struct{})
+	written := make(chan struct{})
+
+	var s *testServer
+	s = startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintln(stdout, "started")
+
+		<-resume
+
+		output, err := s.runner.GetFlowBasePath("output", "large.bin")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(output, content, 0644)
+		if err != nil {
+			return err
+		}
+
+		// Giving output watcher time to send the file.
+		time.Sleep(300 * time.Millisecond)
+		close(written)
+
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+	client.SetReconnectPolicy(&robocat.ReconnectPolicy{
+		InitialDelay: 500 * time.Millisecond,
+		MaxAttempts:  5,
+	})
+
+	flow := client.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	assert.Equal(t, "started", <-flow.Log().Channel())
+
+	s.listener.dropConnections()
+
+	// Output is sent while the client is disconnected.
+	close(resume)
+	<-written
+
+	file := <-flow.Files().Channel()
+	if assert.NotNil(t, file) {
+		assert.Equal(t, "large.bin", file.Path)
+		assert.Equal(t, content, file.Payload)
+	}
+
+	assert.NoError(t, flow.Wait())
+}
+
+func TestRunContextCanceled(t *testing.T) {
+	s := startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		fmt.Fprintln(stdout, "started")
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	client := newTestClient(t, s.address)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	flow := client.Flow("fake").WithTimeout(10 * time.Second).RunContext(ctx)
+	assert.NoError(t, flow.Err())
+
+	assert.Equal(t, "started", <-flow.Log().Channel())
+
+	cancel()
+
+	assert.ErrorIs(t, flow.Wait(), context.Canceled)
+}
+
+func blockingScript(
+	ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+) error {
+	fmt.Fprintln(stdout, "started")
+	<-ctx.Done()
+	return nil
+}
+
+func TestFlowCancel(t *testing.T) {
+	s := startTestServer(t, blockingScript)
+
+	client := newTestClient(t, s.address)
+
+	flow := client.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	assert.Equal(t, "started", <-flow.Log().Channel())
+
+	err := flow.Cancel(context.Background())
+	assert.NoError(t, err)
+
+	err = flow.Wait()
+	assert.ErrorContains(t, err, "flow was aborted: client-stop")
+	assert.Equal(t, "client-stop", flow.Result().Reason)
+
+	err = flow.Cancel(context.Background())
+	assert.ErrorIs(t, err, robocat.ErrNotRunning)
+}
+
+func TestServerSideTimeout(t *testing.T) {
+	s := startTestServer(t, blockingScript)
+
+	client := newTestClient(t, s.address)
+
+	flow := client.Flow("fake").WithTimeout(200 * time.Millisecond).Run()
+	assert.NoError(t, flow.Err())
+
+	err := flow.Wait()
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.Eventually(t, func() bool {
+		return s.executor.Running() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestBusyServer(t *testing.T) {
+	t.Setenv("QUEUE_MAX_LENGTH", "0")
+
+	s := startTestServer(t, blockingScript)
+
+	first := newTestClient(t, s.address)
+	second := newTestClient(t, s.address)
+
+	flow := first.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.Equal(t, "started", <-flow.Log().Channel())
+
+	err := second.Flow("fake").WithTimeout(10 * time.Second).Run().Wait()
+	assert.ErrorIs(t, err, robocat.ErrBusy)
+
+	assert.NoError(t, flow.Cancel(context.Background()))
+}
@@ -0,0 +1,105 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/oklog/ulid/v2"
+)
+
+// Session represents a single client connection. Every session has its own
+// update channel, so replies to commands are only delivered to the client
+// that sent them.
+type Session struct {
+	server *Server
+
+	id     string
+	client string
+	// Codec negotiated for the connection.
+	codec Codec
+	// Client authenticated for the connection.
+	principal *Principal
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	updates chan *Message
+}
+
+func newSession(
+	ctx context.Context,
+	server *Server,
+	client string,
+	codec Codec,
+) *Session {
+	session := &Session{
+		server: server,
+
+		id:     ulid.Make().String(),
+		client: client,
+		codec:  codec,
+
+		updates: make(chan *Message),
+	}
+
+	session.ctx, session.cancel = context.WithCancel(ctx)
+
+	return session
+}
+
+// Unique identifier of the session.
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Remote address of the client connected to this session.
+func (s *Session) Client() string {
+	return s.client
+}
+
+// Context that is canceled when the session is closed.
+func (s *Session) Context() context.Context {
+	return s.ctx
+}
+
+// Client authenticated for the connection.
+func (s *Session) Principal() *Principal {
+	return s.principal
+}
+
+// Subprotocol negotiated for the connection.
+func (s *Session) Subprotocol() string {
+	return s.codec.Subprotocol()
+}
+
+// Close the session and the underlying connection.
+func (s *Session) Close() {
+	s.cancel()
+}
+
+func (s *Session) sendUpdate(update *Message) error {
+	select {
+	case <-s.ctx.Done():
+		return errors.New("session is closed")
+	case s.updates <- update:
+		return nil
+	}
+}
+
+func (s *Session) Send(name string, body ...interface{}) error {
+	update, err := newUpdate(name, body...)
+	if err != nil {
+		return err
+	}
+
+	return s.sendUpdate(update)
+}
+
+func (s *Session) SendError(err error) error {
+	return s.Send("error", NewErrorBody(err))
+}
+
+func (s *Session) SendErrorf(format string, a ...any) error {
+	return s.SendError(fmt.Errorf(format, a...))
+}
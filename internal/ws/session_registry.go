@@ -0,0 +1,55 @@
+package ws
+
+import "sync"
+
+type sessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[string]*Session
+}
+
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions: make(map[string]*Session),
+	}
+}
+
+func (r *sessionRegistry) add(session *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sessions[session.ID()] = session
+}
+
+func (r *sessionRegistry) remove(session *Session) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.sessions, session.ID())
+}
+
+func (r *sessionRegistry) get(id string) (*Session, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	session, ok := r.sessions[id]
+	return session, ok
+}
+
+func (r *sessionRegistry) count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return len(r.sessions)
+}
+
+func (r *sessionRegistry) all() []*Session {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	sessions := make([]*Session, 0, len(r.sessions))
+	for _, session := range r.sessions {
+		sessions = append(sessions, session)
+	}
+
+	return sessions
+}
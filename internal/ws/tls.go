@@ -0,0 +1,154 @@
+package ws
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// Files with certificate and key of the server and optional CA certificates
+// used to verify client certificates (mutual TLS).
+type TLSFiles struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// Require all clients to present a valid certificate. Otherwise client
+	// certificates are verified only when given and other authentication
+	// methods may be used instead.
+	RequireClientCert bool
+}
+
+// TLS configuration read from files which may be reloaded (i.e. after
+// certificate renewal) without restarting the server.
+type TLSReloader struct {
+	files TLSFiles
+
+	mu     sync.RWMutex
+	config *tls.Config
+}
+
+// Read certificates from the files.
+func NewTLSReloader(files TLSFiles) (*TLSReloader, error) {
+	r := &TLSReloader{files: files}
+
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Read certificates from the files again. Current configuration is kept if
+// any of the files is invalid.
+func (r *TLSReloader) Reload() error {
+	config, err := loadTLSConfig(r.files)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.config = config
+	r.mu.Unlock()
+
+	return nil
+}
+
+func loadTLSConfig(files TLSFiles) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(files.CertFile, files.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if len(files.ClientCAFile) > 0 {
+		pem, err := os.ReadFile(files.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("%s: no certificates found", files.ClientCAFile)
+		}
+
+		config.ClientCAs = pool
+
+		if files.RequireClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		} else {
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+		}
+	}
+
+	return config, nil
+}
+
+// Check if every client has to present a valid certificate.
+func (r *TLSReloader) ClientCertRequired() bool {
+	return len(r.files.ClientCAFile) > 0 && r.files.RequireClientCert
+}
+
+// Configuration for TLS listener - every new connection uses the most recently
+// loaded certificates.
+func (r *TLSReloader) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			return r.config, nil
+		},
+	}
+}
+
+// Reload certificates every time SIGHUP is received.
+func (r *TLSReloader) ReloadOnSignal() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+
+	go func() {
+		for range signals {
+			if err := r.Reload(); err != nil {
+				log.Errorw("Unable to reload TLS certificates", "error", err)
+				continue
+			}
+
+			log.Infow("Reloaded TLS certificates", "cert", r.files.CertFile)
+		}
+	}()
+}
+
+// Principal identified by verified client certificate - common name of the
+// subject or, when missing, its first DNS name or email address.
+func certificatePrincipal(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, nil
+	}
+
+	cert := r.TLS.VerifiedChains[0][0]
+
+	name := cert.Subject.CommonName
+	if len(name) == 0 && len(cert.DNSNames) > 0 {
+		name = cert.DNSNames[0]
+	}
+	if len(name) == 0 && len(cert.EmailAddresses) > 0 {
+		name = cert.EmailAddresses[0]
+	}
+
+	if len(name) == 0 {
+		return nil, errors.New("client certificate has no common name")
+	}
+
+	return &Principal{Name: name, Method: "certificate"}, nil
+}
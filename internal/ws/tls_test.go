@@ -0,0 +1,259 @@
+package ws_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	robocat "github.com/robocat-ai/robocat/pkg/client"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCertificate struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// Create certificate signed by the parent (self-signed when parent is nil).
+func newTestCertificate(t *testing.T, template *x509.Certificate, parent *testCertificate) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testCertificate{cert: cert, key: key}
+}
+
+func newTestCA(t *testing.T) *testCertificate {
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "robocat test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil)
+}
+
+func (c *testCertificate) write(t *testing.T, dir string, name string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{
+		Type: "CERTIFICATE", Bytes: c.cert.Raw,
+	}), 0600)
+	assert.NoError(t, err)
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{
+		Type: "EC PRIVATE KEY", Bytes: keyDER,
+	}), 0600)
+	assert.NoError(t, err)
+
+	return certFile, keyFile
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key}
+}
+
+func newServerCertificate(t *testing.T, ca *testCertificate) *testCertificate {
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "robocat"},
+		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1)},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+}
+
+func newClientCertificate(t *testing.T, ca *testCertificate, name string) *testCertificate {
+	return newTestCertificate(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: name},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca)
+}
+
+type tlsTestServer struct {
+	*testServer
+	reloader *ws.TLSReloader
+	ca       *testCertificate
+	dir      string
+}
+
+func startTLSTestServer(t *testing.T, script ws.FakeScript, requireClientCert bool) *tlsTestServer {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+
+	caFile, _ := ca.write(t, dir, "ca")
+	certFile, keyFile := newServerCertificate(t, ca).write(t, dir, "server")
+
+	reloader, err := ws.NewTLSReloader(ws.TLSFiles{
+		CertFile:          certFile,
+		KeyFile:           keyFile,
+		ClientCAFile:      caFile,
+		RequireClientCert: requireClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	executor := ws.NewFakeExecutor(script)
+
+	runner := ws.NewRobocatRunner(executor)
+	runner.FlowPath = t.TempDir()
+
+	server := ws.NewServer()
+	runner.Register(server)
+
+	s := httptest.NewUnstartedServer(server)
+	s.Listener = tls.NewListener(s.Listener, reloader.Config())
+	s.Start()
+	t.Cleanup(s.Close)
+
+	return &tlsTestServer{
+		testServer: &testServer{
+			executor: executor,
+			runner:   runner,
+			server:   server,
+			address:  fmt.Sprintf("wss://%s", s.Listener.Addr().String()),
+		},
+		reloader: reloader,
+		ca:       ca,
+		dir:      dir,
+	}
+}
+
+func (s *tlsTestServer) clientConfig(certificates ...*testCertificate) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.ca.cert)
+
+	config := &tls.Config{RootCAs: pool}
+	for _, c := range certificates {
+		config.Certificates = append(config.Certificates, c.tlsCertificate())
+	}
+
+	return config
+}
+
+func TestMutualTLS(t *testing.T) {
+	s := startTLSTestServer(t, blockingScript, true)
+
+	_, err := robocat.Connect(s.address)
+	assert.Error(t, err, "server certificate must not be trusted by default")
+
+	_, err = robocat.ConnectWithOptions(s.address, robocat.ConnectOptions{
+		TLSConfig: s.clientConfig(),
+	})
+	assert.Error(t, err, "client certificate is required")
+
+	client, err := robocat.ConnectWithOptions(s.address, robocat.ConnectOptions{
+		TLSConfig: s.clientConfig(newClientCertificate(t, s.ca, "alice")),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	flow := client.Flow("fake").WithTimeout(10 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	assert.Equal(t, "started", <-flow.Log().Channel())
+
+	run, ok := s.runner.GetRun(flow.Ref())
+	if assert.True(t, ok) {
+		assert.Equal(t, "alice", run.Principal.Name)
+		assert.Equal(t, "certificate", run.Principal.Method)
+	}
+
+	assert.NoError(t, flow.Cancel(context.Background()))
+}
+
+func TestOptionalClientCertificate(t *testing.T) {
+	s := startTLSTestServer(t, noopScript, false)
+
+	s.server.Username = "robocat"
+	s.server.Password = "secret"
+
+	_, err := robocat.ConnectWithOptions(s.address, robocat.ConnectOptions{
+		TLSConfig: s.clientConfig(),
+	})
+	assert.Error(t, err, "credentials are required without client certificate")
+
+	client, err := robocat.ConnectWithOptions(s.address, robocat.ConnectOptions{
+		Credentials: &robocat.Credentials{Username: "robocat", Password: "secret"},
+		TLSConfig:   s.clientConfig(),
+	})
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+
+	client, err = robocat.ConnectWithOptions(s.address, robocat.ConnectOptions{
+		TLSConfig: s.clientConfig(newClientCertificate(t, s.ca, "bob")),
+	})
+	if assert.NoError(t, err) {
+		client.Close()
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	s := startTLSTestServer(t, noopScript, false)
+
+	servedSerial := func() *big.Int {
+		conn, err := tls.Dial("tcp", s.address[len("wss://"):], s.clientConfig())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	before := servedSerial()
+
+	renewed := newServerCertificate(t, s.ca)
+	renewed.write(t, s.dir, "server")
+
+	assert.Equal(t, before, servedSerial(), "certificate is changed only after reload")
+
+	assert.NoError(t, s.reloader.Reload())
+	assert.Equal(t, renewed.cert.SerialNumber, servedSerial())
+
+	// Broken files do not replace working certificates.
+	assert.NoError(t, os.WriteFile(filepath.Join(s.dir, "server.crt"), []byte("broken"), 0600))
+	assert.Error(t, s.reloader.Reload())
+	assert.Equal(t, renewed.cert.SerialNumber, servedSerial())
+}
@@ -0,0 +1,176 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/robocat-ai/robocat/internal/shared"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer used for server spans.
+const tracerName = "github.com/robocat-ai/robocat/internal/ws"
+
+// Trace context is always propagated in W3C format regardless of the global
+// propagator.
+var tracePropagator = propagation.TraceContext{}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// Add trace context of the span in the context to the message.
+func (m *Message) InjectTrace(ctx context.Context) {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+
+	if len(carrier) > 0 {
+		m.Trace = carrier
+	}
+}
+
+// Context with remote span of the client the message was sent by (parent
+// context is returned as is when the message has no trace context).
+func (m *Message) ExtractTrace(parent context.Context) context.Context {
+	if len(m.Trace) == 0 {
+		return parent
+	}
+
+	return tracePropagator.Extract(parent, propagation.MapCarrier(m.Trace))
+}
+
+// Set up global tracer provider with the exporter selected through
+// OTEL_TRACES_EXPORTER environment variable:
+//
+//	none - tracing is disabled (default)
+//	otlp - spans are sent with OTLP over HTTP (configured with standard
+//	       OTEL_EXPORTER_OTLP_* environment variables)
+//	file - spans are written as JSON lines to OTEL_TRACES_FILE (for offline
+//	       testing)
+//
+// Returned function flushes spans that are not exported yet and must be
+// called before exit.
+func InitTracing(ctx context.Context) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(ctx)
+	case "file":
+		exporter, err = newFileExporter(os.Getenv("OTEL_TRACES_FILE"))
+	default:
+		return nil, fmt.Errorf("unknown traces exporter: %s", name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Service name can be overridden with OTEL_SERVICE_NAME.
+	res, err := resource.New(
+		ctx,
+		resource.WithAttributes(
+			semconv.ServiceNameKey.String("robocat"),
+			semconv.ServiceVersionKey.String(shared.Version),
+		),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(tracePropagator)
+
+	return provider.Shutdown, nil
+}
+
+type fileExporter struct {
+	*stdouttrace.Exporter
+	file *os.File
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	if len(path) == 0 {
+		path = "traces.jsonl"
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fileExporter{Exporter: exporter, file: file}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	err := e.Exporter.Shutdown(ctx)
+	if closeErr := e.file.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// Start span of the run - log lines and output files are recorded as its
+// events.
+func (r *RobocatRunner) startRunSpan(ctx context.Context, run *RobocatRun) context.Context {
+	ctx, run.span = tracer().Start(
+		ctx,
+		"run "+run.Args.Flow,
+		trace.WithAttributes(
+			attribute.String("robocat.flow", run.Args.Flow),
+			attribute.String("robocat.ref", run.Ref),
+			attribute.String("robocat.executor", r.executor.Name()),
+			attribute.String("robocat.principal", run.Principal.String()),
+		),
+	)
+
+	return ctx
+}
+
+func endRunSpan(run *RobocatRun, result *RunResult) {
+	run.span.SetAttributes(
+		attribute.String("robocat.reason", string(result.Reason)),
+		attribute.Int("robocat.exit_code", result.ExitCode),
+		attribute.Int("robocat.logs", result.Logs),
+		attribute.Int("robocat.files", result.Files),
+	)
+
+	endSpan(run.span, run.Err())
+}
+
+// End the span marking it as failed when there is an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
@@ -0,0 +1,151 @@
+package ws_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Record spans with global tracer provider for the duration of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	return recorder
+}
+
+func findSpan(spans []sdktrace.ReadOnlySpan, name string, kind trace.SpanKind) sdktrace.ReadOnlySpan {
+	for _, span := range spans {
+		if span.Name() == name && span.SpanKind() == kind {
+			return span
+		}
+	}
+
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var s *testServer
+	s = startTestServer(t, func(
+		ctx context.Context, args *ws.RunnerArguments, stdout, stderr io.Writer,
+	) error {
+		// Giving output watcher time to start.
+		time.Sleep(200 * time.Millisecond)
+
+		fmt.Fprintln(stdout, "hello")
+
+		output, err := s.runner.GetFlowBasePath("output")
+		if err != nil {
+			return err
+		}
+
+		err = os.WriteFile(filepath.Join(output, "result.txt"), []byte("done"), 0644)
+		if err != nil {
+			return err
+		}
+
+		time.Sleep(500 * time.Millisecond)
+
+		return nil
+	})
+
+	client := newTestClient(t, s.address)
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "caller")
+
+	flow := client.Flow("fake").WithTimeout(5 * time.Second).RunContext(ctx)
+	assert.NoError(t, flow.Wait())
+
+	parent.End()
+
+	// Server span of the command is ended once the run is released.
+	var spans []sdktrace.ReadOnlySpan
+	assert.Eventually(t, func() bool {
+		spans = recorder.Ended()
+		return findSpan(spans, "robocat run", trace.SpanKindServer) != nil
+	}, 5*time.Second, 10*time.Millisecond)
+
+	clientSpan := findSpan(spans, "robocat run", trace.SpanKindClient)
+	serverSpan := findSpan(spans, "robocat run", trace.SpanKindServer)
+	runSpan := findSpan(spans, "run fake", trace.SpanKindInternal)
+	startSpan := findSpan(spans, "process.start", trace.SpanKindInternal)
+
+	if !assert.NotNil(t, clientSpan) || !assert.NotNil(t, runSpan) || !assert.NotNil(t, startSpan) {
+		return
+	}
+
+	// Handshake is traced separately.
+	assert.NotNil(t, findSpan(spans, "robocat hello", trace.SpanKindClient))
+
+	traceID := parent.SpanContext().TraceID()
+	for _, span := range []sdktrace.ReadOnlySpan{clientSpan, serverSpan, runSpan, startSpan} {
+		assert.Equal(t, traceID, span.SpanContext().TraceID(), span.Name())
+	}
+
+	assert.Equal(t, parent.SpanContext().SpanID(), clientSpan.Parent().SpanID())
+	assert.Equal(t, clientSpan.SpanContext().SpanID(), serverSpan.Parent().SpanID())
+	assert.True(t, serverSpan.Parent().IsRemote())
+	assert.Equal(t, serverSpan.SpanContext().SpanID(), runSpan.Parent().SpanID())
+	assert.Equal(t, runSpan.SpanContext().SpanID(), startSpan.Parent().SpanID())
+
+	events := make([]string, 0)
+	for _, event := range runSpan.Events() {
+		events = append(events, event.Name)
+	}
+
+	assert.Equal(t, []string{"log", "output"}, events)
+}
+
+func TestFileTraceExporter(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "traces.jsonl")
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "file")
+	t.Setenv("OTEL_TRACES_FILE", file)
+
+	previous := otel.GetTracerProvider()
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+	})
+
+	shutdown, err := ws.InitTracing(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "offline span")
+	span.End()
+
+	assert.NoError(t, shutdown(context.Background()))
+
+	contents, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Contains(t, string(contents), `"Name":"offline span"`)
+	assert.Contains(t, string(contents), `"Value":"robocat"`)
+}
+
+func TestUnknownTraceExporter(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "carrier-pigeon")
+
+	_, err := ws.InitTracing(context.Background())
+	assert.ErrorContains(t, err, "unknown traces exporter")
+}
@@ -0,0 +1,74 @@
+package ws
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"regexp"
+
+	"github.com/docker/go-units"
+)
+
+// Files larger than this are transferred in chunks by default.
+const DefaultChunkSize = 256 * 1024
+
+// Transfer IDs are used as file names, so only safe characters are allowed.
+var transferIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// Body of "input.begin" command and "output.begin" update starting chunked
+// transfer of a file.
+type TransferBegin struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	MimeType string `json:"type"`
+	Size     int64  `json:"size"`
+	// Target of the input file, same as in RobocatFile (inputs only).
+	Ref   string `json:"ref,omitempty"`
+	Scope string `json:"scope,omitempty"`
+}
+
+// Body of "input.chunk" command and "output.chunk" update carrying a part of
+// the file starting at the offset.
+type TransferChunk struct {
+	ID      string `json:"id"`
+	Offset  int64  `json:"offset"`
+	Payload []byte `json:"payload"`
+}
+
+// Body of "input.end" command and "output.end" update finishing the transfer.
+type TransferEnd struct {
+	ID string `json:"id"`
+	// Hex-encoded SHA-256 checksum of the whole file.
+	Checksum string `json:"checksum"`
+}
+
+// Body of "transfer" update reporting number of bytes received so far, so
+// that interrupted transfer can be resumed from this offset.
+type TransferStatus struct {
+	ID     string `json:"id"`
+	Offset int64  `json:"offset"`
+}
+
+type transferBody interface {
+	transferID() string
+}
+
+func (b *TransferBegin) transferID() string { return b.ID }
+func (c *TransferChunk) transferID() string { return c.ID }
+func (e *TransferEnd) transferID() string   { return e.ID }
+
+// Hex-encoded SHA-256 checksum of the payload.
+func Checksum(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Size of chunks for output files configured with TRANSFER_CHUNK_SIZE.
+func transferChunkSize() int {
+	size, err := units.FromHumanSize(os.Getenv("TRANSFER_CHUNK_SIZE"))
+	if err != nil || size < 1 {
+		return DefaultChunkSize
+	}
+
+	return int(size)
+}
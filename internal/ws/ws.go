@@ -1,10 +1,13 @@
 package ws
 
 import (
+	"crypto/tls"
+	"fmt"
 	"net"
 	"net/http"
 	"time"
 
+	"github.com/docker/go-units"
 	"github.com/robocat-ai/robocat/internal/shared"
 	"github.com/sakirsensoy/genv"
 )
@@ -12,13 +15,6 @@ import (
 func Start(options shared.Options) {
 	log.Info("Starting WebSocket module...")
 
-	username := genv.Key("AUTH_USERNAME").String()
-	password := genv.Key("AUTH_PASSWORD").String()
-
-	if len(username) == 0 && len(password) == 0 {
-		log.Warn("No AUTH_USERNAME and AUTH_PASSWORD specified - anybody can connect!")
-	}
-
 	listener, err := net.Listen("tcp", options.ListenAddress)
 	if err != nil {
 		log.Fatal(err)
@@ -26,8 +22,78 @@ func Start(options shared.Options) {
 
 	server := NewServer()
 
-	server.Username = username
-	server.Password = password
+	server.Username = genv.Key("AUTH_USERNAME").String()
+	server.Password = genv.Key("AUTH_PASSWORD").String()
+	server.TokenSecret = []byte(genv.Key("AUTH_TOKEN_SECRET").String())
+
+	if htpasswdFile := genv.Key("AUTH_HTPASSWD_FILE").String(); len(htpasswdFile) > 0 {
+		server.Credentials, err = NewHtpasswdFile(htpasswdFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Infof("Using credentials from %s", htpasswdFile)
+	}
+
+	if apiKeysFile := genv.Key("API_KEYS_FILE").String(); len(apiKeysFile) > 0 {
+		server.APIKeys, err = LoadAPIKeys(apiKeysFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Infof("Loaded %d API keys from %s", len(server.APIKeys), apiKeysFile)
+	}
+
+	var tlsReloader *TLSReloader
+
+	if certFile := genv.Key("TLS_CERT_FILE").String(); len(certFile) > 0 {
+		tlsReloader, err = NewTLSReloader(TLSFiles{
+			CertFile:          certFile,
+			KeyFile:           genv.Key("TLS_KEY_FILE").String(),
+			ClientCAFile:      genv.Key("TLS_CLIENT_CA_FILE").String(),
+			RequireClientCert: genv.Key("TLS_CLIENT_AUTH").Default("require").String() == "require",
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tlsReloader.ReloadOnSignal()
+
+		listener = tls.NewListener(listener, tlsReloader.Config())
+	}
+
+	if !server.authEnabled() && (tlsReloader == nil || !tlsReloader.ClientCertRequired()) {
+		log.Warn("No AUTH_USERNAME, AUTH_PASSWORD, AUTH_HTPASSWD_FILE, API_KEYS_FILE or AUTH_TOKEN_SECRET specified - anybody can connect!")
+	}
+
+	if genv.Key("METRICS_PUBLIC").Default(false).Bool() {
+		server.RoutePublic(MetricsPath, server.Metrics().Handler())
+	}
+
+	if !genv.Key("PROBES_PUBLIC").Default(true).Bool() {
+		server.RouteProbes(false)
+	}
+
+	if address := genv.Key("HEALTHCHECK_LISTEN").Default(DefaultHealthCheckAddress).String(); len(address) > 0 {
+		healthListener, err := net.Listen("tcp", address)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		go func() {
+			err := server.ServeHealthCheck(healthListener)
+			if err != nil {
+				log.Errorw("Healthcheck listener stopped", "error", err)
+			}
+		}()
+	}
+
+	readLimit, err := units.FromHumanSize(genv.Key("MAX_READ_SIZE").Default("1M").String())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server.ReadLimit = readLimit
 
 	s := &http.Server{
 		Handler:      server,
@@ -35,15 +101,84 @@ func Start(options shared.Options) {
 		WriteTimeout: time.Second * 10,
 	}
 
-	runner := NewRobocatRunner()
-	server.On("run", runner.Handle)
-	server.On("stop", runner.Stop)
-	server.On("input", runner.GetInput().Handle)
+	executor, err := NewExecutorFromEnvironment()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Detecting executor version in advance, so that first "hello" is
+	// replied to without delay.
+	go executor.Version()
+
+	if genv.Key("RUN_CONCURRENCY").Default(1).Int() > 1 && !executor.Concurrent() {
+		log.Warnf("%s executor cannot run flows concurrently - RUN_CONCURRENCY is ignored", executor.Name())
+	}
+
+	if genv.Key("RUN_ISOLATION").Default(false).Bool() && !executor.Isolated() {
+		log.Warnf("%s executor does not support isolated run directories - RUN_ISOLATION is ignored", executor.Name())
+	}
+
+	runner := NewRobocatRunner(executor)
+	runner.FlowPath = genv.Key("FLOW_PATH").Default("flow").String()
+	runner.Register(server)
 
-	log.Infof("Listening on ws://%v", listener.Addr())
+	err = runner.StartScheduler()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if tlsReloader != nil {
+		log.Infof("Listening on wss://%v", listener.Addr())
+	} else {
+		log.Infof("Listening on ws://%v", listener.Addr())
+	}
 
 	err = s.Serve(listener)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
+
+// Request liveness probe of the server listening on the address from options
+// and return exit code for container healthcheck.
+func CheckHealth(options shared.Options) int {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	address := genv.Key("HEALTHCHECK_LISTEN").Default(DefaultHealthCheckAddress).String()
+	url := fmt.Sprintf("http://%s%s", address, HealthzPath)
+
+	// Falling back to the main listener when healthcheck listener is disabled
+	// (fails if client certificates are required or probes are not public).
+	if len(address) == 0 {
+		_, port, err := net.SplitHostPort(options.ListenAddress)
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+
+		scheme := "http"
+		if len(genv.Key("TLS_CERT_FILE").String()) > 0 {
+			scheme = "https"
+			// Only liveness is checked here - certificate is verified by clients.
+			client.Transport = &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			}
+		}
+
+		url = fmt.Sprintf("%s://localhost:%s%s", scheme, port, HealthzPath)
+	}
+
+	res, err := client.Get(url)
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		log.Errorf("Health check failed: %s", res.Status)
+		return 1
+	}
+
+	return 0
+}
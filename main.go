@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"math/rand"
 	"net/http"
 	_ "net/http/pprof"
@@ -19,8 +20,17 @@ func main() {
 	rand.Seed(time.Now().UnixMilli())
 	options := shared.InitializeOptions()
 
+	if options.HealthCheck {
+		os.Exit(ws.CheckHealth(options))
+	}
+
 	log.Infof("Starting robocat...")
 
+	shutdownTracing, err := ws.InitTracing(context.Background())
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	go ws.Start(options)
 
 	if options.ProfilerEnabled {
@@ -32,6 +42,14 @@ func main() {
 	}
 
 	waitForExitSignal()
+
+	// Spans that are not exported yet would be lost otherwise.
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := shutdownTracing(ctx); err != nil {
+		log.Error(err)
+	}
 }
 
 func waitForExitSignal() {
@@ -2,13 +2,17 @@ package robocat
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
+	"sync"
 
 	"github.com/docker/go-units"
+	"github.com/robocat-ai/robocat/internal/ws"
 	"nhooyr.io/websocket"
 )
 
@@ -23,14 +27,31 @@ type Client struct {
 
 	logger *Logger
 
+	url         *url.URL
+	token       string
+	tlsConfig   *tls.Config
+	readLimit   int64
+	chunkSize   int
+	reconnect   *ReconnectPolicy
+	reconnected chan struct{}
+
+	mu   sync.RWMutex
 	conn *websocket.Conn
-	err  error
+	// Codec negotiated for the current connection.
+	codec ws.Codec
+	err   error
 
-	registeredCallbacks map[string][]UpdateCallback
+	subscriptionsMu sync.Mutex
+	subscriptions   map[string]*subscription
 
-	cancelFlow chan struct{}
+	flowsMu sync.Mutex
+	flows   map[string]*RobocatFlow
 
 	input *RobocatInput
+
+	// Server info is set once handshake is finished.
+	info      *ServerInfo
+	infoReady chan struct{}
 }
 
 func makeClient() *Client {
@@ -40,15 +61,42 @@ func makeClient() *Client {
 		ctx:       ctx,
 		ctxCancel: cancel,
 
-		registeredCallbacks: make(map[string][]UpdateCallback),
+		reconnected: make(chan struct{}),
+		infoReady:   make(chan struct{}),
 
-		cancelFlow: make(chan struct{}),
+		subscriptions: make(map[string]*subscription),
+		flows:         make(map[string]*RobocatFlow),
 	}
 
 	return client
 }
 
+// Options of the connection to the server.
+type ConnectOptions struct {
+	Credentials *Credentials
+	// API key or signed access token sent as bearer token (credentials are
+	// ignored when set).
+	Token string
+	// Custom TLS configuration for "wss://" URLs (i.e. with private CA or
+	// client certificate).
+	TLSConfig *tls.Config
+}
+
 func Connect(u string, credentials ...Credentials) (*Client, error) {
+	options := ConnectOptions{}
+	if len(credentials) > 0 {
+		options.Credentials = &credentials[0]
+	}
+
+	return ConnectWithOptions(u, options)
+}
+
+// Connect with API key or signed access token sent as bearer token.
+func ConnectWithToken(u string, token string) (*Client, error) {
+	return ConnectWithOptions(u, ConnectOptions{Token: token})
+}
+
+func ConnectWithOptions(u string, options ConnectOptions) (*Client, error) {
 	client := makeClient()
 
 	url, err := url.Parse(u)
@@ -56,22 +104,22 @@ func Connect(u string, credentials ...Credentials) (*Client, error) {
 		return nil, err
 	}
 
-	if len(credentials) > 0 {
-		url.User = credentials[0].GetUserInfo()
+	if len(options.Token) > 0 {
+		client.token = options.Token
+	} else if options.Credentials != nil {
+		url.User = options.Credentials.GetUserInfo()
 	}
 
-	conn, _, err := websocket.Dial(
-		client.ctx,
-		url.String(),
-		&websocket.DialOptions{
-			Subprotocols: []string{"robocat"},
-		},
-	)
+	client.url = url
+	client.tlsConfig = options.TLSConfig
+
+	conn, err := client.dial()
 	if err != nil {
 		return nil, err
 	}
 
 	client.conn = conn
+	client.codec = ws.CodecForSubprotocol(conn.Subprotocol())
 
 	maxReadSize := os.Getenv("MAX_READ_SIZE")
 	if maxReadSize == "" {
@@ -84,10 +132,72 @@ func Connect(u string, credentials ...Credentials) (*Client, error) {
 	}
 
 	go client.listenForUpdates()
+	go client.handshake()
 
 	return client, nil
 }
 
+func (c *Client) dial() (*websocket.Conn, error) {
+	header := http.Header{}
+	if len(c.token) > 0 {
+		header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	var httpClient *http.Client
+	if c.tlsConfig != nil {
+		httpClient = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: c.tlsConfig},
+		}
+	}
+
+	conn, _, err := websocket.Dial(
+		c.ctx,
+		c.url.String(),
+		&websocket.DialOptions{
+			// Server picks binary protocol when it supports one.
+			Subprotocols: ws.Subprotocols,
+			HTTPHeader:   header,
+			HTTPClient:   httpClient,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.readLimit > 0 {
+		conn.SetReadLimit(c.readLimit)
+	}
+
+	return conn, nil
+}
+
+func (c *Client) getConn() *websocket.Conn {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.conn
+}
+
+func (c *Client) getCodec() ws.Codec {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.codec
+}
+
+func (c *Client) getConnAndCodec() (*websocket.Conn, ws.Codec) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.conn, c.codec
+}
+
+// Subprotocol negotiated with the server ("robocat.v2+cbor" for binary
+// protocol or "robocat" for JSON).
+func (c *Client) Subprotocol() string {
+	return c.getCodec().Subprotocol()
+}
+
 // Nax number of bytes to read for a single message.
 // Limit must be in human-readable format (i.e. 10M, 50KB, etc) - for more
 // details refer to https://pkg.go.dev/github.com/docker/go-units@v0.5.0#section-documentation
@@ -97,20 +207,58 @@ func (c *Client) SetSizeLimit(limit string) error {
 		return err
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.readLimit = size
 	c.conn.SetReadLimit(size)
 
 	return nil
 }
 
+// Size of chunks used to upload large input files. Files that are larger
+// than a single chunk are uploaded in parts and put back together by the
+// server. Size must be in the same human-readable format as in SetSizeLimit.
+func (c *Client) SetChunkSize(size string) error {
+	chunkSize, err := units.FromHumanSize(size)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.chunkSize = int(chunkSize)
+
+	return nil
+}
+
 func (c *Client) Close() error {
-	if c.conn != nil {
+	conn := c.getConn()
+
+	if conn != nil {
 		c.ctxCancel()
-		return c.conn.Close(websocket.StatusNormalClosure, "")
+		return conn.Close(websocket.StatusNormalClosure, "")
 	}
 
 	return nil
 }
 
+// Error that caused the client to close (if any).
+func (c *Client) Err() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.err
+}
+
+func (c *Client) setErr(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.err = err
+}
+
 func (c *Client) logDebug(args ...any) {
 	if c.logger != nil && c.logger.Debug != nil {
 		c.logger.Debug(args...)
@@ -135,11 +283,22 @@ func (c *Client) listenForUpdates() {
 		default:
 			message, err := c.readUpdate()
 			if err != nil {
+				if c.ctx.Err() != nil {
+					return
+				}
+
 				if !errors.Is(err, context.Canceled) {
 					c.logError(fmt.Errorf("got listen error: %v", err))
 				}
 
-				c.err = err
+				if c.reconnect != nil {
+					err = c.reconnectWithBackoff(err)
+					if err == nil {
+						continue
+					}
+				}
+
+				c.setErr(err)
 				c.Close()
 				continue
 			}
@@ -149,12 +308,6 @@ func (c *Client) listenForUpdates() {
 	}
 }
 
-// cancelFlowChannel returns a channel that's closed
-// when child flow of this client should be canceled.
-func (c *Client) cancelFlowChannel() <-chan struct{} {
-	return c.cancelFlow
-}
-
 func (c *Client) getInput() *RobocatInput {
 	if c.input == nil {
 		c.input = &RobocatInput{
@@ -164,3 +317,29 @@ func (c *Client) getInput() *RobocatInput {
 
 	return c.input
 }
+
+func (c *Client) trackFlow(flow *RobocatFlow) {
+	c.flowsMu.Lock()
+	defer c.flowsMu.Unlock()
+
+	c.flows[flow.ref] = flow
+}
+
+func (c *Client) untrackFlow(flow *RobocatFlow) {
+	c.flowsMu.Lock()
+	defer c.flowsMu.Unlock()
+
+	delete(c.flows, flow.ref)
+}
+
+func (c *Client) trackedFlows() []*RobocatFlow {
+	c.flowsMu.Lock()
+	defer c.flowsMu.Unlock()
+
+	flows := make([]*RobocatFlow, 0, len(c.flows))
+	for _, flow := range c.flows {
+		flows = append(flows, flow)
+	}
+
+	return flows
+}
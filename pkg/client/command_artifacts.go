@@ -0,0 +1,108 @@
+package robocat
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+)
+
+// Output file retained by the server after the run.
+type Artifact struct {
+	Path     string
+	MimeType string
+	Size     int64
+	// Hex-encoded SHA-256 checksum of the file.
+	SHA256    string
+	CreatedAt time.Time
+}
+
+func (c *Client) Artifacts(ref string) ([]*Artifact, error) {
+	return c.ArtifactsContext(context.Background(), ref)
+}
+
+// List output files retained by the server for the run. ErrNotFound is
+// returned when nothing is retained for the run (i.e. artifacts have
+// expired).
+func (c *Client) ArtifactsContext(ctx context.Context, ref string) ([]*Artifact, error) {
+	m, err := c.request(ctx, "artifacts.list", &ws.ArtifactsListArguments{Ref: ref})
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Name != "artifacts" {
+		return nil, fmt.Errorf("unexpected update message: '%s'", m.Name)
+	}
+
+	var manifest *ws.ArtifactManifest
+
+	err = m.Decode(&manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	artifacts := make([]*Artifact, 0, len(manifest.Files))
+	for _, file := range manifest.Files {
+		artifacts = append(artifacts, &Artifact{
+			Path:      file.Path,
+			MimeType:  file.MimeType,
+			Size:      file.Size,
+			SHA256:    file.SHA256,
+			CreatedAt: file.CreatedAt,
+		})
+	}
+
+	return artifacts, nil
+}
+
+// Download output file retained by the server for the run. Large files are
+// downloaded in parts and verified against their checksum.
+func (c *Client) DownloadArtifact(ctx context.Context, ref string, path string) (*File, error) {
+	var payload bytes.Buffer
+	var artifact ws.Artifact
+
+	for {
+		m, err := c.request(ctx, "artifacts.get", &ws.ArtifactGetArguments{
+			Ref:    ref,
+			Path:   path,
+			Offset: int64(payload.Len()),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if m.Name != "artifact" {
+			return nil, fmt.Errorf("unexpected update message: '%s'", m.Name)
+		}
+
+		var part *ws.ArtifactPart
+
+		err = m.Decode(&part)
+		if err != nil {
+			return nil, err
+		}
+
+		if payload.Len() > 0 && part.SHA256 != artifact.SHA256 {
+			return nil, fmt.Errorf("artifact '%s' was replaced during download", path)
+		}
+
+		artifact = part.Artifact
+		payload.Write(part.Payload)
+
+		if int64(payload.Len()) >= artifact.Size || len(part.Payload) == 0 {
+			break
+		}
+	}
+
+	if ws.Checksum(payload.Bytes()) != artifact.SHA256 {
+		return nil, fmt.Errorf("artifact '%s': %w", path, ErrChecksumMismatch)
+	}
+
+	return &File{
+		Path:     artifact.Path,
+		MimeType: artifact.MimeType,
+		Payload:  payload.Bytes(),
+	}, nil
+}
@@ -2,10 +2,12 @@ package robocat
 
 import (
 	"context"
-	"errors"
+	"fmt"
 	"time"
 
 	"github.com/robocat-ai/robocat/internal/ws"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type FlowCommandChain struct {
@@ -24,6 +26,14 @@ func (c *Client) Flow(flow string) *FlowCommandChain {
 	}
 }
 
+// Arguments sent to the server with the server-side timeout set.
+func (chain *FlowCommandChain) arguments() *ws.RunnerArguments {
+	args := *chain.args
+	args.Timeout = chain.timeout.String()
+
+	return &args
+}
+
 func (chain *FlowCommandChain) WithData(data string) *FlowCommandChain {
 	chain.args.Data = data
 	return chain
@@ -34,74 +44,158 @@ func (chain *FlowCommandChain) WithProxy(proxy string) *FlowCommandChain {
 	return chain
 }
 
+// Priority of the run in the server queue - runs with higher priority are
+// started first.
+func (chain *FlowCommandChain) WithPriority(priority int) *FlowCommandChain {
+	chain.args.Priority = priority
+	return chain
+}
+
 func (chain *FlowCommandChain) WithTimeout(timeout time.Duration) *FlowCommandChain {
 	chain.timeout = timeout
 	return chain
 }
 
 func (chain *FlowCommandChain) Run() *RobocatFlow {
+	return chain.RunContext(context.Background())
+}
+
+// Run the flow. Flow is finished with the context error when the context is
+// done before the flow completes. Span of the run is started from the
+// context, so that server spans of the run are part of the caller's trace.
+func (chain *FlowCommandChain) RunContext(parent context.Context) *RobocatFlow {
+	spanCtx, span := tracer().Start(
+		parent,
+		"robocat run",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("robocat.flow", chain.args.Flow)),
+	)
+
+	ctx, cancel := context.WithTimeout(spanCtx, chain.timeout)
+
 	flow := &RobocatFlow{
 		client: chain.client,
+		ctx:    ctx,
+		cancel: cancel,
 		log:    &RobocatLogStream{},
+		stderr: &RobocatLogStream{},
 		output: &RobocatFileStream{},
 	}
 
-	ref, err := chain.client.sendCommand("run", chain.args)
+	ref, err := chain.client.sendCommandWithCallback(ctx, "run", flow.handleUpdate, chain.arguments())
 	if err != nil {
-		flow.err = err
+		flow.abort(err)
+		flow.log.Close()
+		flow.stderr.Close()
+		flow.output.Close()
+		endSpan(span, err)
 		return flow
 	}
 
-	ctx, cancel := context.WithTimeout(chain.client.ctx, chain.timeout)
-
 	flow.ref = ref
-	flow.ctx = ctx
+	span.SetAttributes(attribute.String("robocat.ref", ref))
 
-	chain.client.subscribe(flow.ref, func(ctx context.Context, m *ws.Message) {
-		if m.Name == "status" {
-			if m.MustText() == "success" {
-				cancel()
-			}
-		} else if m.Name == "log" {
-			flow.log.Push(m.MustText())
-		} else if m.Name == "error" {
-			flow.err = errors.New(m.MustText())
-			cancel()
-		} else if m.Name == "output" {
-			file, err := ws.ParseFileFromMessage(m)
-			if err != nil {
-				flow.err = err
-				cancel()
-			}
+	chain.client.trackFlow(flow)
 
-			flow.output.Push(&File{
-				Path:     file.Path,
-				MimeType: file.MimeType,
-				Payload:  file.Payload,
-			})
-		}
-	})
+	flow.errWait.Add(1)
 
 	go func() {
+		defer flow.errWait.Done()
+		defer func() {
+			endSpan(span, flow.Err())
+		}()
 		defer flow.close()
 		defer cancel()
 
-		flow.errWait.Add(1)
-		defer flow.errWait.Done()
-
-		for {
-			select {
-			case <-ctx.Done():
-				if ctx.Err() == context.DeadlineExceeded {
-					flow.err = context.DeadlineExceeded
-				}
-				return
-			case <-chain.client.cancelFlowChannel():
-				flow.err = errors.New("flow was aborted")
-				return
+		select {
+		case <-ctx.Done():
+			if parent.Err() != nil {
+				flow.setErr(parent.Err())
+				// Flow is abandoned, so there is no reason to keep it running.
+				go flow.Cancel(chain.client.ctx)
+			} else if ctx.Err() == context.DeadlineExceeded {
+				flow.setErr(context.DeadlineExceeded)
 			}
+		case <-chain.client.ctx.Done():
+			// Client error (if any) is reported by flow.Err().
 		}
 	}()
 
 	return flow
 }
+
+func (f *RobocatFlow) handleUpdate(ctx context.Context, m *ws.Message) {
+	if !f.track(m.Seq) {
+		return
+	}
+
+	if m.Name == "status" {
+		f.setQueuePosition(0)
+
+		if m.MustText() == "success" {
+			f.cancel()
+		}
+	} else if m.Name == "queued" {
+		var status ws.QueuedStatus
+
+		err := m.Decode(&status)
+		if err != nil {
+			f.abort(err)
+			return
+		}
+
+		f.setQueuePosition(status.Position)
+	} else if m.Name == "log" {
+		f.log.Push(m.MustText())
+	} else if m.Name == "stderr" {
+		f.stderr.Push(m.MustText())
+	} else if m.Name == "result" {
+		result, err := parseResultFromMessage(m)
+		if err != nil {
+			f.abort(err)
+			return
+		}
+
+		f.setResult(result)
+	} else if m.Name == "error" {
+		f.abort(parseErrorFromMessage(m))
+	} else if m.Name == "stopped" {
+		reason := m.MustText()
+
+		if reason == "timeout" {
+			f.abort(fmt.Errorf("flow was stopped by server: %w", context.DeadlineExceeded))
+		} else {
+			f.abort(fmt.Errorf("flow was aborted: %s", reason))
+		}
+	} else if m.Name == "output" {
+		file, err := ws.ParseFileFromMessage(m)
+		if err != nil {
+			f.abort(err)
+			return
+		}
+
+		f.output.Push(&File{
+			Path:     file.Path,
+			MimeType: file.MimeType,
+			Payload:  file.Payload,
+		})
+	} else if m.Name == "output.begin" {
+		err := f.beginTransfer(m)
+		if err != nil {
+			f.abort(err)
+		}
+	} else if m.Name == "output.chunk" {
+		err := f.receiveChunk(m)
+		if err != nil {
+			f.abort(err)
+		}
+	} else if m.Name == "output.end" {
+		file, err := f.endTransfer(m)
+		if err != nil {
+			f.abort(err)
+			return
+		}
+
+		f.output.Push(file)
+	}
+}
@@ -69,6 +69,7 @@ func TestMissingFlow(t *testing.T) {
 
 	err := flow.Wait()
 	assert.ErrorContains(t, err, "cannot find missing-flow")
+	assert.ErrorIs(t, err, ErrFlowNotFound)
 }
 
 func TestFlowTimeout(t *testing.T) {
@@ -0,0 +1,149 @@
+package robocat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+)
+
+// Filter of run history - zero fields match all runs.
+type HistoryFilter struct {
+	Flow string
+	// "success", "error" or "stopped".
+	Status string
+	// Only runs started at or after the time.
+	Since time.Time
+	// Only runs started before the time.
+	Until time.Time
+	// Max number of runs to return (the latest ones are returned first) -
+	// the server returns 100 runs when zero and at most 500 at once. Older
+	// runs are paged with Until set to StartedAt of the last run.
+	Limit int
+}
+
+// Finished run recorded in server history.
+type HistoryEntry struct {
+	Ref  string
+	Flow string
+	// Arguments of the run with secrets redacted by the server.
+	Data  string
+	Proxy string
+	// Client that has started the run (empty if unknown).
+	Principal string
+	// "success", "error" or "stopped".
+	Status     string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Result     *Result
+	// Error the run has failed with (nil if the run was successful).
+	Error *Error
+	// Output files sent during the run.
+	Files []HistoryFile
+}
+
+// Output file listed in history entry (payload is not kept in history).
+type HistoryFile struct {
+	Path     string
+	MimeType string
+	Size     int
+}
+
+// Get finished runs matching the filter from server history - the latest
+// runs come first.
+func (c *Client) History(ctx context.Context, filter HistoryFilter) ([]*HistoryEntry, error) {
+	body := &ws.HistoryFilter{
+		Flow:   filter.Flow,
+		Status: filter.Status,
+		Limit:  filter.Limit,
+	}
+
+	if !filter.Since.IsZero() {
+		body.Since = &filter.Since
+	}
+
+	if !filter.Until.IsZero() {
+		body.Until = &filter.Until
+	}
+
+	m, err := c.request(ctx, "history", body)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Name != "history" {
+		return nil, fmt.Errorf("unexpected update message: '%s'", m.Name)
+	}
+
+	var entries []*ws.HistoryEntry
+
+	err = m.Decode(&entries)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]*HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		history = append(history, newHistoryEntry(entry))
+	}
+
+	return history, nil
+}
+
+// Get history entry of the finished run by its ref. ErrNotFound is returned
+// when the run is not recorded in history.
+func (c *Client) HistoryEntry(ctx context.Context, ref string) (*HistoryEntry, error) {
+	m, err := c.request(ctx, "history.get", &ws.HistoryGetArguments{Ref: ref})
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Name != "history" {
+		return nil, fmt.Errorf("unexpected update message: '%s'", m.Name)
+	}
+
+	var entry *ws.HistoryEntry
+
+	err = m.Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHistoryEntry(entry), nil
+}
+
+func newHistoryEntry(entry *ws.HistoryEntry) *HistoryEntry {
+	h := &HistoryEntry{
+		Ref:        entry.Ref,
+		Flow:       entry.Flow,
+		Principal:  entry.Principal,
+		Status:     entry.Status,
+		StartedAt:  entry.StartedAt,
+		FinishedAt: entry.FinishedAt,
+		Files:      make([]HistoryFile, 0, len(entry.Files)),
+	}
+
+	if entry.Args != nil {
+		h.Data = entry.Args.Data
+		h.Proxy = entry.Args.Proxy
+	}
+
+	if entry.Result != nil {
+		h.Result = newResult(entry.Result)
+	}
+
+	if entry.Error != nil {
+		h.Error = newErrorFromBody(entry.Error)
+	}
+
+	for _, file := range entry.Files {
+		h.Files = append(h.Files, HistoryFile{
+			Path:     file.Path,
+			MimeType: file.MimeType,
+			Size:     file.Size,
+		})
+	}
+
+	return h
+}
@@ -1,12 +1,27 @@
 package robocat
 
 import (
+	"context"
 	"mime"
 	"path/filepath"
 )
 
 func (c *Client) Input(path string, content []byte) error {
+	return c.InputContext(context.Background(), path, content)
+}
+
+// Upload input file and wait for the server to confirm it until the context
+// is done.
+func (c *Client) InputContext(ctx context.Context, path string, content []byte) error {
+	mimeType := mime.TypeByExtension(filepath.Ext(path))
+
+	return c.getInput().PushContext(ctx, path, mimeType, content)
+}
+
+// Upload input file for the target (i.e. the run in progress) and wait for
+// the server to confirm it until the context is done.
+func (c *Client) InputTo(ctx context.Context, target InputTarget, path string, content []byte) error {
 	mimeType := mime.TypeByExtension(filepath.Ext(path))
 
-	return c.getInput().Push(path, mimeType, content)
+	return c.getInput().PushTo(ctx, target, path, mimeType, content)
 }
@@ -1,7 +1,9 @@
 package robocat
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,3 +15,20 @@ func TestPingCommand(t *testing.T) {
 	err := client.Ping()
 	assert.NoError(t, err)
 }
+
+func TestPingContext(t *testing.T) {
+	client := newTestClient(t)
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := client.PingContext(ctx)
+	assert.NoError(t, err)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = client.PingContext(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
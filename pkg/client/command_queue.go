@@ -0,0 +1,70 @@
+package robocat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+)
+
+// Run waiting for its turn in the server queue.
+type QueuedRun struct {
+	Ref      string
+	Flow     string
+	Priority int
+	// Position in the queue starting from 1.
+	Position int
+	// Client that has started the run (empty if unknown).
+	Principal string
+}
+
+// Get runs waiting in the server queue in order they are going to be
+// started.
+func (c *Client) Queue(ctx context.Context) ([]*QueuedRun, error) {
+	m, err := c.request(ctx, "queue.list")
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Name != "queue" {
+		return nil, fmt.Errorf("unexpected update message: '%s'", m.Name)
+	}
+
+	var queue []ws.QueuedRun
+
+	err = m.Decode(&queue)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]*QueuedRun, 0, len(queue))
+	for _, run := range queue {
+		runs = append(runs, &QueuedRun{
+			Ref:       run.Ref,
+			Flow:      run.Flow,
+			Priority:  run.Priority,
+			Position:  run.Position,
+			Principal: run.Principal,
+		})
+	}
+
+	return runs, nil
+}
+
+// Remove the run from the server queue before it has started. The flow is
+// finished with "canceled" reason. ErrNotFound is returned when the run is
+// not queued (i.e. it has already started).
+func (c *Client) CancelQueued(ctx context.Context, ref string) error {
+	m, err := c.request(ctx, "queue.cancel", &ws.QueueCancelArguments{Ref: ref})
+	if err != nil {
+		return err
+	}
+
+	if m.Name != "status" {
+		return fmt.Errorf("unexpected update message: '%s' (%s)", m.Name, m.MustText())
+	} else if m.MustText() != "ok" {
+		return fmt.Errorf("retured status was not 'ok': '%s'", m.MustText())
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package robocat
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+)
+
+// Flow started by the server on cron schedule.
+type Schedule struct {
+	// Unique ID of the schedule (generated by the server when empty).
+	ID string
+	// Cron expression with optional seconds field (i.e. "0 6 * * *",
+	// "@daily" or "@every 1h").
+	Cron string
+	// IANA time zone the expression is evaluated in (i.e. "Europe/Berlin"),
+	// local time zone of the server by default.
+	TimeZone string
+	Flow     string
+	Data     string
+	Proxy    string
+	// Max duration of every run (no timeout when zero).
+	Timeout time.Duration
+	// What happens when the schedule fires while its previous run is still
+	// in progress - "skip" (default), "queue" or "replace".
+	Overlap string
+	// URL the history entry of every finished run is posted to as JSON.
+	Webhook string
+
+	// Next time the schedule fires (set by the server).
+	Next time.Time
+	// Ref of the last run started by the schedule (set by the server).
+	LastRef string
+}
+
+func newSchedule(status *ws.ScheduleStatus) *Schedule {
+	// Timeout is validated by the server.
+	timeout, _ := status.Arguments().GetTimeout()
+
+	return &Schedule{
+		ID:       status.ID,
+		Cron:     status.Cron,
+		TimeZone: status.TimeZone,
+		Flow:     status.Flow,
+		Data:     status.Data,
+		Proxy:    status.Proxy,
+		Timeout:  timeout,
+		Overlap:  string(status.Overlap),
+		Webhook:  status.Webhook,
+		Next:     status.Next,
+		LastRef:  status.LastRef,
+	}
+}
+
+// Add the schedule to the server and get it back with ID and next run time
+// set. Schedules are kept by the server until they are removed.
+func (c *Client) AddSchedule(ctx context.Context, schedule Schedule) (*Schedule, error) {
+	body := &ws.Schedule{
+		ID:       schedule.ID,
+		Cron:     schedule.Cron,
+		TimeZone: schedule.TimeZone,
+		Flow:     schedule.Flow,
+		Data:     schedule.Data,
+		Proxy:    schedule.Proxy,
+		Overlap:  ws.OverlapPolicy(schedule.Overlap),
+		Webhook:  schedule.Webhook,
+	}
+
+	if schedule.Timeout > 0 {
+		body.Timeout = schedule.Timeout.String()
+	}
+
+	m, err := c.request(ctx, "schedule.add", body)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Name != "schedule" {
+		return nil, fmt.Errorf("unexpected update message: '%s'", m.Name)
+	}
+
+	var status *ws.ScheduleStatus
+
+	err = m.Decode(&status)
+	if err != nil {
+		return nil, err
+	}
+
+	return newSchedule(status), nil
+}
+
+// Get all schedules of the server ordered by ID.
+func (c *Client) Schedules(ctx context.Context) ([]*Schedule, error) {
+	m, err := c.request(ctx, "schedule.list")
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Name != "schedules" {
+		return nil, fmt.Errorf("unexpected update message: '%s'", m.Name)
+	}
+
+	var statuses []*ws.ScheduleStatus
+
+	err = m.Decode(&statuses)
+	if err != nil {
+		return nil, err
+	}
+
+	schedules := make([]*Schedule, 0, len(statuses))
+	for _, status := range statuses {
+		schedules = append(schedules, newSchedule(status))
+	}
+
+	return schedules, nil
+}
+
+// Remove the schedule from the server - its run in progress (if any) is not
+// stopped. ErrNotFound is returned when the schedule does not exist.
+func (c *Client) RemoveSchedule(ctx context.Context, id string) error {
+	m, err := c.request(ctx, "schedule.remove", &ws.ScheduleRemoveArguments{ID: id})
+	if err != nil {
+		return err
+	}
+
+	if m.Name != "status" {
+		return fmt.Errorf("unexpected update message: '%s' (%s)", m.Name, m.MustText())
+	} else if m.MustText() != "ok" {
+		return fmt.Errorf("retured status was not 'ok': '%s'", m.MustText())
+	}
+
+	return nil
+}
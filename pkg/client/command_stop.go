@@ -2,44 +2,26 @@ package robocat
 
 import (
 	"context"
-	"errors"
 	"fmt"
-	"sync"
-
-	"github.com/robocat-ai/robocat/internal/ws"
 )
 
 func (c *Client) Stop() error {
-	ref, err := c.sendCommand("stop")
-	if err != nil {
-		return err
-	}
-
-	wg := sync.WaitGroup{}
-	wg.Add(1)
-
-	c.subscribe(ref, func(ctx context.Context, m *ws.Message) {
-		if m.Name != "status" {
-			err = fmt.Errorf("unexpected update message: '%s'", m.Name)
-		} else if m.MustText() != "ok" {
-			err = fmt.Errorf("retured status was not 'ok': '%s'", m.MustText())
-		} else if m.Ref != ref {
-			err = errors.New("update message reference does not match the command")
-		}
-
-		wg.Done()
-	})
-	defer c.unsubscribe(ref)
-
-	wg.Wait()
+	return c.StopContext(context.Background())
+}
 
+// Stop all flows started by this client and wait for the server to confirm
+// it until the context is done. Use RobocatFlow.Cancel to stop a single flow.
+func (c *Client) StopContext(ctx context.Context) error {
+	m, err := c.request(ctx, "stop")
 	if err != nil {
 		return err
 	}
 
-	// c.ctxCancel()
-
-	c.cancelFlow <- struct{}{}
+	if m.Name != "status" {
+		return fmt.Errorf("unexpected update message: '%s' (%s)", m.Name, m.MustText())
+	} else if m.MustText() != "ok" {
+		return fmt.Errorf("retured status was not 'ok': '%s'", m.MustText())
+	}
 
 	return nil
 }
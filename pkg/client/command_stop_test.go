@@ -1,6 +1,7 @@
 package robocat
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
@@ -29,3 +30,25 @@ func TestStopCommand(t *testing.T) {
 	err = flow.Wait()
 	assert.ErrorContains(t, err, "flow was aborted")
 }
+
+func TestFlowCancel(t *testing.T) {
+	if os.Getenv("CI") != "" {
+		t.Skip()
+	}
+
+	client := newTestClient(t)
+	defer client.Close()
+
+	setClientLogger(client, t)
+
+	flow := client.Flow("02-long-polling").WithTimeout(15 * time.Second).Run()
+	assert.NoError(t, flow.Err())
+
+	time.Sleep(5 * time.Second)
+
+	err := flow.Cancel(context.Background())
+	assert.NoError(t, err)
+
+	err = flow.Wait()
+	assert.ErrorContains(t, err, "flow was aborted: client-stop")
+}
@@ -0,0 +1,100 @@
+package robocat
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+)
+
+var (
+	// Flow with the given name does not exist on the server.
+	ErrFlowNotFound = errors.New("flow not found")
+	// Automation has not started in time.
+	ErrStartTimeout = errors.New("automation start timeout")
+	// Flow has reported an error.
+	ErrFlowErrorLine = errors.New("flow reported an error")
+	// Flow process has exited with non-zero code.
+	ErrProcessExit = errors.New("flow process exited with error")
+	// Command arguments were rejected by the server.
+	ErrInvalidArguments = errors.New("invalid arguments")
+	// Server is busy running another flow.
+	ErrBusy = errors.New("server is busy")
+	// Client is not allowed to perform the command.
+	ErrUnauthorized = errors.New("unauthorized")
+	// Referenced flow is not running.
+	ErrNotRunning = errors.New("flow is not running")
+	// Command is not supported by the server.
+	ErrUnknownCommand = errors.New("unknown command")
+	// Checksum of the transferred file does not match.
+	ErrChecksumMismatch = errors.New("checksum mismatch")
+	// Referenced history entry, artifact, queued run or schedule does not
+	// exist.
+	ErrNotFound = errors.New("not found")
+	// Connection was lost before the server replied to the command.
+	ErrConnectionLost = errors.New("connection lost before reply")
+)
+
+var errorsByCode = map[ws.ErrorCode]error{
+	ws.ErrorFlowNotFound:     ErrFlowNotFound,
+	ws.ErrorStartTimeout:     ErrStartTimeout,
+	ws.ErrorFlowErrorLine:    ErrFlowErrorLine,
+	ws.ErrorProcessExit:      ErrProcessExit,
+	ws.ErrorInvalidArguments: ErrInvalidArguments,
+	ws.ErrorBusy:             ErrBusy,
+	ws.ErrorUnauthorized:     ErrUnauthorized,
+	ws.ErrorNotRunning:       ErrNotRunning,
+	ws.ErrorUnknownCommand:   ErrUnknownCommand,
+	ws.ErrorChecksumMismatch: ErrChecksumMismatch,
+	ws.ErrorNotFound:         ErrNotFound,
+}
+
+// Error reported by the server. Use errors.Is with sentinel errors
+// (i.e. ErrFlowNotFound) to check the kind of the error.
+type Error struct {
+	// Machine-readable error code (i.e. "flow-not-found").
+	Code string
+	// Human-readable error message.
+	Message string
+	// Additional details provided by the server (if any).
+	Details json.RawMessage
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Is(target error) bool {
+	err, ok := errorsByCode[ws.ErrorCode(e.Code)]
+	return ok && err == target
+}
+
+// Parse body of "error" update. Older servers send plain text errors
+// which are converted to errors without code.
+func parseErrorFromMessage(m *ws.Message) *Error {
+	var body *ws.ErrorBody
+
+	err := m.Decode(&body)
+	if err != nil || body == nil {
+		return &Error{
+			Message: m.MustText(),
+		}
+	}
+
+	return newErrorFromBody(body)
+}
+
+func newErrorFromBody(body *ws.ErrorBody) *Error {
+	var details json.RawMessage
+
+	if body.Details != nil {
+		// Details are exposed as JSON regardless of the negotiated encoding.
+		details, _ = json.Marshal(body.Details)
+	}
+
+	return &Error{
+		Code:    string(body.Code),
+		Message: body.Message,
+		Details: details,
+	}
+}
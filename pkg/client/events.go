@@ -2,32 +2,116 @@ package robocat
 
 import (
 	"context"
+	"sync"
 
 	"github.com/robocat-ai/robocat/internal/ws"
 )
 
 type UpdateCallback func(context.Context, *ws.Message)
 
+// Subscription delivers updates with the same ref to callbacks one by one
+// in the order they were received.
+type subscription struct {
+	mu        sync.Mutex
+	callbacks []UpdateCallback
+	pending   []*ws.Message
+	notify    chan struct{}
+	done      chan struct{}
+}
+
+func newSubscription(ctx context.Context) *subscription {
+	s := &subscription{
+		callbacks: make([]UpdateCallback, 0),
+		notify:    make(chan struct{}, 1),
+		done:      make(chan struct{}),
+	}
+
+	go s.deliver(ctx)
+
+	return s
+}
+
+func (s *subscription) push(message *ws.Message) {
+	s.mu.Lock()
+	s.pending = append(s.pending, message)
+	s.mu.Unlock()
+
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *subscription) next() (*ws.Message, []UpdateCallback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil, nil
+	}
+
+	message := s.pending[0]
+	s.pending = s.pending[1:]
+
+	return message, append([]UpdateCallback(nil), s.callbacks...)
+}
+
+func (s *subscription) deliver(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-s.notify:
+			for {
+				message, callbacks := s.next()
+				if message == nil {
+					break
+				}
+
+				for _, callback := range callbacks {
+					callback(ctx, message)
+				}
+			}
+		}
+	}
+}
+
 func (c *Client) subscribe(ref string, callback UpdateCallback) {
-	_, ok := c.registeredCallbacks[ref]
+	c.subscriptionsMu.Lock()
+	defer c.subscriptionsMu.Unlock()
+
+	s, ok := c.subscriptions[ref]
 	if !ok {
-		c.registeredCallbacks[ref] = make([]UpdateCallback, 0)
+		s = newSubscription(c.ctx)
+		c.subscriptions[ref] = s
 	}
 
-	c.registeredCallbacks[ref] = append(c.registeredCallbacks[ref], callback)
+	s.mu.Lock()
+	s.callbacks = append(s.callbacks, callback)
+	s.mu.Unlock()
 }
 
 func (c *Client) unsubscribe(ref string) {
-	c.registeredCallbacks[ref] = nil
+	c.subscriptionsMu.Lock()
+	defer c.subscriptionsMu.Unlock()
+
+	s, ok := c.subscriptions[ref]
+	if ok {
+		close(s.done)
+		delete(c.subscriptions, ref)
+	}
 }
 
 func (c *Client) broadcastEvent(ctx context.Context, message *ws.Message) {
 	c.logDebug("<- recv:", message.Ref, message.Name, message.MustText())
 
-	callbacks, ok := c.registeredCallbacks[message.Ref]
+	c.subscriptionsMu.Lock()
+	s, ok := c.subscriptions[message.Ref]
+	c.subscriptionsMu.Unlock()
+
 	if ok {
-		for _, callback := range callbacks {
-			go callback(ctx, message)
-		}
+		s.push(message)
 	}
 }
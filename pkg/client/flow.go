@@ -2,40 +2,132 @@ package robocat
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+
+	"github.com/robocat-ai/robocat/internal/ws"
 )
 
 type RobocatFlow struct {
 	client *Client
 	ref    string
 	ctx    context.Context
-	err    error
+	cancel context.CancelFunc
+
+	mu  sync.Mutex
+	err error
+	// Sequence number of the last update received from the server.
+	seq    uint64
+	result *Result
+	// Position of the run in the server queue (0 once the run has started).
+	position int
 
 	errWait sync.WaitGroup
 
 	log    *RobocatLogStream
+	stderr *RobocatLogStream
 	output *RobocatFileStream
+
+	// Output files being received in chunks keyed by transfer ID (updates
+	// are delivered sequentially, so no locking is needed).
+	transfers map[string]*outputTransfer
 }
 
 func (f *RobocatFlow) Err() error {
-	if f.err != nil {
-		return fmt.Errorf("flow error: %w", f.err)
+	f.mu.Lock()
+	err := f.err
+	f.mu.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("flow error: %w", err)
 	}
 
-	if f.client.err != nil {
-		return fmt.Errorf("client error: %w", f.client.err)
+	if err := f.client.Err(); err != nil {
+		return fmt.Errorf("client error: %w", err)
 	}
 
 	return nil
 }
 
+// Record flow error unless another error was already recorded.
+func (f *RobocatFlow) setErr(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.err == nil {
+		f.err = err
+	}
+}
+
+// Sequence number of the last update received from the server.
+func (f *RobocatFlow) lastSeq() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.seq
+}
+
+// Record sequence number of the update and report whether the update is
+// new (updates can be replayed by the server after re-attaching).
+func (f *RobocatFlow) track(seq uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if seq == 0 {
+		return true
+	}
+
+	if seq <= f.seq {
+		return false
+	}
+
+	f.seq = seq
+
+	return true
+}
+
+// Finish the flow with the given error.
+func (f *RobocatFlow) abort(err error) {
+	f.setErr(err)
+	f.cancel()
+}
+
 func (f *RobocatFlow) close() {
+	f.client.untrackFlow(f)
 	f.client.unsubscribe(f.ref)
 	f.log.Close()
+	f.stderr.Close()
 	f.output.Close()
 }
 
+// Unique reference of the flow run.
+func (f *RobocatFlow) Ref() string {
+	return f.ref
+}
+
+// Ask the server to stop the flow and wait for it to confirm until the
+// context is done. Flow is finished once the server reports that the run
+// has been stopped.
+func (f *RobocatFlow) Cancel(ctx context.Context) error {
+	if len(f.ref) == 0 {
+		return errors.New("flow was not started")
+	}
+
+	m, err := f.client.request(ctx, "stop", ws.StopArguments{Ref: f.ref})
+	if err != nil {
+		return err
+	}
+
+	if m.Name != "status" {
+		return fmt.Errorf("unexpected update message: '%s' (%s)", m.Name, m.MustText())
+	} else if m.MustText() != "ok" {
+		return fmt.Errorf("retured status was not 'ok': '%s'", m.MustText())
+	}
+
+	return nil
+}
+
 func (f *RobocatFlow) Done() <-chan struct{} {
 	return f.ctx.Done()
 }
@@ -49,10 +141,47 @@ func (f *RobocatFlow) Wait() error {
 	return f.Err()
 }
 
+// Structured result of the run reported by the server (nil until the run
+// is finished).
+func (f *RobocatFlow) Result() *Result {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.result
+}
+
+func (f *RobocatFlow) setResult(result *Result) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.result = result
+}
+
+// Position of the run in the server queue starting from 1 (0 if the run is
+// not queued or has already started).
+func (f *RobocatFlow) QueuePosition() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.position
+}
+
+func (f *RobocatFlow) setQueuePosition(position int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.position = position
+}
+
 func (f *RobocatFlow) Log() *RobocatLogStream {
 	return f.log
 }
 
+// Lines written by the flow process to its standard error.
+func (f *RobocatFlow) Stderr() *RobocatLogStream {
+	return f.stderr
+}
+
 func (f *RobocatFlow) Files() *RobocatFileStream {
 	return f.output
 }
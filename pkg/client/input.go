@@ -4,8 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"sync"
 
+	"github.com/oklog/ulid/v2"
 	"github.com/robocat-ai/robocat/internal/ws"
 )
 
@@ -13,47 +13,213 @@ type RobocatInput struct {
 	client *Client
 }
 
+// Where the input file is written to on the server. Zero value targets the
+// input directory shared by all runs.
+type InputTarget struct {
+	// Ref of the run in progress the input is written for.
+	Ref string
+	// Keep the input for runs started by this connection only (when runs
+	// are isolated on the server). Inputs are removed once the connection
+	// is closed.
+	Session bool
+}
+
+func (t InputTarget) scope() string {
+	if t.Session {
+		return ws.InputScopeSession
+	}
+
+	return ""
+}
+
 func (i *RobocatInput) Push(
 	path string,
 	mimeType string,
 	content []byte,
+) error {
+	return i.PushContext(context.Background(), path, mimeType, content)
+}
+
+// Upload input file and wait for the server to confirm it until the context
+// is done.
+func (i *RobocatInput) PushContext(
+	ctx context.Context,
+	path string,
+	mimeType string,
+	content []byte,
+) error {
+	return i.PushTo(ctx, InputTarget{}, path, mimeType, content)
+}
+
+// Upload input file for the target and wait for the server to confirm it
+// until the context is done.
+func (i *RobocatInput) PushTo(
+	ctx context.Context,
+	target InputTarget,
+	path string,
+	mimeType string,
+	content []byte,
 ) error {
 	if i.client == nil {
 		return errors.New("client is not set")
 	}
 
+	if len(content) > i.chunkSize() {
+		return i.pushChunked(ctx, target, path, mimeType, content)
+	}
+
 	file := &ws.RobocatFile{
 		Path:     path,
 		MimeType: mimeType,
 		Payload:  content,
+		Ref:      target.Ref,
+		Scope:    target.scope(),
 	}
 
-	ref, err := i.client.sendCommand("input", file)
+	m, err := i.client.request(ctx, "input", file)
 	if err != nil {
 		return err
 	}
 
-	wg := sync.WaitGroup{}
-	wg.Add(1)
+	if m.Name != "status" {
+		return fmt.Errorf("unexpected update message: '%s' (%s)", m.Name, m.MustText())
+	} else if m.MustText() != "ok" {
+		return fmt.Errorf("retured status was not 'ok': '%s'", m.MustText())
+	}
+
+	return nil
+}
+
+// Files larger than this are uploaded in chunks.
+func (i *RobocatInput) chunkSize() int {
+	i.client.mu.RLock()
+	defer i.client.mu.RUnlock()
+
+	if i.client.chunkSize > 0 {
+		return i.client.chunkSize
+	}
+
+	return ws.DefaultChunkSize
+}
+
+// Upload the file in chunks. When connection is lost during the upload, the
+// transfer is resumed from the offset reported by the server once the client
+// reconnects.
+func (i *RobocatInput) pushChunked(
+	ctx context.Context,
+	target InputTarget,
+	path string,
+	mimeType string,
+	content []byte,
+) error {
+	begin := ws.TransferBegin{
+		ID:       ulid.Make().String(),
+		Path:     path,
+		MimeType: mimeType,
+		Size:     int64(len(content)),
+		Ref:      target.Ref,
+		Scope:    target.scope(),
+	}
 
-	i.client.subscribe(ref, func(ctx context.Context, m *ws.Message) {
-		if m.Name != "status" {
-			err = fmt.Errorf("unexpected update message: '%s' (%s)", m.Name, m.MustText())
-		} else if m.MustText() != "ok" {
-			err = fmt.Errorf("retured status was not 'ok': '%s'", m.MustText())
-		} else if m.Ref != ref {
-			err = errors.New("update message reference does not match the command")
+	checksum := ws.Checksum(content)
+
+	for {
+		reconnected := i.client.reconnectedChan()
+
+		err := i.upload(ctx, begin, content, checksum)
+		if err == nil {
+			return nil
 		}
 
-		wg.Done()
-	})
-	defer i.client.unsubscribe(ref)
+		var serverErr *Error
+		if errors.As(err, &serverErr) || i.client.getReconnectPolicy() == nil {
+			return err
+		}
+
+		i.client.logDebug(fmt.Sprintf("upload of %s interrupted, waiting for reconnect: %v", path, err))
+
+		select {
+		case <-reconnected:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-i.client.ctx.Done():
+			return err
+		}
+	}
+}
+
+func (i *RobocatInput) upload(
+	ctx context.Context,
+	begin ws.TransferBegin,
+	content []byte,
+	checksum string,
+) error {
+	status, err := i.transfer(ctx, "input.begin", begin)
+	if err != nil {
+		return err
+	}
+
+	offset := status.Offset
+	if offset > begin.Size {
+		return fmt.Errorf("server has received more than %d bytes of %s", begin.Size, begin.Path)
+	}
+
+	for offset < begin.Size {
+		end := offset + int64(i.chunkSize())
+		if end > begin.Size {
+			end = begin.Size
+		}
 
-	wg.Wait()
+		status, err = i.transfer(ctx, "input.chunk", ws.TransferChunk{
+			ID:      begin.ID,
+			Offset:  offset,
+			Payload: content[offset:end],
+		})
+		if err != nil {
+			return err
+		}
 
+		offset = status.Offset
+	}
+
+	m, err := i.client.request(ctx, "input.end", ws.TransferEnd{
+		ID:       begin.ID,
+		Checksum: checksum,
+	})
 	if err != nil {
 		return err
 	}
 
+	if m.Name != "status" {
+		return fmt.Errorf("unexpected update message: '%s' (%s)", m.Name, m.MustText())
+	} else if m.MustText() != "ok" {
+		return fmt.Errorf("retured status was not 'ok': '%s'", m.MustText())
+	}
+
 	return nil
 }
+
+// Send transfer command and parse transfer status sent in reply.
+func (i *RobocatInput) transfer(
+	ctx context.Context,
+	name string,
+	body interface{},
+) (*ws.TransferStatus, error) {
+	m, err := i.client.request(ctx, name, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if m.Name != "transfer" {
+		return nil, fmt.Errorf("unexpected update message: '%s' (%s)", m.Name, m.MustText())
+	}
+
+	var status ws.TransferStatus
+
+	err = m.Decode(&status)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse transfer status: %w", err)
+	}
+
+	return &status, nil
+}
@@ -1,15 +1,17 @@
 package robocat
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
 	"github.com/oklog/ulid/v2"
 	"github.com/robocat-ai/robocat/internal/ws"
-	"nhooyr.io/websocket"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func newCommand(name string, body ...interface{}) (*ws.Message, error) {
+// Create command carrying trace context of the span in the context.
+func newCommand(ctx context.Context, name string, body ...interface{}) (*ws.Message, error) {
 	message, err := ws.NewMessageWithBody(name, body...)
 	if err != nil {
 		return nil, err
@@ -17,38 +19,115 @@ func newCommand(name string, body ...interface{}) (*ws.Message, error) {
 
 	message.Type = ws.Command
 	message.Ref = ulid.Make().String()
+	message.InjectTrace(ctx)
 
 	return message, nil
 }
 
 func (c *Client) sendCommand(name string, body ...interface{}) (string, error) {
-	message, err := newCommand(name, body...)
+	message, err := newCommand(context.Background(), name, body...)
 	if err != nil {
 		return "", err
 	}
 
-	c.logDebug("-> send:", message.Ref, message.Name, message.MustText())
+	return message.Ref, c.writeCommand(message)
+}
 
-	bytes, err := message.Bytes()
+// Send command and subscribe to its updates. Callback is subscribed before
+// the command is sent, so no updates can be missed. Context is only used to
+// propagate trace context to the server.
+func (c *Client) sendCommandWithCallback(
+	ctx context.Context,
+	name string,
+	callback UpdateCallback,
+	body ...interface{},
+) (string, error) {
+	message, err := newCommand(ctx, name, body...)
 	if err != nil {
 		return "", err
 	}
 
-	err = c.conn.Write(c.ctx, websocket.MessageText, bytes)
+	c.subscribe(message.Ref, callback)
+
+	err = c.writeCommand(message)
 	if err != nil {
+		c.unsubscribe(message.Ref)
 		return "", err
 	}
 
 	return message.Ref, nil
 }
 
-func updateFromBytes(bytes []byte) (*ws.Message, error) {
-	message, err := ws.MessageFromBytes(bytes)
+// Send command and wait for the first update in reply. Waiting is aborted
+// when the context is done, the client is closed or reconnected. Command ref
+// is unsubscribed upon return in any case. Error updates are returned as
+// *Error.
+func (c *Client) request(
+	ctx context.Context,
+	name string,
+	body ...interface{},
+) (reply *ws.Message, err error) {
+	ctx, span := tracer().Start(ctx, "robocat "+name, trace.WithSpanKind(trace.SpanKindClient))
+	defer func() {
+		endSpan(span, err)
+	}()
+
+	replies := make(chan *ws.Message, 1)
+	// Replies are sent over the connection the command was received from,
+	// so they never arrive once the client reconnects.
+	reconnected := c.reconnectedChan()
+
+	ref, err := c.sendCommandWithCallback(ctx, name, func(ctx context.Context, m *ws.Message) {
+		select {
+		case replies <- m:
+		default:
+		}
+	}, body...)
+	if err != nil {
+		return nil, err
+	}
+	defer c.unsubscribe(ref)
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.ctx.Done():
+		if err := c.Err(); err != nil {
+			return nil, fmt.Errorf("client error: %w", err)
+		}
+
+		return nil, errors.New("client is closed")
+	case <-reconnected:
+		return nil, ErrConnectionLost
+	case reply := <-replies:
+		if reply.Name == "error" {
+			return nil, parseErrorFromMessage(reply)
+		}
+
+		return reply, nil
+	}
+}
+
+func (c *Client) writeCommand(message *ws.Message) error {
+	c.logDebug("-> send:", message.Ref, message.Name, message.MustText())
+
+	codec := c.getCodec()
+
+	bytes, err := codec.EncodeMessage(message)
+	if err != nil {
+		return err
+	}
+
+	return c.getConn().Write(c.ctx, codec.MessageType(), bytes)
+}
+
+func updateFromBytes(codec ws.Codec, bytes []byte) (*ws.Message, error) {
+	message, err := codec.DecodeMessage(bytes)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal message: %w", err)
 	}
 
-	if message.Type != ws.Update {
+	if message == nil || message.Type != ws.Update {
 		return nil, errors.New("message is not an update")
 	}
 
@@ -56,12 +135,18 @@ func updateFromBytes(bytes []byte) (*ws.Message, error) {
 }
 
 func (c *Client) readUpdate() (*ws.Message, error) {
-	_, bytes, err := c.conn.Read(c.ctx)
+	conn, codec := c.getConnAndCodec()
+
+	typ, bytes, err := conn.Read(c.ctx)
 	if err != nil {
 		return nil, fmt.Errorf("connection error: %w", err)
 	}
 
-	msg, err := updateFromBytes(bytes)
+	if typ != codec.MessageType() {
+		return nil, fmt.Errorf("unexpected %s message with %s subprotocol", typ, codec.Subprotocol())
+	}
+
+	msg, err := updateFromBytes(codec, bytes)
 	if err != nil {
 		return nil, err
 	}
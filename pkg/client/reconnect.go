@@ -0,0 +1,157 @@
+package robocat
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+	"nhooyr.io/websocket"
+)
+
+// Policy for reconnecting to the server after the connection was lost.
+type ReconnectPolicy struct {
+	// Max number of attempts before giving up (0 means unlimited).
+	MaxAttempts int
+	// Delay before the first attempt (500ms by default).
+	InitialDelay time.Duration
+	// Max delay between attempts (30s by default).
+	MaxDelay time.Duration
+	// Factor the delay is multiplied by after each attempt (2 by default).
+	Multiplier float64
+	// Fraction of the delay that is randomized, from 0 to 1.
+	Jitter float64
+}
+
+// Default reconnect policy with exponential backoff and 10 attempts.
+func DefaultReconnectPolicy() *ReconnectPolicy {
+	return &ReconnectPolicy{
+		MaxAttempts:  10,
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     30 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
+
+// Delay before the given attempt (starting from 1).
+func (p *ReconnectPolicy) Delay(attempt int) time.Duration {
+	initialDelay := p.InitialDelay
+	if initialDelay <= 0 {
+		initialDelay = 500 * time.Millisecond
+	}
+
+	maxDelay := p.MaxDelay
+	if maxDelay <= 0 {
+		maxDelay = 30 * time.Second
+	}
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+
+	delay := float64(initialDelay) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		delay = delay * (1 - jitter + 2*jitter*rand.Float64())
+	}
+
+	return time.Duration(delay)
+}
+
+// Enable automatic reconnect using the given policy (nil disables reconnect).
+// After reconnect the client re-attaches to flows that are still running,
+// so their logs and files continue streaming.
+func (c *Client) SetReconnectPolicy(policy *ReconnectPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.reconnect = policy
+}
+
+func (c *Client) getReconnectPolicy() *ReconnectPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.reconnect
+}
+
+// Channel closed once the client reconnects.
+func (c *Client) reconnectedChan() <-chan struct{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.reconnected
+}
+
+func (c *Client) reconnectWithBackoff(cause error) error {
+	policy := c.getReconnectPolicy()
+
+	for attempt := 1; policy.MaxAttempts == 0 || attempt <= policy.MaxAttempts; attempt++ {
+		delay := policy.Delay(attempt)
+
+		c.logDebug(fmt.Sprintf("reconnecting in %s (attempt %d)...", delay, attempt))
+
+		select {
+		case <-c.ctx.Done():
+			return c.ctx.Err()
+		case <-time.After(delay):
+		}
+
+		conn, err := c.dial()
+		if err != nil {
+			c.logError(fmt.Errorf("reconnect attempt %d failed: %w", attempt, err))
+			continue
+		}
+
+		c.mu.Lock()
+		previous := c.conn
+		c.conn = conn
+		c.codec = ws.CodecForSubprotocol(conn.Subprotocol())
+		reconnected := c.reconnected
+		c.reconnected = make(chan struct{})
+		c.mu.Unlock()
+
+		previous.Close(websocket.StatusNormalClosure, "")
+		close(reconnected)
+
+		c.logDebug("reconnected")
+
+		go c.reattachFlows()
+
+		return nil
+	}
+
+	return fmt.Errorf("unable to reconnect: %w", cause)
+}
+
+// Ask the server to resume streaming updates of flows that are still running
+// and replay updates that were missed while the client was disconnected.
+func (c *Client) reattachFlows() {
+	for _, flow := range c.trackedFlows() {
+		flow := flow
+
+		_, err := c.sendCommandWithCallback(
+			flow.ctx,
+			"attach",
+			func(ctx context.Context, m *ws.Message) {
+				if m.Name == "error" {
+					flow.abort(fmt.Errorf("unable to re-attach: %w", parseErrorFromMessage(m)))
+				}
+
+				c.unsubscribe(m.Ref)
+			},
+			ws.AttachArguments{Ref: flow.ref, Seq: flow.lastSeq()},
+		)
+		if err != nil {
+			flow.abort(fmt.Errorf("unable to re-attach: %w", err))
+		}
+	}
+}
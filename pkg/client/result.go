@@ -0,0 +1,50 @@
+package robocat
+
+import (
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+)
+
+// Structured result of a finished flow run.
+type Result struct {
+	// Exit code of the flow process (-1 if the process was killed by signal
+	// or has not exited).
+	ExitCode int
+	// Signal that killed the process (if any).
+	Signal     string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Duration   time.Duration
+	// Reason the run has ended for (i.e. "success", "error", "client-stop",
+	// "timeout", "watchdog").
+	Reason string
+	// Number of log lines produced by the flow.
+	Logs int
+	// Number of output files sent by the flow.
+	Files int
+}
+
+func parseResultFromMessage(m *ws.Message) (*Result, error) {
+	var result *ws.RunResult
+
+	err := m.Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	return newResult(result), nil
+}
+
+func newResult(result *ws.RunResult) *Result {
+	return &Result{
+		ExitCode:   result.ExitCode,
+		Signal:     result.Signal,
+		StartedAt:  result.StartedAt,
+		FinishedAt: result.FinishedAt,
+		Duration:   result.Duration,
+		Reason:     string(result.Reason),
+		Logs:       result.Logs,
+		Files:      result.Files,
+	}
+}
@@ -0,0 +1,112 @@
+package robocat
+
+import (
+	"context"
+	"time"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+)
+
+// Time to wait for the reply to "hello" command. Servers speaking protocol
+// version 1 ignore unknown commands, so there might be no reply at all.
+const helloTimeout = 2 * time.Second
+
+// Information about the server reported during handshake.
+type ServerInfo struct {
+	// Version of the protocol (1 for servers without handshake support).
+	Protocol int
+	// Build version of robocat (empty for older servers).
+	Version string
+	// Subprotocols supported by the server.
+	Subprotocols []string
+	// Name and version of the executor running flows (i.e. "tagui").
+	Executor        string
+	ExecutorVersion string
+	// Max size of a single message accepted by the server.
+	MaxMessageSize int64
+	// Commands supported by the server.
+	Commands []string
+	// Optional protocol features supported by the server (i.e. "attach").
+	Features []string
+}
+
+// Check if the server supports the command.
+func (i *ServerInfo) HasCommand(name string) bool {
+	return contains(i.Commands, name)
+}
+
+// Check if the server supports the feature.
+func (i *ServerInfo) HasFeature(name string) bool {
+	return contains(i.Features, name)
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Info assumed for servers that do not support handshake.
+func legacyServerInfo() *ServerInfo {
+	return &ServerInfo{
+		Protocol:     1,
+		Subprotocols: []string{ws.SubprotocolJSON},
+		Commands:     []string{"ping", "run", "stop", "input"},
+	}
+}
+
+// Exchange "hello" with the server to learn what it supports.
+func (c *Client) handshake() {
+	defer close(c.infoReady)
+
+	c.info = legacyServerInfo()
+
+	ctx, cancel := context.WithTimeout(c.ctx, helloTimeout)
+	defer cancel()
+
+	m, err := c.request(ctx, "hello")
+	if err != nil {
+		c.logDebug("handshake failed, assuming legacy server:", err)
+		return
+	}
+
+	var info *ws.ServerInfo
+
+	err = m.Decode(&info)
+	if err != nil || info == nil {
+		c.logError("unable to parse server info:", err)
+		return
+	}
+
+	c.info = &ServerInfo{
+		Protocol:        info.Protocol,
+		Version:         info.Version,
+		Subprotocols:    info.Subprotocols,
+		Executor:        info.Executor,
+		ExecutorVersion: info.ExecutorVersion,
+		MaxMessageSize:  info.MaxMessageSize,
+		Commands:        info.Commands,
+		Features:        info.Features,
+	}
+}
+
+// Information about the server. Blocks until the handshake is finished.
+func (c *Client) ServerInfo() *ServerInfo {
+	<-c.infoReady
+	return c.info
+}
+
+// Information about the server - waits for the handshake to finish until
+// the context is done.
+func (c *Client) ServerInfoContext(ctx context.Context) (*ServerInfo, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.infoReady:
+		return c.info, nil
+	}
+}
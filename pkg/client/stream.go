@@ -6,28 +6,71 @@ import (
 )
 
 type RobocatStream[T any] struct {
-	channel      chan T
-	closed       bool
-	waitForWrite sync.WaitGroup
+	mu      sync.Mutex
+	channel chan T
+	pending []T
+	notify  chan struct{}
+	closed  bool
 }
 
-func (s *RobocatStream[T]) ensureChannel() chan T {
-	if s.channel == nil {
-		s.channel = make(chan T)
+// Lazily initialize the stream and start delivering pending items to the
+// channel. Must be called with mutex locked.
+func (s *RobocatStream[T]) init() {
+	if s.channel != nil {
+		return
 	}
 
-	return s.channel
+	s.channel = make(chan T)
+	s.notify = make(chan struct{}, 1)
+
+	go s.deliver()
+}
+
+func (s *RobocatStream[T]) deliver() {
+	for {
+		s.mu.Lock()
+
+		if len(s.pending) == 0 {
+			if s.closed {
+				close(s.channel)
+				s.mu.Unlock()
+				return
+			}
+
+			s.mu.Unlock()
+			<-s.notify
+			continue
+		}
+
+		item := s.pending[0]
+		s.pending = s.pending[1:]
+
+		s.mu.Unlock()
+
+		s.channel <- item
+	}
+}
+
+func (s *RobocatStream[T]) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
 }
 
-// Append a new item to the stream.
+// Append a new item to the stream. Items are buffered, so pushing never
+// blocks even if nobody is reading the stream.
 func (s *RobocatStream[T]) Push(item T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.closed {
 		return errors.New("stream channel is closed")
 	}
 
-	s.waitForWrite.Add(1)
-	s.ensureChannel() <- item
-	s.waitForWrite.Done()
+	s.init()
+	s.pending = append(s.pending, item)
+	s.wake()
 
 	return nil
 }
@@ -47,12 +90,20 @@ func (s *RobocatStream[T]) Watch(callback func(item T)) {
 
 // Get read-only channel with stream items.
 func (s *RobocatStream[T]) Channel() <-chan T {
-	return s.ensureChannel()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
+
+	return s.channel
 }
 
-// Mark stream as closed.
+// Mark stream as closed. Channel is closed once all pending items are read.
 func (s *RobocatStream[T]) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.init()
 	s.closed = true
-	s.waitForWrite.Wait()
-	close(s.ensureChannel())
+	s.wake()
 }
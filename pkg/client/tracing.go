@@ -0,0 +1,26 @@
+package robocat
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Name of the tracer used for client spans. Spans are recorded with the
+// global tracer provider, so they are only exported when the application
+// has configured one.
+const tracerName = "github.com/robocat-ai/robocat/pkg/client"
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// End the span marking it as failed when there is an error.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
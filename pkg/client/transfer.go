@@ -0,0 +1,87 @@
+package robocat
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/robocat-ai/robocat/internal/ws"
+)
+
+// Output file being received in chunks.
+type outputTransfer struct {
+	begin   ws.TransferBegin
+	payload bytes.Buffer
+}
+
+func (f *RobocatFlow) beginTransfer(m *ws.Message) error {
+	var begin ws.TransferBegin
+
+	err := m.Decode(&begin)
+	if err != nil {
+		return fmt.Errorf("unable to parse output transfer: %w", err)
+	}
+
+	if f.transfers == nil {
+		f.transfers = make(map[string]*outputTransfer)
+	}
+
+	transfer := &outputTransfer{begin: begin}
+	transfer.payload.Grow(int(begin.Size))
+
+	f.transfers[begin.ID] = transfer
+
+	return nil
+}
+
+func (f *RobocatFlow) receiveChunk(m *ws.Message) error {
+	var chunk ws.TransferChunk
+
+	err := m.Decode(&chunk)
+	if err != nil {
+		return fmt.Errorf("unable to parse output chunk: %w", err)
+	}
+
+	transfer, ok := f.transfers[chunk.ID]
+	if !ok {
+		return fmt.Errorf("got chunk of unknown output transfer '%s'", chunk.ID)
+	}
+
+	if chunk.Offset != int64(transfer.payload.Len()) {
+		return fmt.Errorf(
+			"got chunk of '%s' at offset %d, expected %d",
+			transfer.begin.Path, chunk.Offset, transfer.payload.Len(),
+		)
+	}
+
+	transfer.payload.Write(chunk.Payload)
+
+	return nil
+}
+
+func (f *RobocatFlow) endTransfer(m *ws.Message) (*File, error) {
+	var end ws.TransferEnd
+
+	err := m.Decode(&end)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse output transfer end: %w", err)
+	}
+
+	transfer, ok := f.transfers[end.ID]
+	if !ok {
+		return nil, fmt.Errorf("got end of unknown output transfer '%s'", end.ID)
+	}
+
+	delete(f.transfers, end.ID)
+
+	payload := transfer.payload.Bytes()
+
+	if ws.Checksum(payload) != end.Checksum {
+		return nil, fmt.Errorf("output '%s': %w", transfer.begin.Path, ErrChecksumMismatch)
+	}
+
+	return &File{
+		Path:     transfer.begin.Path,
+		MimeType: transfer.begin.MimeType,
+		Payload:  payload,
+	}, nil
+}